@@ -1,11 +1,19 @@
 package kapsule
 
-type Image struct {
-	Modelfile string
-	Context   string
-	Name      string
-}
+import (
+	"context"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/nicholasjackson/kapsule/builder"
+)
+
+// Build creates an image from the given modelfile, paths referenced
+// in the modelfile are resolved relative to context
+func Build(ctx context.Context, modelfile, context string, opts ...Option) (v1.Image, error) {
+	o := newOptions(opts)
+
+	o.logger.Info("Building image", "modelfile", modelfile, "context", context)
 
-func (i *Image) Build(output string) error {
-	return nil
+	b := builder.NewBuilder()
+	return b.Build(ctx, modelfile, context)
 }
@@ -3,11 +3,15 @@ package builder
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"strconv"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
@@ -21,8 +25,9 @@ import (
 //
 //go:generate mockery --name Builder
 type Builder interface {
-	// Build an image using the given modelfile path and write to the output path
-	Build(model, context string) (v1.Image, error)
+	// Build an image using the given modelfile path, files referenced in the
+	// modelfile are resolved relative to the context path
+	Build(ctx context.Context, model, context string) (v1.Image, error)
 }
 
 // BuilderImpl is a concrete implementation of the Builder interface
@@ -36,20 +41,43 @@ func NewBuilder() Builder {
 	}
 }
 
-func (b *BuilderImpl) Build(model, context string) (v1.Image, error) {
-	base := empty.Image
+func (b *BuilderImpl) Build(ctx context.Context, model, context string) (v1.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// parse the modelfile
 	mf, err := b.parser.Parse(model)
 	if err != nil {
-		return nil, fmt.Errorf("unable to load modelfile: %s", err)
+		kind := types.ErrorKindParse
+		if errors.Is(err, os.ErrNotExist) {
+			kind = types.ErrorKindNotFound
+		}
+
+		return nil, types.Errorf(kind, "unable to load modelfile: %w", err)
+	}
+
+	// the creation time and labels are stored in the config so that images
+	// can be listed and filtered without reading the layers
+	base, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{
+		Created: v1.Time{Time: buildTime()},
+		Config:  v1.Config{Labels: mf.Labels},
+		RootFS:  v1.RootFS{Type: "layers"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create config: %s", err)
 	}
 
 	// add the model in FROM
 	fPath := path.Join(context, mf.From)
 	f, err := os.Open(fPath)
 	if err != nil {
-		return nil, fmt.Errorf("unable to find file: %s defined in FROM: %s", mf.From, err)
+		kind := types.ErrorKindIO
+		if errors.Is(err, os.ErrNotExist) {
+			kind = types.ErrorKindNotFound
+		}
+
+		return nil, types.Errorf(kind, "unable to find file: %s defined in FROM: %w", mf.From, err)
 	}
 
 	fromLayer := stream.NewLayer(
@@ -76,6 +104,19 @@ func (b *BuilderImpl) Build(model, context string) (v1.Image, error) {
 		}
 	}
 
+	if mf.System != "" {
+		systemLayer := stream.NewLayer(
+			io.NopCloser(bytes.NewReader([]byte(mf.System))),
+			stream.WithCompressionLevel(gzip.DefaultCompression),
+			stream.WithMediaType(types.KAPSULE_MEDIA_TYPE_SYSTEM),
+		)
+
+		image, err = mutate.AppendLayers(image, systemLayer)
+		if err != nil {
+			return nil, fmt.Errorf("unable add SYSTEM layer: %s", err)
+		}
+	}
+
 	if len(mf.Parameters) > 0 {
 		jp, err := json.Marshal(mf.Parameters)
 		if err != nil {
@@ -96,3 +137,16 @@ func (b *BuilderImpl) Build(model, context string) (v1.Image, error) {
 
 	return image, nil
 }
+
+// buildTime returns the creation time of the image, builds of the same
+// source produce the same image so the Unix epoch is used unless
+// SOURCE_DATE_EPOCH is set
+func buildTime() time.Time {
+	if e := os.Getenv("SOURCE_DATE_EPOCH"); e != "" {
+		if sec, err := strconv.ParseInt(e, 10, 64); err == nil {
+			return time.Unix(sec, 0).UTC()
+		}
+	}
+
+	return time.Unix(0, 0).UTC()
+}
@@ -2,6 +2,7 @@ package builder
 
 import (
 	"compress/gzip"
+	"context"
 	"io"
 	"os"
 	"path"
@@ -30,6 +31,7 @@ func setupBuilder(t *testing.T) (builder Builder, mockParser *pm.Parser, context
 	model := &modelfile.ModelFile{
 		From:       "./model.gguf",
 		Template:   "[Inst] Something [/Inst]",
+		System:     "You are a helpful assistant",
 		Parameters: map[string][]string{"a": {"1"}, "b": {"2"}},
 	}
 
@@ -44,7 +46,7 @@ func setupBuilder(t *testing.T) (builder Builder, mockParser *pm.Parser, context
 func TestBuildLoadsModelFile(t *testing.T) {
 	b, mb, ctx, _ := setupBuilder(t)
 
-	_, err := b.Build("./blah.modelfile", ctx)
+	_, err := b.Build(context.Background(), "./blah.modelfile", ctx)
 	require.NoError(t, err)
 
 	mb.AssertCalled(t, "Parse", "./blah.modelfile")
@@ -53,7 +55,7 @@ func TestBuildLoadsModelFile(t *testing.T) {
 func TestBuildAddsModelLayer(t *testing.T) {
 	b, _, ctx, _ := setupBuilder(t)
 
-	img, err := b.Build("./blah.modelfile", ctx)
+	img, err := b.Build(context.Background(), "./blah.modelfile", ctx)
 	require.NoError(t, err)
 	require.NotNil(t, img)
 
@@ -77,7 +79,7 @@ func TestBuildAddsModelLayer(t *testing.T) {
 func TestBuildAddsTemplateLayer(t *testing.T) {
 	b, _, ctx, _ := setupBuilder(t)
 
-	img, err := b.Build("./blah.modelfile", ctx)
+	img, err := b.Build(context.Background(), "./blah.modelfile", ctx)
 	require.NoError(t, err)
 	require.NotNil(t, img)
 
@@ -98,17 +100,17 @@ func TestBuildAddsTemplateLayer(t *testing.T) {
 	require.Equal(t, "[Inst] Something [/Inst]", string(d))
 }
 
-func TestBuildAddsParametersLayer(t *testing.T) {
+func TestBuildAddsSystemLayer(t *testing.T) {
 	b, _, ctx, _ := setupBuilder(t)
 
-	img, err := b.Build("./blah.modelfile", ctx)
+	img, err := b.Build(context.Background(), "./blah.modelfile", ctx)
 	require.NoError(t, err)
 	require.NotNil(t, img)
 
 	fl, _ := img.Layers()
 
 	mt, _ := fl[2].MediaType()
-	require.Equal(t, types.MediaType(kt.KAPSULE_MEDIA_TYPE_PARAMETERS), mt)
+	require.Equal(t, types.MediaType(kt.KAPSULE_MEDIA_TYPE_SYSTEM), mt)
 
 	rc, err := fl[2].Compressed()
 	require.NoError(t, err)
@@ -117,7 +119,83 @@ func TestBuildAddsParametersLayer(t *testing.T) {
 	gzr, err := gzip.NewReader(rc)
 	require.NoError(t, err)
 
+	d, err := io.ReadAll(gzr)
+	require.NoError(t, err)
+	require.Equal(t, "You are a helpful assistant", string(d))
+}
+
+func TestBuildAddsParametersLayer(t *testing.T) {
+	b, _, ctx, _ := setupBuilder(t)
+
+	img, err := b.Build(context.Background(), "./blah.modelfile", ctx)
+	require.NoError(t, err)
+	require.NotNil(t, img)
+
+	fl, _ := img.Layers()
+
+	mt, _ := fl[3].MediaType()
+	require.Equal(t, types.MediaType(kt.KAPSULE_MEDIA_TYPE_PARAMETERS), mt)
+
+	rc, err := fl[3].Compressed()
+	require.NoError(t, err)
+
+	// uncompress the reader
+	gzr, err := gzip.NewReader(rc)
+	require.NoError(t, err)
+
 	d, err := io.ReadAll(gzr)
 	require.NoError(t, err)
 	require.JSONEq(t, string(d), `{"a": ["1"], "b": ["2"]}`)
 }
+
+func TestBuildAddsLabelsAndCreatedToConfig(t *testing.T) {
+	_, _, ctx, _ := setupBuilder(t)
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	mp := &pm.Parser{}
+	mp.On("Parse", mock.Anything).Return(&modelfile.ModelFile{
+		From:   "./model.gguf",
+		Labels: map[string]string{"quantization": "Q4_K_M"},
+	}, nil)
+
+	b := &BuilderImpl{mp}
+
+	img, err := b.Build(context.Background(), "./blah.modelfile", ctx)
+	require.NoError(t, err)
+
+	// the diff ids in the config are only known once the layers are read
+	fl, _ := img.Layers()
+	rc, err := fl[0].Compressed()
+	require.NoError(t, err)
+	io.Copy(io.Discard, rc)
+	rc.Close()
+
+	cf, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, "Q4_K_M", cf.Config.Labels["quantization"])
+	require.Equal(t, int64(1700000000), cf.Created.Unix())
+}
+
+func TestBuildUsesEpochAsCreatedByDefault(t *testing.T) {
+	_, _, ctx, _ := setupBuilder(t)
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	mp := &pm.Parser{}
+	mp.On("Parse", mock.Anything).Return(&modelfile.ModelFile{From: "./model.gguf"}, nil)
+
+	b := &BuilderImpl{mp}
+
+	img, err := b.Build(context.Background(), "./blah.modelfile", ctx)
+	require.NoError(t, err)
+
+	// the diff ids in the config are only known once the layers are read
+	fl, _ := img.Layers()
+	rc, err := fl[0].Compressed()
+	require.NoError(t, err)
+	io.Copy(io.Discard, rc)
+	rc.Close()
+
+	cf, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), cf.Created.Unix())
+}
@@ -2,29 +2,47 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
 
 // Builder is an autogenerated mock type for the Builder type
 type Builder struct {
 	mock.Mock
 }
 
-// Build provides a mock function with given fields: model, output
-func (_m *Builder) Build(model string, output string) error {
-	ret := _m.Called(model, output)
+// Build provides a mock function with given fields: ctx, model, _a2
+func (_m *Builder) Build(ctx context.Context, model string, _a2 string) (v1.Image, error) {
+	ret := _m.Called(ctx, model, _a2)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Build")
 	}
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = rf(model, output)
+	var r0 v1.Image
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (v1.Image, error)); ok {
+		return rf(ctx, model, _a2)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) v1.Image); ok {
+		r0 = rf(ctx, model, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(v1.Image)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, model, _a2)
 	} else {
-		r0 = ret.Error(0)
+		r1 = ret.Error(1)
 	}
 
-	return r0
+	return r0, r1
 }
 
 // NewBuilder creates a new instance of Builder. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
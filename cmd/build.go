@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/charmbracelet/log"
@@ -13,17 +14,25 @@ var modelFile string
 var tag string
 var outputFormat string
 var outputFolder string
+var ollamaHost string
 var insecure bool
+var plainHTTP bool
+var caCert string
+var clientCert string
+var clientKey string
 var registryUsername string
 var registryPassword string
-var encryptionKey string
+var encryptionKeys []string
 var decryptionKey string
 var encryptionVaultPath string
-var encryptionVaultKey string
+var encryptionVaultKeys []string
 var encryptionVaultAuthToken string
 var encryptionVaultAuthAddr string
 var encryptionVaultAuthNamespace string
+var encryptLayers []string
 var unzip bool
+var jobs int
+var progressFormat string
 var debug bool
 
 func newBuildCmd() *cobra.Command {
@@ -33,8 +42,8 @@ func newBuildCmd() *cobra.Command {
 		Long: `
 			Builds an OCI image for a model using the specified context and output format.
 			`,
-		Args: cobra.MatchAll(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
-		Run: func(cmd *cobra.Command, args []string) {
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := log.New(os.Stdout)
 			logger.SetReportTimestamp(false)
 
@@ -45,106 +54,142 @@ func newBuildCmd() *cobra.Command {
 			// are we using encryption, if so build the key provider
 			kp, err := getKeyProvider(
 				logger,
-				encryptionKey,
+				encryptionKeys,
 				decryptionKey,
-				encryptionVaultKey,
+				encryptionVaultKeys,
 				encryptionVaultPath,
 				encryptionVaultAuthToken,
 				encryptionVaultAuthAddr,
 				encryptionVaultAuthNamespace)
 
 			if err != nil {
-				log.Error("Failed to create key provider", "error", err)
-				return
+				return &usageError{fmt.Errorf("failed to create key provider: %w", err)}
 			}
 
+			// the progress of each layer is reported as it is written
+			rep, err := getProgressReporter(logger)
+			if err != nil {
+				return err
+			}
+			defer rep.Close()
+
 			decrypt := false
-			if decryptionKey != "" || encryptionVaultKey != "" {
+			if decryptionKey != "" || len(encryptionVaultKeys) > 0 {
 				decrypt = true
 			}
 
 			encrypt := false
-			if encryptionKey != "" || encryptionVaultKey != "" {
+			if len(encryptionKeys) > 0 || len(encryptionVaultKeys) > 0 {
 				encrypt = true
 			}
 
-			ctx := args[0]
+			layers, err := getEncryptLayers(encrypt)
+			if err != nil {
+				return err
+			}
+
+			buildContext := args[0]
 
-			logger.Info("Building image", "modelfile", modelFile, "context", ctx, "output", outputFolder, "format", outputFormat, "tag", tag)
+			logger.Info("Building image", "modelfile", modelFile, "context", buildContext, "output", outputFolder, "format", outputFormat, "tag", tag)
 
 			b := builder.NewBuilder()
-			i, err := b.Build(modelFile, ctx)
+			i, err := b.Build(cmd.Context(), modelFile, buildContext)
 			if err != nil {
-				log.Error("Failed to build image", "error", err)
-				return
+				return fmt.Errorf("failed to build image: %w", err)
 			}
 
 			// write the image to the output
 			switch outputFormat {
 			case "ollama":
 				if outputFolder == "" {
-					log.Error("Output folder '--output-folder' must be specified for Ollama format")
-					return
+					return &usageError{fmt.Errorf("output folder '--output' must be specified for Ollama format")}
 				}
 
 				w := writer.NewOllamaWriter(logger, kp, outputFolder)
-				err := w.Write(i, tag, decrypt, unzip)
+				w.SetJobs(jobs)
+				w.SetProgress(rep)
+				err := w.Write(cmd.Context(), i, tag, decrypt, unzip)
 				if err != nil {
-					log.Error("Failed to write image to ollama", "path", outputFolder, "error", err)
-					return
+					return fmt.Errorf("failed to write image to ollama at %s: %w", outputFolder, err)
+				}
+			case "ollama-api":
+				w := writer.NewOllamaAPIWriter(logger, kp, ollamaHost)
+				w.SetJobs(jobs)
+				w.SetProgress(rep)
+				w.SetTempDir(cacheDir)
+				err := w.Write(cmd.Context(), i, tag, decrypt, unzip)
+				if err != nil {
+					return fmt.Errorf("failed to create model on ollama server at %s: %w", ollamaHost, err)
 				}
 			case "oci":
 				if outputFolder != "" {
 					w := writer.NewPathWriter(logger, kp, outputFolder)
+					w.SetJobs(jobs)
+					w.SetProgress(rep)
+					w.SetEncryptLayers(layers)
 
 					var err error
 					if encrypt {
-						err = w.WriteEncrypted(i, tag)
+						err = w.WriteEncrypted(cmd.Context(), i, tag)
 					} else {
-						err = w.Write(i, tag, decrypt, unzip)
+						err = w.Write(cmd.Context(), i, tag, decrypt, unzip)
 					}
 
 					if err != nil {
-						log.Error("Failed to write image to path", "path", outputFolder, "error", err)
-						return
+						return fmt.Errorf("failed to write image to path %s: %w", outputFolder, err)
 					}
 				} else {
-					w := writer.NewOCIRegistry(logger, kp, registryUsername, registryPassword, insecure)
+					ro, err := getRegistryOptions()
+					if err != nil {
+						return err
+					}
+
+					w := writer.NewOCIRegistry(logger, kp, ro)
+					w.SetJobs(jobs)
+					w.SetProgress(rep)
+					w.SetEncryptLayers(layers)
 
-					var err error
 					if encrypt {
-						err = w.WriteEncrypted(i, tag)
+						err = w.WriteEncrypted(cmd.Context(), i, tag)
 					} else {
-						err = w.Write(i, tag, decrypt, unzip)
+						err = w.Write(cmd.Context(), i, tag, decrypt, unzip)
 					}
 
 					if err != nil {
-						log.Error("Failed to push image to remote registry", "error", err)
-						return
+						return fmt.Errorf("failed to push image to remote registry: %w", err)
 					}
 				}
 			default:
-				log.Error("Unsupported format", "format", outputFormat)
-				return
+				return &usageError{fmt.Errorf("unsupported format: %s", outputFormat)}
 			}
+
+			return nil
 		},
 	}
 
 	buildCmd.Flags().StringVarP(&modelFile, "file", "f", "ModelFile", "Specify the model file for the build")
 	buildCmd.Flags().StringVarP(&tag, "tag", "t", "", "Specify the tag for the built image i.e. docker.io/nicholasjackson/llm_test:latest")
-	buildCmd.Flags().StringVarP(&outputFormat, "format", "", "oci", "Specify the output format for the built image, defaults to OCI image format, options: [ollama, oci]")
+	buildCmd.Flags().StringVarP(&outputFormat, "format", "", "oci", "Specify the output format for the built image, defaults to OCI image format, options: [ollama, ollama-api, oci]")
 	buildCmd.Flags().StringVarP(&outputFolder, "output", "o", "", "Specify the output folder for the built image, if not specified the image will be pushed to a remote registry")
-	buildCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Push to an insecure registry")
+	buildCmd.Flags().StringVarP(&ollamaHost, "ollama-host", "", writer.OllamaAddress(), "Specify the address of the Ollama server used by the ollama-api format")
+	buildCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	buildCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	buildCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	buildCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	buildCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
 	buildCmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registry")
-	buildCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry")
-	buildCmd.Flags().StringVarP(&encryptionKey, "encryption-key", "", "", "The encryption key to use for encrypting the image, RSA public key")
+	buildCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry, prefer kapsule login as the password is visible in the shell history")
+	buildCmd.Flags().StringArrayVarP(&encryptionKeys, "encryption-key", "", nil, "The encryption key to use for encrypting the image, RSA public key, repeat to encrypt the image for several recipients")
 	buildCmd.Flags().StringVarP(&decryptionKey, "decryption-key", "", "", "The decryption key to use for encrypting the image, RSA private key")
 	buildCmd.Flags().StringVarP(&encryptionVaultPath, "encryption-vault-path", "", "", "The path to the transit secrets endpoint for encrypting and decryupting the image")
-	buildCmd.Flags().StringVarP(&encryptionVaultKey, "encryption-vault-key", "", "", "The name of exportable encryption key in Vault to use for encrypting and decrypting the image")
+	buildCmd.Flags().StringArrayVarP(&encryptionVaultKeys, "encryption-vault-key", "", nil, "The name of exportable encryption key in Vault to use for encrypting and decrypting the image, repeat to encrypt the image for several recipients")
 	buildCmd.Flags().StringVarP(&encryptionVaultAuthToken, "encryption-vault-auth-token", "", "", "The vault token to use for accessing the encryption key")
 	buildCmd.Flags().StringVarP(&encryptionVaultAuthAddr, "encryption-vault-addr", "", "", "The address of the vault server to use for accessing the encryption key")
 	buildCmd.Flags().StringVarP(&encryptionVaultAuthNamespace, "encryption-vault-namespace", "", "", "The namespace for the vault server to use for accessing the encryption key")
+	buildCmd.Flags().StringSliceVarP(&encryptLayers, "encrypt-layers", "", nil, "Only encrypt the given layers, options: [model, adapter, template, system, params, licence] or a media type, defaults to all layers")
 	buildCmd.Flags().BoolVarP(&unzip, "unzip", "", true, "Uncompresses layers when writing to disk")
+	buildCmd.Flags().IntVarP(&jobs, "jobs", "j", writer.DefaultJobs, "Specify the number of layers that are fetched, decrypted and written concurrently")
+	buildCmd.Flags().StringVarP(&progressFormat, "progress", "", "auto", "Specify how the progress of each layer is reported, options: [auto, bar, log, json, none]")
 	buildCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
 
 	return buildCmd
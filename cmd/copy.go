@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/writer"
+	"github.com/spf13/cobra"
+)
+
+var decryptionVaultKeys []string
+
+func newCopyCmd() *cobra.Command {
+	copyCmd := &cobra.Command{
+		Use:   "copy <src> <dst>",
+		Short: "Copy an image from one remote registry to another",
+		Long: `
+			Copies an image between remote registries without writing it to disk, the layers are
+			streamed from the source to the destination. Blobs that already exist at the destination
+			are skipped and when both images are in the same registry the blobs are mounted from the
+			source repository rather than uploaded.
+
+			Layers are decrypted on the way when --decryption-key or --decryption-vault-key is set and
+			encrypted for a new recipient when --encryption-key or --encryption-vault-key is set, to
+			re-encrypt an encrypted image set both.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.New(os.Stdout)
+			logger.SetReportTimestamp(false)
+
+			if debug {
+				logger.SetLevel(log.DebugLevel)
+			}
+
+			src, dst := args[0], args[1]
+
+			// the source is decrypted and the copy encrypted with separate
+			// keys so that the image can be re-encrypted for a new recipient
+			dkp, ekp, err := getCopyKeyProviders(logger)
+			if err != nil {
+				return &usageError{fmt.Errorf("failed to create key provider: %w", err)}
+			}
+
+			// the progress of each layer is reported as it is written
+			rep, err := getProgressReporter(logger)
+			if err != nil {
+				return err
+			}
+			defer rep.Close()
+
+			encrypt := len(encryptionKeys) > 0 || len(encryptionVaultKeys) > 0
+
+			layers, err := getEncryptLayers(encrypt)
+			if err != nil {
+				return err
+			}
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			opts := append(getOptions(logger, ro), kapsule.WithJobs(jobs), kapsule.WithProgress(rep))
+
+			if decryptionKey != "" || len(decryptionVaultKeys) > 0 {
+				opts = append(opts, kapsule.WithDecryption(dkp))
+			}
+
+			if encrypt {
+				opts = append(opts, kapsule.WithEncryption(ekp), kapsule.WithEncryptLayers(layers...))
+			}
+
+			err = kapsule.Copy(cmd.Context(), src, dst, opts...)
+			if err != nil {
+				return fmt.Errorf("failed to copy image: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	copyCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registries")
+	copyCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registries using plain HTTP rather than HTTPS")
+	copyCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registries")
+	copyCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	copyCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
+	copyCmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registries")
+	copyCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registries, prefer kapsule login as the password is visible in the shell history")
+	copyCmd.Flags().StringArrayVarP(&encryptionKeys, "encryption-key", "", nil, "The encryption key to use for encrypting the copied image, RSA public key, repeat to encrypt the image for several recipients")
+	copyCmd.Flags().StringVarP(&decryptionKey, "decryption-key", "", "", "The decryption key to use for decrypting the source image, RSA private key")
+	copyCmd.Flags().StringVarP(&encryptionVaultPath, "encryption-vault-path", "", "", "The path for the transit secrets engine in vault to use for encrypting and decrypting the image")
+	copyCmd.Flags().StringArrayVarP(&encryptionVaultKeys, "encryption-vault-key", "", nil, "The name of the key in vault to use for encrypting the copied image, repeat to encrypt the image for several recipients")
+	copyCmd.Flags().StringArrayVarP(&decryptionVaultKeys, "decryption-vault-key", "", nil, "The name of the key in vault to use for decrypting the source image, repeat to try several keys")
+	copyCmd.Flags().StringVarP(&encryptionVaultAuthToken, "encryption-vault-auth-token", "", "", "The vault token to use for accessing the encryption and decryption key")
+	copyCmd.Flags().StringVarP(&encryptionVaultAuthAddr, "encryption-vault-addr", "", "", "The address of the vault server to use for accessing the encryption / decryption key")
+	copyCmd.Flags().StringVarP(&encryptionVaultAuthNamespace, "encryption-vault-namespace", "", "", "The namespace for the vault server to use for accessing the encryption key")
+	copyCmd.Flags().StringSliceVarP(&encryptLayers, "encrypt-layers", "", nil, "Only encrypt the given layers, options: [model, adapter, template, system, params, licence] or a media type, defaults to all layers")
+	copyCmd.Flags().IntVarP(&jobs, "jobs", "j", writer.DefaultJobs, "Specify the number of layers that are fetched, decrypted and written concurrently")
+	copyCmd.Flags().StringVarP(&progressFormat, "progress", "", "auto", "Specify how the progress of each layer is reported, options: [auto, bar, log, json, none]")
+	copyCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+
+	return copyCmd
+}
+
+// getCopyKeyProviders returns the provider used to decrypt the source image
+// and the provider used to encrypt the copy, both use the same Vault server
+func getCopyKeyProviders(l *log.Logger) (keyproviders.Provider, keyproviders.Provider, error) {
+	dkp, err := getKeyProvider(
+		l,
+		nil,
+		decryptionKey,
+		decryptionVaultKeys,
+		encryptionVaultPath,
+		encryptionVaultAuthToken,
+		encryptionVaultAuthAddr,
+		encryptionVaultAuthNamespace)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ekp, err := getKeyProvider(
+		l,
+		encryptionKeys,
+		"",
+		encryptionVaultKeys,
+		encryptionVaultPath,
+		encryptionVaultAuthToken,
+		encryptionVaultAuthAddr,
+		encryptionVaultAuthNamespace)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dkp, ekp, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/stretchr/testify/require"
+)
+
+func resetCopyKeys(t *testing.T) {
+	t.Cleanup(func() {
+		encryptionKeys = nil
+		decryptionKey = ""
+		encryptionVaultKeys = nil
+		decryptionVaultKeys = nil
+		encryptionVaultPath = ""
+		encryptionVaultAuthAddr = ""
+	})
+}
+
+func TestCopyKeyProvidersUseSeparateVaultKeys(t *testing.T) {
+	resetCopyKeys(t)
+
+	encryptionVaultKeys = []string{"production"}
+	decryptionVaultKeys = []string{"staging"}
+	encryptionVaultPath = "transit"
+	encryptionVaultAuthAddr = "http://addr"
+
+	dkp, ekp, err := getCopyKeyProviders(nil)
+	require.NoError(t, err)
+	require.IsType(t, &keyproviders.Vault{}, dkp)
+	require.IsType(t, &keyproviders.Vault{}, ekp)
+	require.NotSame(t, dkp, ekp)
+}
+
+func TestCopyKeyProvidersDoNotDecryptWithEncryptionKeys(t *testing.T) {
+	resetCopyKeys(t)
+
+	encryptionVaultKeys = []string{"production"}
+	encryptionVaultPath = "transit"
+	encryptionVaultAuthAddr = "http://addr"
+
+	dkp, ekp, err := getCopyKeyProviders(nil)
+	require.NoError(t, err)
+	require.IsType(t, &keyproviders.NullProvider{}, dkp)
+	require.IsType(t, &keyproviders.Vault{}, ekp)
+}
+
+func TestCopyKeyProvidersAllowFileDecryptionAndVaultEncryption(t *testing.T) {
+	resetCopyKeys(t)
+
+	decryptionKey = "../test_fixtures/keys/private.key"
+	encryptionVaultKeys = []string{"production"}
+	encryptionVaultPath = "transit"
+	encryptionVaultAuthAddr = "http://addr"
+
+	dkp, ekp, err := getCopyKeyProviders(nil)
+	require.NoError(t, err)
+	require.IsType(t, &keyproviders.File{}, dkp)
+	require.IsType(t, &keyproviders.Vault{}, ekp)
+}
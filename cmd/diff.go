@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/spf13/cobra"
+)
+
+var diffFormat string
+
+func newDiffCmd() *cobra.Command {
+	diffCmd := &cobra.Command{
+		Use:   "diff <ref-a> <ref-b>",
+		Short: "Show the differences between two model images",
+		Long: `
+			Compares two model images layer by layer. The output shows whether the weights are
+			identical by digest, a unified diff of the template and system prompt, the parameters
+			and labels that have been added, removed or changed and any changes to the config.
+			Like inspect the model weights are never downloaded.
+
+			Encrypted layers are encrypted with a new key each time so the digests of the weights
+			of two encrypted images are always different, the templates and parameters of encrypted
+			images are compared when --decryption-key is set.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// the diff is written to stdout so logs are written to stderr
+			logger := log.New(os.Stderr)
+			logger.SetReportTimestamp(false)
+			logger.SetLevel(log.WarnLevel)
+
+			if debug {
+				logger.SetLevel(log.DebugLevel)
+			}
+
+			if diffFormat != "table" && diffFormat != "json" {
+				return &usageError{fmt.Errorf("unsupported format: %s", diffFormat)}
+			}
+
+			kp, err := getKeyProvider(
+				logger,
+				nil,
+				decryptionKey,
+				encryptionVaultKeys,
+				encryptionVaultPath,
+				encryptionVaultAuthToken,
+				encryptionVaultAuthAddr,
+				encryptionVaultAuthNamespace)
+
+			if err != nil {
+				return &usageError{fmt.Errorf("failed to create key provider: %w", err)}
+			}
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			opts := getOptions(logger, ro)
+
+			if decryptionKey != "" || len(encryptionVaultKeys) > 0 {
+				opts = append(opts, kapsule.WithDecryption(kp))
+			}
+
+			d, err := kapsule.Diff(cmd.Context(), args[0], args[1], opts...)
+			if err != nil {
+				return fmt.Errorf("failed to diff images: %w", err)
+			}
+
+			if diffFormat == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+
+				return enc.Encode(d)
+			}
+
+			return writeDiff(os.Stdout, d)
+		},
+	}
+
+	diffCmd.Flags().StringVarP(&diffFormat, "format", "", "table", "Specify the output format, options: [table, json]")
+	diffCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	diffCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	diffCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	diffCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	diffCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
+	diffCmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registry")
+	diffCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry, prefer kapsule login as the password is visible in the shell history")
+	diffCmd.Flags().StringVarP(&decryptionKey, "decryption-key", "", "", "The decryption key to use for reading encrypted layers, RSA private key")
+	diffCmd.Flags().StringVarP(&encryptionVaultPath, "encryption-vault-path", "", "", "The path for the transit secrets engine in vault to use for decrypting the image")
+	diffCmd.Flags().StringArrayVarP(&encryptionVaultKeys, "encryption-vault-key", "", nil, "The name of the key in vault to use for decrypting the image, repeat to try several keys")
+	diffCmd.Flags().StringVarP(&encryptionVaultAuthToken, "encryption-vault-auth-token", "", "", "The vault token to use for accessing the decryption key")
+	diffCmd.Flags().StringVarP(&encryptionVaultAuthAddr, "encryption-vault-addr", "", "", "The address of the vault server to use for accessing the decryption key")
+	diffCmd.Flags().StringVarP(&encryptionVaultAuthNamespace, "encryption-vault-namespace", "", "", "The namespace for the vault server to use for accessing the decryption key")
+	diffCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+
+	return diffCmd
+}
+
+// writeDiff writes a summary of the images followed by the layers and the
+// changes to the template, system prompt, parameters, labels and config
+func writeDiff(w io.Writer, d *kapsule.ImageDiff) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "A:\t%s\t%s\n", d.A, shortDigest(d.DigestA))
+	fmt.Fprintf(tw, "B:\t%s\t%s\n", d.B, shortDigest(d.DigestB))
+	fmt.Fprintf(tw, "Weights:\t%s\n", weightsStatus(d))
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tSTATUS\tA\tSIZE\tB\tSIZE")
+
+	for _, l := range d.Layers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", l.Type, l.Status, orDash(shortDigest(l.DigestA)), layerSize(l.DigestA, l.SizeA), orDash(shortDigest(l.DigestB)), layerSize(l.DigestB, l.SizeB))
+	}
+
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range []struct{ title, diff string }{{"Template:", d.Template}, {"System:", d.System}} {
+		if s.diff == "" {
+			continue
+		}
+
+		fmt.Fprintf(w, "\n%s\n%s", s.title, s.diff)
+	}
+
+	for _, s := range []struct {
+		title  string
+		values []kapsule.ValueDiff
+	}{{"Parameters:", d.Parameters}, {"Labels:", d.Labels}, {"Config:", d.Config}} {
+		if len(s.values) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "\n%s\n", s.title)
+
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tSTATUS\tA\tB")
+
+		for _, v := range s.values {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v.Key, v.Status, orDash(v.A), orDash(v.B))
+		}
+
+		err = tw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// weightsStatus describes whether the weights of the images are the same,
+// encrypted weights with different digests may still be the same
+func weightsStatus(d *kapsule.ImageDiff) string {
+	if d.WeightsIdentical {
+		return kapsule.DiffIdentical
+	}
+
+	status := kapsule.DiffUnknown
+	for _, l := range d.Layers {
+		if (l.Type == "model" || l.Type == "adapter") && l.Status != kapsule.DiffIdentical && l.Status != kapsule.DiffUnknown {
+			status = kapsule.DiffChanged
+		}
+	}
+
+	if status == kapsule.DiffUnknown {
+		return "unknown, the weights are encrypted"
+	}
+
+	return status
+}
+
+func layerSize(digest string, size int64) string {
+	if digest == "" {
+		return "-"
+	}
+
+	return progress.HumanBytes(size)
+}
+
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nicholasjackson/kapsule"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDiffWritesChanges(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	d := &kapsule.ImageDiff{
+		A:       "docker.io/nicholasjackson/mistral:staging",
+		B:       "docker.io/nicholasjackson/mistral:production",
+		DigestA: "sha256:6a0746a1ec1aef3e7ec53868f220ff6e389f6f8ef87a01d77c96807de94ca2aa",
+		DigestB: "sha256:ed11eda7790d05b49395598a42b155812b17e263214292f7b87d15e14003d337",
+		Layers: []kapsule.LayerDiff{
+			{Type: "model", DigestA: "sha256:e8a35b5937a5e6d5c35d1f2a15f161e07eefe5e5bb0a3cdd42998ee79b057730", DigestB: "sha256:e8a35b5937a5e6d5c35d1f2a15f161e07eefe5e5bb0a3cdd42998ee79b057730", SizeA: 4108218000, SizeB: 4108218000, Status: kapsule.DiffIdentical},
+			{Type: "adapter", DigestB: "sha256:c1f2a15f161e07eefe5e5bb0a3cdd42998ee79b057730e8a35b5937a5e6d5c35", SizeB: 1024, Status: kapsule.DiffAdded},
+		},
+		Template:   "--- a template\n+++ b template\n@@ -1 +1 @@\n-[INST] {{ .Prompt }} [/INST]\n+[INST] {{ .System }} {{ .Prompt }} [/INST]\n",
+		Parameters: []kapsule.ValueDiff{{Key: "temperature", A: "0.8", B: "0.2", Status: kapsule.DiffChanged}},
+	}
+
+	err := writeDiff(out, d)
+	require.NoError(t, err)
+
+	require.Regexp(t, `Weights:\s+changed`, out.String())
+	require.Regexp(t, `model\s+identical\s+sha256:e8a35b5937a5\s+4.1 GB\s+sha256:e8a35b5937a5\s+4.1 GB`, out.String())
+	require.Regexp(t, `adapter\s+added\s+-\s+-\s+sha256:c1f2a15f161e\s+1.0 kB`, out.String())
+	require.Contains(t, out.String(), "+[INST] {{ .System }} {{ .Prompt }} [/INST]")
+	require.Regexp(t, `temperature\s+changed\s+0.8\s+0.2`, out.String())
+	require.NotContains(t, out.String(), "Labels:")
+}
+
+func TestWeightsStatusReportsEncryptedWeightsAsUnknown(t *testing.T) {
+	d := &kapsule.ImageDiff{
+		Layers: []kapsule.LayerDiff{{Type: "model", Status: kapsule.DiffUnknown}},
+	}
+
+	require.Equal(t, "unknown, the weights are encrypted", weightsStatus(d))
+
+	d.WeightsIdentical = true
+	require.Equal(t, kapsule.DiffIdentical, weightsStatus(d))
+}
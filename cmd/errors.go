@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/spf13/cobra"
+)
+
+// exit codes returned by the CLI, scripts can use these to determine
+// why a command failed
+const (
+	exitCodeUnknown   = 1
+	exitCodeUsage     = 2
+	exitCodeAuth      = 3
+	exitCodeNotFound  = 4
+	exitCodeCrypto    = 5
+	exitCodeParse     = 6
+	exitCodeIO        = 7
+	exitCodeCorrupt   = 8
+	exitCodeCancelled = 130
+)
+
+// error kinds that are only relevant to the CLI
+const (
+	errorKindUsage     types.ErrorKind = "usage"
+	errorKindCancelled types.ErrorKind = "cancelled"
+)
+
+var exitCodes = map[types.ErrorKind]int{
+	types.ErrorKindUnknown:  exitCodeUnknown,
+	types.ErrorKindAuth:     exitCodeAuth,
+	types.ErrorKindNotFound: exitCodeNotFound,
+	types.ErrorKindCrypto:   exitCodeCrypto,
+	types.ErrorKindParse:    exitCodeParse,
+	types.ErrorKindIO:       exitCodeIO,
+	types.ErrorKindCorrupt:  exitCodeCorrupt,
+	errorKindUsage:          exitCodeUsage,
+	errorKindCancelled:      exitCodeCancelled,
+}
+
+// usageError is returned when the command line arguments or flags are invalid
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// usageArgs combines the given argument validators and reports any failure
+// as a usage error
+func usageArgs(args ...cobra.PositionalArgs) cobra.PositionalArgs {
+	validate := cobra.MatchAll(args...)
+
+	return func(cmd *cobra.Command, a []string) error {
+		if err := validate(cmd, a); err != nil {
+			return &usageError{err}
+		}
+
+		return nil
+	}
+}
+
+// classifyError returns the kind of the error, errors that have not been
+// classified by the Kapsule packages are inspected for well known
+// registry and filesystem errors
+func classifyError(err error) types.ErrorKind {
+	var ue *usageError
+	if errors.As(err, &ue) {
+		return errorKindUsage
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return errorKindCancelled
+	}
+
+	if kind := types.ErrorKindOf(err); kind != types.ErrorKindUnknown {
+		return kind
+	}
+
+	var te *transport.Error
+	if errors.As(err, &te) {
+		switch te.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return types.ErrorKindAuth
+		case http.StatusNotFound:
+			return types.ErrorKindNotFound
+		}
+
+		for _, d := range te.Errors {
+			switch d.Code {
+			case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+				return types.ErrorKindAuth
+			case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode, transport.BlobUnknownErrorCode:
+				return types.ErrorKindNotFound
+			}
+		}
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return types.ErrorKindNotFound
+	}
+
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return types.ErrorKindIO
+	}
+
+	return types.ErrorKindUnknown
+}
+
+type errorOutput struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Kind     types.ErrorKind `json:"kind"`
+	Message  string          `json:"message"`
+	ExitCode int             `json:"exit_code"`
+}
+
+// writeError writes the error to w in the given format and returns
+// the exit code for the error
+func writeError(w io.Writer, format string, err error) int {
+	kind := classifyError(err)
+	code := exitCodes[kind]
+
+	switch format {
+	case "json":
+		json.NewEncoder(w).Encode(errorOutput{
+			Error: errorDetail{
+				Kind:     kind,
+				Message:  err.Error(),
+				ExitCode: code,
+			},
+		})
+	default:
+		logger := log.New(w)
+		logger.SetReportTimestamp(false)
+		logger.Error(err.Error(), "kind", kind, "exit_code", code)
+	}
+
+	return code
+}
+
+// validateErrorFormat ensures the error format flag is a supported value
+func validateErrorFormat(format string) error {
+	switch format {
+	case "text", "json":
+		return nil
+	default:
+		return &usageError{fmt.Errorf("unsupported error format %q, options: [text, json]", format)}
+	}
+}
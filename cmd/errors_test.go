@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyErrorReturnsKindFromTypedError(t *testing.T) {
+	err := fmt.Errorf("failed: %w", types.Errorf(types.ErrorKindCrypto, "bad key"))
+	require.Equal(t, types.ErrorKindCrypto, classifyError(err))
+}
+
+func TestClassifyErrorReturnsAuthForUnauthorized(t *testing.T) {
+	err := fmt.Errorf("failed: %w", &transport.Error{StatusCode: http.StatusUnauthorized})
+	require.Equal(t, types.ErrorKindAuth, classifyError(err))
+}
+
+func TestClassifyErrorReturnsNotFoundForUnknownManifest(t *testing.T) {
+	err := fmt.Errorf("failed: %w", &transport.Error{
+		StatusCode: http.StatusBadRequest,
+		Errors:     []transport.Diagnostic{{Code: transport.ManifestUnknownErrorCode}},
+	})
+	require.Equal(t, types.ErrorKindNotFound, classifyError(err))
+}
+
+func TestClassifyErrorReturnsIOForFilesystemError(t *testing.T) {
+	_, err := os.ReadDir("/dev/null/nothere")
+	require.Equal(t, types.ErrorKindIO, classifyError(err))
+}
+
+func TestClassifyErrorReturnsCancelled(t *testing.T) {
+	err := fmt.Errorf("failed: %w", context.Canceled)
+	require.Equal(t, errorKindCancelled, classifyError(err))
+}
+
+func TestWriteErrorWritesJSON(t *testing.T) {
+	bf := bytes.NewBuffer(nil)
+
+	code := writeError(bf, "json", types.Errorf(types.ErrorKindParse, "bad modelfile"))
+	require.Equal(t, exitCodeParse, code)
+
+	out := errorOutput{}
+	err := json.Unmarshal(bf.Bytes(), &out)
+	require.NoError(t, err)
+	require.Equal(t, types.ErrorKindParse, out.Error.Kind)
+	require.Equal(t, "bad modelfile", out.Error.Message)
+	require.Equal(t, exitCodeParse, out.Error.ExitCode)
+}
+
+func TestWriteErrorWritesText(t *testing.T) {
+	bf := bytes.NewBuffer(nil)
+
+	code := writeError(bf, "text", &usageError{fmt.Errorf("missing argument")})
+	require.Equal(t, exitCodeUsage, code)
+	require.Contains(t, bf.String(), "missing argument")
+}
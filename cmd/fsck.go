@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/nicholasjackson/kapsule/writer"
+	"github.com/spf13/cobra"
+)
+
+var repair bool
+
+// checker is implemented by the writers that can verify their store
+type checker interface {
+	Check(ctx context.Context, repair bool) ([]writer.Problem, error)
+}
+
+func newFsckCmd() *cobra.Command {
+	fsckCmd := &cobra.Command{
+		Use:   "fsck [path]",
+		Short: "Check an OCI layout or Ollama store for inconsistencies",
+		Long: `
+			Checks that every image in an OCI layout or Ollama store references blobs that
+			exist and match their digest. Files left by interrupted writes and blobs that are
+			not referenced by any image are also reported. Use --repair to remove broken
+			images, corrupt blobs and leftover files.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.New(os.Stdout)
+			logger.SetReportTimestamp(false)
+
+			if debug {
+				logger.SetLevel(log.DebugLevel)
+			}
+
+			path := args[0]
+
+			var c checker
+			switch outputFormat {
+			case "oci":
+				c = writer.NewPathWriter(logger, nil, path)
+			case "ollama":
+				c = writer.NewOllamaWriter(logger, nil, path)
+			default:
+				return &usageError{fmt.Errorf("unsupported format: %s", outputFormat)}
+			}
+
+			logger.Info("Checking store", "path", path, "format", outputFormat, "repair", repair)
+
+			problems, err := c.Check(cmd.Context(), repair)
+			if err != nil {
+				return fmt.Errorf("failed to check store: %w", err)
+			}
+
+			remaining := 0
+			for _, p := range problems {
+				if p.Repaired {
+					logger.Info("Repaired", "path", p.Path, "problem", p.Message)
+					continue
+				}
+
+				remaining++
+				logger.Warn(p.Message, "path", p.Path)
+			}
+
+			if remaining > 0 && repair {
+				return types.Errorf(types.ErrorKindCorrupt, "unable to repair %d problems in %s", remaining, path)
+			}
+
+			if remaining > 0 {
+				return types.Errorf(types.ErrorKindCorrupt, "found %d problems in %s, run with --repair to fix them", remaining, path)
+			}
+
+			logger.Info("Store is consistent", "path", path, "repaired", len(problems))
+
+			return nil
+		},
+	}
+
+	fsckCmd.Flags().StringVarP(&outputFormat, "format", "", "oci", "Specify the format of the store, options: [oci, ollama]")
+	fsckCmd.Flags().BoolVarP(&repair, "repair", "", false, "Remove broken images, corrupt blobs and files left by interrupted writes")
+	fsckCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+
+	return fsckCmd
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/spf13/cobra"
+)
+
+var inspectFormat string
+
+func newInspectCmd() *cobra.Command {
+	inspectCmd := &cobra.Command{
+		Use:   "inspect <ref>",
+		Short: "Show the manifest, config and layers of an image",
+		Long: `
+			Shows the manifest, config and layers of an image including the Kapsule layer types,
+			sizes, encryption status and the recipients of encrypted layers. The template, system
+			prompt and parameters are read from their layers, the model weights are never downloaded.
+
+			Images can also be read from a local OCI layout using the oci-layout://path:tag or
+			oci:path:tag scheme or from an Ollama store using the ollama://path:model scheme.
+			Encrypted templates and parameters are shown when --decryption-key is set.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// the details are written to stdout so logs are written to
+			// stderr to keep the output parseable
+			logger := log.New(os.Stderr)
+			logger.SetReportTimestamp(false)
+			logger.SetLevel(log.WarnLevel)
+
+			if debug {
+				logger.SetLevel(log.DebugLevel)
+			}
+
+			ref := args[0]
+
+			if inspectFormat != "table" && inspectFormat != "json" {
+				return &usageError{fmt.Errorf("unsupported format: %s", inspectFormat)}
+			}
+
+			kp, err := getKeyProvider(
+				logger,
+				nil,
+				decryptionKey,
+				encryptionVaultKeys,
+				encryptionVaultPath,
+				encryptionVaultAuthToken,
+				encryptionVaultAuthAddr,
+				encryptionVaultAuthNamespace)
+
+			if err != nil {
+				return &usageError{fmt.Errorf("failed to create key provider: %w", err)}
+			}
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			opts := getOptions(logger, ro)
+
+			if decryptionKey != "" || len(encryptionVaultKeys) > 0 {
+				opts = append(opts, kapsule.WithDecryption(kp))
+			}
+
+			d, err := kapsule.Inspect(cmd.Context(), ref, opts...)
+			if err != nil {
+				return fmt.Errorf("failed to inspect image: %w", err)
+			}
+
+			if inspectFormat == "json" {
+				return writeDetailsJSON(os.Stdout, d)
+			}
+
+			return writeDetailsTable(os.Stdout, d)
+		},
+	}
+
+	inspectCmd.Flags().StringVarP(&inspectFormat, "format", "", "table", "Specify the output format, options: [table, json]")
+	inspectCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	inspectCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	inspectCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	inspectCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	inspectCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
+	inspectCmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registry")
+	inspectCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry, prefer kapsule login as the password is visible in the shell history")
+	inspectCmd.Flags().StringVarP(&decryptionKey, "decryption-key", "", "", "The decryption key to use for reading encrypted layers, RSA private key")
+	inspectCmd.Flags().StringVarP(&encryptionVaultPath, "encryption-vault-path", "", "", "The path for the transit secrets engine in vault to use for decrypting the image")
+	inspectCmd.Flags().StringArrayVarP(&encryptionVaultKeys, "encryption-vault-key", "", nil, "The name of the key in vault to use for decrypting the image, repeat to try several keys")
+	inspectCmd.Flags().StringVarP(&encryptionVaultAuthToken, "encryption-vault-auth-token", "", "", "The vault token to use for accessing the decryption key")
+	inspectCmd.Flags().StringVarP(&encryptionVaultAuthAddr, "encryption-vault-addr", "", "", "The address of the vault server to use for accessing the decryption key")
+	inspectCmd.Flags().StringVarP(&encryptionVaultAuthNamespace, "encryption-vault-namespace", "", "", "The namespace for the vault server to use for accessing the decryption key")
+	inspectCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+
+	return inspectCmd
+}
+
+// writeDetailsJSON writes the details as indented JSON
+func writeDetailsJSON(w io.Writer, d *kapsule.ImageDetails) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(d)
+}
+
+// writeDetailsTable writes the details as a summary of the image followed
+// by a table of the layers
+func writeDetailsTable(w io.Writer, d *kapsule.ImageDetails) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Ref:\t%s\n", d.Ref)
+	fmt.Fprintf(tw, "Digest:\t%s\n", d.Digest)
+	fmt.Fprintf(tw, "Media Type:\t%s\n", d.MediaType)
+	fmt.Fprintf(tw, "Size:\t%s\n", progress.HumanBytes(d.Size))
+	fmt.Fprintf(tw, "Config:\t%s\n", d.Config.Digest)
+
+	if d.Config.Created != nil {
+		fmt.Fprintf(tw, "Created:\t%s\n", d.Config.Created.Format(time.RFC3339))
+	}
+
+	writeMap(tw, "Annotations:", d.Annotations)
+	writeMap(tw, "Labels:", d.Config.Labels)
+
+	if d.Template != "" {
+		fmt.Fprintf(tw, "Template:\t%s\n", indent(d.Template))
+	}
+
+	if d.System != "" {
+		fmt.Fprintf(tw, "System:\t%s\n", indent(d.System))
+	}
+
+	if len(d.Parameters) > 0 {
+		params := map[string]string{}
+		for k, v := range d.Parameters {
+			params[k] = strings.Join(v, ", ")
+		}
+
+		writeMap(tw, "Parameters:", params)
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tDIGEST\tSIZE\tENCRYPTED\tRECIPIENTS\tMEDIA TYPE")
+
+	for _, l := range d.Layers {
+		t := l.Type
+		if t == "" {
+			t = "-"
+		}
+
+		recipients := []string{}
+		for _, r := range l.Recipients {
+			recipients = append(recipients, r.String())
+		}
+
+		rs := strings.Join(recipients, ", ")
+		if rs == "" {
+			rs = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\t%s\n", t, shortDigest(l.Digest), progress.HumanBytes(l.Size), l.Encrypted, rs, l.MediaType)
+	}
+
+	return tw.Flush()
+}
+
+// writeMap writes the entries of m sorted by key, the title is written on
+// the first line
+func writeMap(w io.Writer, title string, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s=%s\n", title, k, m[k])
+		title = ""
+	}
+}
+
+// indent keeps multi line values aligned with the first line of the table
+func indent(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n\t")
+}
+
+// shortDigest shortens digests to the algorithm and first 12 characters of
+// the hex i.e. sha256:6a0746a1ec1a
+func shortDigest(d string) string {
+	if i := strings.Index(d, ":"); i >= 0 && len(d) > i+13 {
+		return d[:i+13]
+	}
+
+	return d
+}
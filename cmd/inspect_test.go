@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/nicholasjackson/kapsule"
+	"github.com/nicholasjackson/kapsule/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func testDetails() *kapsule.ImageDetails {
+	return &kapsule.ImageDetails{
+		Ref:       "docker.io/nicholasjackson/mistral:tune",
+		Digest:    "sha256:6a0746a1ec1aef3e7ec53868f220ff6e389f6f8ef87a01d77c96807de94ca2aa",
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Size:      4108218112,
+		Layers: []kapsule.LayerDetails{
+			{
+				Type:      "model",
+				MediaType: "application/vnd.kapsule.image.model+gzip+enc",
+				Digest:    "sha256:e8a35b5937a5e6d5c35d1f2a15f161e07eefe5e5bb0a3cdd42998ee79b057730",
+				Size:      4108218000,
+				Encrypted: true,
+				Recipients: []crypto.Recipient{
+					{Algorithm: "RSA-OAEP", KeySize: 4096},
+				},
+			},
+			{
+				Type:      "params",
+				MediaType: "application/vnd.kapsule.image.params+gzip",
+				Digest:    "sha256:ed11eda7790d05b49395598a42b155812b17e263214292f7b87d15e14003d337",
+				Size:      112,
+			},
+		},
+		Template:   "[INST] {{ .Prompt }} [/INST]",
+		Parameters: map[string][]string{"stop": {"[INST]", "[/INST]"}},
+	}
+}
+
+func TestWriteDetailsTableWritesLayers(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := writeDetailsTable(out, testDetails())
+	require.NoError(t, err)
+
+	require.Contains(t, out.String(), "4.1 GB")
+	require.Contains(t, out.String(), "[INST] {{ .Prompt }} [/INST]")
+	require.Contains(t, out.String(), "stop=[INST], [/INST]")
+	require.Regexp(t, `model\s+sha256:e8a35b5937a5\s+4.1 GB\s+true\s+RSA-OAEP 4096-bit\s+application/vnd.kapsule.image.model\+gzip\+enc`, out.String())
+	require.Regexp(t, `params\s+sha256:ed11eda7790d\s+112 B\s+false\s+-\s+`, out.String())
+}
+
+func TestWriteDetailsJSONWritesDetails(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := writeDetailsJSON(out, testDetails())
+	require.NoError(t, err)
+
+	d := &kapsule.ImageDetails{}
+	err = json.Unmarshal(out.Bytes(), d)
+	require.NoError(t, err)
+	require.Equal(t, testDetails(), d)
+}
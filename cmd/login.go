@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule/registry"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var passwordStdin bool
+
+func newLoginCmd() *cobra.Command {
+	loginCmd := &cobra.Command{
+		Use:   "login [registry]",
+		Short: "Store the credentials for a remote registry",
+		Long: `
+			Verifies the credentials with the registry and stores them so that they do not
+			need to be passed to build, pull or push. Credentials are written to the file set
+			by $REGISTRY_AUTH_FILE or ~/.docker/config.json, when the file configures a
+			credential helper the credentials are stored using the docker-credential-* helper.
+
+			The password is read from stdin when --password-stdin is set, otherwise it is
+			prompted for.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.New(os.Stdout)
+			logger.SetReportTimestamp(false)
+
+			if debug {
+				logger.SetLevel(log.DebugLevel)
+			}
+
+			server := args[0]
+
+			if registryUsername == "" {
+				return &usageError{fmt.Errorf("username '--username' must be specified")}
+			}
+
+			password, err := readPassword(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+			ro.Password = password
+
+			err = registry.Login(cmd.Context(), ro, server)
+			if err != nil {
+				return fmt.Errorf("failed to login to %s: %w", server, err)
+			}
+
+			logger.Info("Login succeeded", "registry", server, "file", registry.LoginFile())
+
+			return nil
+		},
+	}
+
+	loginCmd.Flags().StringVarP(&registryUsername, "username", "u", "", "Specify the username for the remote registry")
+	loginCmd.Flags().BoolVarP(&passwordStdin, "password-stdin", "", false, "Read the password for the remote registry from stdin")
+	loginCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	loginCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	loginCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	loginCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	loginCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
+	loginCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+
+	return loginCmd
+}
+
+func newLogoutCmd() *cobra.Command {
+	logoutCmd := &cobra.Command{
+		Use:   "logout [registry]",
+		Short: "Remove the stored credentials for a remote registry",
+		Long: `
+			Removes the credentials stored by login for the registry from the file set by
+			$REGISTRY_AUTH_FILE or ~/.docker/config.json, or from the docker-credential-*
+			helper configured in the file.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.New(os.Stdout)
+			logger.SetReportTimestamp(false)
+
+			server := args[0]
+
+			err := registry.Logout(server)
+			if err != nil {
+				return fmt.Errorf("failed to logout from %s: %w", server, err)
+			}
+
+			logger.Info("Removed credentials", "registry", server, "file", registry.LoginFile())
+
+			return nil
+		},
+	}
+
+	return logoutCmd
+}
+
+// readPassword reads the password from stdin when --password-stdin is set,
+// otherwise the user is prompted for the password without echoing it
+func readPassword(stdin io.Reader) (string, error) {
+	if passwordStdin {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", fmt.Errorf("unable to read password from stdin: %w", err)
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", &usageError{fmt.Errorf("use '--password-stdin' to read the password when stdin is not a terminal")}
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	data, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		return "", fmt.Errorf("unable to read password: %w", err)
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/nicholasjackson/kapsule/reader"
+	"github.com/spf13/cobra"
+)
+
+var listFormat string
+var labelFilters []string
+
+func newLsCmd() *cobra.Command {
+	lsCmd := &cobra.Command{
+		Use:   "ls <registry|location>",
+		Short: "List the repositories in a registry or the models in a local store",
+		Long: `
+			Lists the repositories in a remote registry using the catalog API, not all registries
+			support the catalog API. Use the tags command to list the images in a repository.
+
+			Models in a local OCI layout or Ollama store are listed with their size, creation time
+			and labels when the location uses the oci-layout://path, oci:path or ollama://path
+			scheme. Models can be filtered by label using --filter key=value or --filter key to
+			only show models where the label is set.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := getListLogger()
+			if err != nil {
+				return err
+			}
+
+			location := args[0]
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			_, _, layout := reader.ParseOCILayoutRef(location)
+			_, _, ollama := reader.ParseOllamaRef(location)
+
+			if layout || ollama {
+				s, err := kapsule.List(cmd.Context(), location, getOptions(logger, ro)...)
+				if err != nil {
+					return fmt.Errorf("failed to list models: %w", err)
+				}
+
+				return writeSummaries(os.Stdout, filterSummaries(s))
+			}
+
+			if len(labelFilters) > 0 {
+				return &usageError{fmt.Errorf("filters are not supported when listing repositories, use the tags command")}
+			}
+
+			repos, err := kapsule.Catalog(cmd.Context(), location, getOptions(logger, ro)...)
+			if err != nil {
+				return fmt.Errorf("failed to list repositories: %w", err)
+			}
+
+			if listFormat == "json" {
+				return json.NewEncoder(os.Stdout).Encode(repos)
+			}
+
+			for _, r := range repos {
+				fmt.Fprintln(os.Stdout, r)
+			}
+
+			return nil
+		},
+	}
+
+	addListFlags(lsCmd)
+
+	return lsCmd
+}
+
+func newTagsCmd() *cobra.Command {
+	tagsCmd := &cobra.Command{
+		Use:   "tags <repository>",
+		Short: "List the images in a repository of a remote registry",
+		Long: `
+			Lists each tag in a repository of a remote registry with the size, creation time and
+			labels of the image, only the manifest and config of each image is fetched. Images
+			can be filtered by label using --filter key=value or --filter key to only show
+			images where the label is set.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := getListLogger()
+			if err != nil {
+				return err
+			}
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			s, err := kapsule.Tags(cmd.Context(), args[0], getOptions(logger, ro)...)
+			if err != nil {
+				return fmt.Errorf("failed to list tags: %w", err)
+			}
+
+			return writeSummaries(os.Stdout, filterSummaries(s))
+		},
+	}
+
+	addListFlags(tagsCmd)
+
+	return tagsCmd
+}
+
+func addListFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&listFormat, "format", "", "table", "Specify the output format, options: [table, json]")
+	cmd.Flags().StringArrayVarP(&labelFilters, "filter", "f", nil, "Only show images with the label, specified as key=value or key, can be repeated")
+	cmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	cmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	cmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	cmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	cmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
+	cmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registry")
+	cmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry, prefer kapsule login as the password is visible in the shell history")
+	cmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+}
+
+// getListLogger returns the logger for the list commands, the list is
+// written to stdout so logs are written to stderr
+func getListLogger() (*log.Logger, error) {
+	if listFormat != "table" && listFormat != "json" {
+		return nil, &usageError{fmt.Errorf("unsupported format: %s", listFormat)}
+	}
+
+	logger := log.New(os.Stderr)
+	logger.SetReportTimestamp(false)
+	logger.SetLevel(log.WarnLevel)
+
+	if debug {
+		logger.SetLevel(log.DebugLevel)
+	}
+
+	return logger, nil
+}
+
+// filterSummaries returns the summaries that match --filter
+func filterSummaries(s []reader.Summary) []reader.Summary {
+	filters := reader.ParseFilters(labelFilters)
+
+	matched := []reader.Summary{}
+	for _, sm := range s {
+		if sm.Matches(filters) {
+			matched = append(matched, sm)
+		}
+	}
+
+	return matched
+}
+
+// writeSummaries writes the summaries using --format
+func writeSummaries(w io.Writer, s []reader.Summary) error {
+	if listFormat == "json" {
+		return json.NewEncoder(w).Encode(s)
+	}
+
+	return writeSummariesTable(w, s)
+}
+
+// writeSummariesTable writes a row for each image, the labels are sorted
+// by key
+func writeSummariesTable(w io.Writer, s []reader.Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REF\tDIGEST\tSIZE\tCREATED\tLABELS")
+
+	for _, sm := range s {
+		created := "-"
+		if sm.Created != nil {
+			created = sm.Created.Format("2006-01-02 15:04")
+		}
+
+		keys := make([]string, 0, len(sm.Labels))
+		for k := range sm.Labels {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		labels := []string{}
+		for _, k := range keys {
+			labels = append(labels, k+"="+sm.Labels[k])
+		}
+
+		ls := strings.Join(labels, ",")
+		if ls == "" {
+			ls = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", sm.Ref, shortDigest(sm.Digest), progress.HumanBytes(sm.Size), created, ls)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/kapsule/reader"
+	"github.com/stretchr/testify/require"
+)
+
+func testSummaries() []reader.Summary {
+	created := time.Date(2024, 4, 2, 10, 30, 0, 0, time.UTC)
+
+	return []reader.Summary{
+		{
+			Ref:     "kapsule.io/library/mistral:q4",
+			Digest:  "sha256:6a0746a1ec1aef3e7ec53868f220ff6e389f6f8ef87a01d77c96807de94ca2aa",
+			Size:    4108218112,
+			Created: &created,
+			Labels:  map[string]string{"quantization": "Q4_K_M", "family": "llama"},
+		},
+		{
+			Ref:    "kapsule.io/library/mistral:q8",
+			Digest: "sha256:e8a35b5937a5e6d5c35d1f2a15f161e07eefe5e5bb0a3cdd42998ee79b057730",
+			Size:   7695857664,
+			Labels: map[string]string{"quantization": "Q8_0"},
+		},
+	}
+}
+
+func TestWriteSummariesTableWritesImages(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := writeSummariesTable(out, testSummaries())
+	require.NoError(t, err)
+
+	require.Regexp(t, `kapsule.io/library/mistral:q4\s+sha256:6a0746a1ec1a\s+4.1 GB\s+2024-04-02 10:30\s+family=llama,quantization=Q4_K_M`, out.String())
+	require.Regexp(t, `kapsule.io/library/mistral:q8\s+sha256:e8a35b5937a5\s+7.7 GB\s+-\s+quantization=Q8_0`, out.String())
+}
+
+func TestFilterSummariesReturnsMatchingImages(t *testing.T) {
+	labelFilters = []string{"quantization=Q8_0"}
+	t.Cleanup(func() { labelFilters = nil })
+
+	s := filterSummaries(testSummaries())
+	require.Len(t, s, 1)
+	require.Equal(t, "kapsule.io/library/mistral:q8", s[0].Ref)
+}
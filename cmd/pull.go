@@ -1,10 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/charmbracelet/log"
-	"github.com/nicholasjackson/kapsule/reader"
 	"github.com/nicholasjackson/kapsule/writer"
 	"github.com/spf13/cobra"
 )
@@ -13,9 +13,15 @@ func newPullCmd() *cobra.Command {
 	pullCmd := &cobra.Command{
 		Use:   "pull",
 		Short: "Pull an OCI image from a remote registry",
-		Long:  ``,
-		Args:  cobra.MatchAll(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
-		Run: func(cmd *cobra.Command, args []string) {
+		Long: `
+			Pulls an image from a remote registry and writes it to the output folder. Images can also
+			be read from a local OCI layout using the oci-layout://path:tag or oci:path:tag scheme.
+
+			Using the ollama-api format the model is registered with a running Ollama server
+			using the Ollama API, the output folder is not required.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := log.New(os.Stdout)
 			logger.SetReportTimestamp(false)
 
@@ -28,77 +34,108 @@ func newPullCmd() *cobra.Command {
 			// are we using encryption, if so build the key provider
 			kp, err := getKeyProvider(
 				logger,
-				encryptionKey,
+				encryptionKeys,
 				decryptionKey,
-				encryptionVaultKey,
+				encryptionVaultKeys,
 				encryptionVaultPath,
 				encryptionVaultAuthToken,
 				encryptionVaultAuthAddr,
 				encryptionVaultAuthNamespace)
 
 			if err != nil {
-				log.Error("Failed to create key provider", "error", err)
-				return
+				return &usageError{fmt.Errorf("failed to create key provider: %w", err)}
 			}
 
+			// the progress of each layer is reported as it is written
+			rep, err := getProgressReporter(logger)
+			if err != nil {
+				return err
+			}
+			defer rep.Close()
+
 			decrypt := false
-			if decryptionKey != "" || encryptionVaultKey != "" {
+			if decryptionKey != "" || len(encryptionVaultKeys) > 0 {
 				decrypt = true
 			}
 
+			if outputFolder == "" && outputFormat != "ollama-api" {
+				return &usageError{fmt.Errorf("output folder '--output' must be specified")}
+			}
+
 			logger.Info("Pulling image", "tag", tag, "output", outputFolder)
-			r := reader.NewOCIRegistry(logger, registryUsername, registryPassword, insecure)
-			i, err := r.Pull(tag)
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			r, source := getReader(logger, tag, ro)
+			i, err := r.Pull(cmd.Context(), source)
 			if err != nil {
-				log.Error("Failed to pull image", "error", err)
-				return
+				return fmt.Errorf("failed to pull image: %w", err)
+			}
+
+			// images read from a local layout are written using the name
+			// recorded in the layout rather than the tag or digest used to
+			// select them
+			tag, err = getImageName(cmd.Context(), r, source)
+			if err != nil {
+				return fmt.Errorf("failed to pull image: %w", err)
 			}
 
 			// write the image to the output
 			switch outputFormat {
 			case "ollama":
-				if outputFolder == "" {
-					log.Error("Output folder '--output-folder' must be specified for Ollama format")
-					return
-				}
 				w := writer.NewOllamaWriter(logger, kp, outputFolder)
-				err := w.Write(i, tag, decrypt, unzip)
+				w.SetJobs(jobs)
+				w.SetProgress(rep)
+				err := w.Write(cmd.Context(), i, tag, decrypt, unzip)
 				if err != nil {
-					log.Error("Failed to write image to ollama", "path", outputFolder, "error", err)
-					return
+					return fmt.Errorf("failed to write image to ollama at %s: %w", outputFolder, err)
 				}
-			case "oci":
-				if outputFolder == "" {
-					log.Error("Output folder '--output-folder' must be specified for Ollama format")
-					return
+			case "ollama-api":
+				w := writer.NewOllamaAPIWriter(logger, kp, ollamaHost)
+				w.SetJobs(jobs)
+				w.SetProgress(rep)
+				w.SetTempDir(cacheDir)
+				err := w.Write(cmd.Context(), i, tag, decrypt, unzip)
+				if err != nil {
+					return fmt.Errorf("failed to create model on ollama server at %s: %w", ollamaHost, err)
 				}
-
+			case "oci":
 				w := writer.NewPathWriter(logger, kp, outputFolder)
-				err := w.Write(i, outputFolder, decrypt, unzip)
+				w.SetJobs(jobs)
+				w.SetProgress(rep)
+				err := w.Write(cmd.Context(), i, tag, decrypt, unzip)
 				if err != nil {
-					log.Error("Failed to write image to path", "path", outputFolder, "error", err)
-					return
+					return fmt.Errorf("failed to write image to path %s: %w", outputFolder, err)
 				}
-				return
 			default:
-				log.Error("Unsupported format", "format", outputFormat)
-				return
+				return &usageError{fmt.Errorf("unsupported format: %s", outputFormat)}
 			}
+
+			return nil
 		},
 	}
 
-	pullCmd.Flags().StringVarP(&outputFormat, "format", "", "oci", "Specify the output format for the built image, defaults to OCI image format, options: [ollama, oci]")
+	pullCmd.Flags().StringVarP(&outputFormat, "format", "", "oci", "Specify the output format for the built image, defaults to OCI image format, options: [ollama, ollama-api, oci]")
 	pullCmd.Flags().StringVarP(&outputFolder, "output", "o", "", "Specify the output folder for the built image, if not specified the image will be pushed to a remote registry")
-	pullCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Push to an insecure registry")
+	pullCmd.Flags().StringVarP(&ollamaHost, "ollama-host", "", writer.OllamaAddress(), "Specify the address of the Ollama server used by the ollama-api format")
+	pullCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	pullCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	pullCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	pullCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	pullCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
 	pullCmd.Flags().BoolVarP(&unzip, "unzip", "", true, "Uncompresses layers when writing to disk")
 	pullCmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registry")
-	pullCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry")
-	pullCmd.Flags().StringVarP(&encryptionKey, "encryption-key", "", "", "The encryption key to use for encrypting the image")
+	pullCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry, prefer kapsule login as the password is visible in the shell history")
+	pullCmd.Flags().StringArrayVarP(&encryptionKeys, "encryption-key", "", nil, "The encryption key to use for encrypting the image")
 	pullCmd.Flags().StringVarP(&decryptionKey, "decryption-key", "", "", "The decryption key to use for encrypting the image, RSA private key")
 	pullCmd.Flags().StringVarP(&encryptionVaultPath, "encryption-vault-path", "", "", "The path for the transit secrets engine in vault to use for encrypting and decrypting the image")
-	pullCmd.Flags().StringVarP(&encryptionVaultKey, "encryption-vault-key", "", "", "The name of the key in vault to use for encrypting and decrypting the image")
+	pullCmd.Flags().StringArrayVarP(&encryptionVaultKeys, "encryption-vault-key", "", nil, "The name of the key in vault to use for encrypting and decrypting the image, repeat to try several keys")
 	pullCmd.Flags().StringVarP(&encryptionVaultAuthToken, "encryption-vault-auth-token", "", "", "The vault token to use for accessing the encryption and decryption key")
 	pullCmd.Flags().StringVarP(&encryptionVaultAuthAddr, "encryption-vault-addr", "", "", "The address of the vault server to use for accessing the encryption / decryption key")
+	pullCmd.Flags().IntVarP(&jobs, "jobs", "j", writer.DefaultJobs, "Specify the number of layers that are fetched, decrypted and written concurrently")
+	pullCmd.Flags().StringVarP(&progressFormat, "progress", "", "auto", "Specify how the progress of each layer is reported, options: [auto, bar, log, json, none]")
 	pullCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
 
 	return pullCmd
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule/reader"
+	"github.com/nicholasjackson/kapsule/writer"
+	"github.com/spf13/cobra"
+)
+
+var inputFolder string
+var inputFormat string
+var sourceRef string
+
+func newPushCmd() *cobra.Command {
+	pushCmd := &cobra.Command{
+		Use:   "push",
+		Short: "Push an image from a local OCI layout or Ollama store to a remote registry",
+		Long: `
+			Pushes an image that has been written to a local OCI layout using the --output flag
+			to a remote registry. The image is selected from the layout using the tag or digest
+			specified by --source, if --source is not set the destination tag is used.
+
+			Models in an Ollama store can be pushed by setting --input-format to ollama, if
+			--input is not set the default Ollama store is used.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.New(os.Stdout)
+			logger.SetReportTimestamp(false)
+
+			if debug {
+				logger.SetLevel(log.DebugLevel)
+			}
+
+			tag := args[0]
+
+			// are we using encryption, if so build the key provider
+			kp, err := getKeyProvider(
+				logger,
+				encryptionKeys,
+				"",
+				encryptionVaultKeys,
+				encryptionVaultPath,
+				encryptionVaultAuthToken,
+				encryptionVaultAuthAddr,
+				encryptionVaultAuthNamespace)
+
+			if err != nil {
+				return &usageError{fmt.Errorf("failed to create key provider: %w", err)}
+			}
+
+			// the progress of each layer is reported as it is written
+			rep, err := getProgressReporter(logger)
+			if err != nil {
+				return err
+			}
+			defer rep.Close()
+
+			encrypt := false
+			if len(encryptionKeys) > 0 || len(encryptionVaultKeys) > 0 {
+				encrypt = true
+			}
+
+			layers, err := getEncryptLayers(encrypt)
+			if err != nil {
+				return err
+			}
+
+			source := sourceRef
+			if source == "" {
+				source = tag
+			}
+
+			var r reader.Registry
+			switch inputFormat {
+			case "oci":
+				if inputFolder == "" {
+					return &usageError{fmt.Errorf("input folder '--input' must be specified")}
+				}
+
+				r = reader.NewOCILayout(logger, inputFolder)
+			case "ollama":
+				if inputFolder == "" {
+					inputFolder = reader.DefaultOllamaPath()
+				}
+
+				r = reader.NewOllama(logger, inputFolder)
+			default:
+				return &usageError{fmt.Errorf("unsupported input format: %s", inputFormat)}
+			}
+
+			logger.Info("Reading image", "input", inputFolder, "format", inputFormat, "source", source)
+
+			i, err := r.Pull(cmd.Context(), source)
+			if err != nil {
+				return fmt.Errorf("failed to read image: %w", err)
+			}
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			w := writer.NewOCIRegistry(logger, kp, ro)
+			w.SetJobs(jobs)
+			w.SetProgress(rep)
+			w.SetEncryptLayers(layers)
+
+			if encrypt {
+				err = w.WriteEncrypted(cmd.Context(), i, tag)
+			} else {
+				err = w.Write(cmd.Context(), i, tag, false, false)
+			}
+
+			if err != nil {
+				return fmt.Errorf("failed to push image to remote registry: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	pushCmd.Flags().StringVarP(&inputFolder, "input", "i", "", "Specify the folder containing the OCI layout or Ollama store to read the image from")
+	pushCmd.Flags().StringVarP(&inputFormat, "input-format", "", "oci", "Specify the format of the input folder, options: [oci, ollama]")
+	pushCmd.Flags().StringVarP(&sourceRef, "source", "s", "", "Specify the tag or digest of the image in the input folder, defaults to the destination tag")
+	pushCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	pushCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	pushCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	pushCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	pushCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
+	pushCmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registry")
+	pushCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry, prefer kapsule login as the password is visible in the shell history")
+	pushCmd.Flags().StringArrayVarP(&encryptionKeys, "encryption-key", "", nil, "The encryption key to use for encrypting the image, RSA public key, repeat to encrypt the image for several recipients")
+	pushCmd.Flags().StringVarP(&encryptionVaultPath, "encryption-vault-path", "", "", "The path to the transit secrets endpoint for encrypting the image")
+	pushCmd.Flags().StringArrayVarP(&encryptionVaultKeys, "encryption-vault-key", "", nil, "The name of exportable encryption key in Vault to use for encrypting the image, repeat to encrypt the image for several recipients")
+	pushCmd.Flags().StringVarP(&encryptionVaultAuthToken, "encryption-vault-auth-token", "", "", "The vault token to use for accessing the encryption key")
+	pushCmd.Flags().StringVarP(&encryptionVaultAuthAddr, "encryption-vault-addr", "", "", "The address of the vault server to use for accessing the encryption key")
+	pushCmd.Flags().StringVarP(&encryptionVaultAuthNamespace, "encryption-vault-namespace", "", "", "The namespace for the vault server to use for accessing the encryption key")
+	pushCmd.Flags().StringSliceVarP(&encryptLayers, "encrypt-layers", "", nil, "Only encrypt the given layers, options: [model, adapter, template, system, params, licence] or a media type, defaults to all layers")
+	pushCmd.Flags().IntVarP(&jobs, "jobs", "j", writer.DefaultJobs, "Specify the number of layers that are fetched, decrypted and written concurrently")
+	pushCmd.Flags().StringVarP(&progressFormat, "progress", "", "auto", "Specify how the progress of each layer is reported, options: [auto, bar, log, json, none]")
+	pushCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+
+	return pushCmd
+}
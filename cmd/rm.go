@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule"
+	"github.com/spf13/cobra"
+)
+
+func newRmCmd() *cobra.Command {
+	rmCmd := &cobra.Command{
+		Use:   "rm <ref>...",
+		Short: "Remove tags or images from a remote registry or a local store",
+		Long: `
+			Removes the image at each ref. Images in a remote registry are deleted using the OCI
+			delete API, deleting a digest removes the manifest and every tag that points at it. Not
+			all registries support deleting a tag, use the digest of the image for those registries.
+
+			Images in a local OCI layout or Ollama store are removed using the oci-layout://path:tag,
+			oci:path:tag or ollama://path:model scheme, blobs that are not used by any other image
+			in the store are deleted.
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.MinimumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.New(os.Stdout)
+			logger.SetReportTimestamp(false)
+
+			if debug {
+				logger.SetLevel(log.DebugLevel)
+			}
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			for _, ref := range args {
+				err := kapsule.Remove(cmd.Context(), ref, getOptions(logger, ro)...)
+				if err != nil {
+					return fmt.Errorf("failed to remove %s: %w", ref, err)
+				}
+
+				logger.Info("Removed image", "ref", ref)
+			}
+
+			return nil
+		},
+	}
+
+	rmCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	rmCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	rmCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	rmCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	rmCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
+	rmCmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registry")
+	rmCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry, prefer kapsule login as the password is visible in the shell history")
+	rmCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+
+	return rmCmd
+}
@@ -1,29 +1,71 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/nicholasjackson/kapsule/registry"
 	"github.com/spf13/cobra"
 )
 
+var errorFormat string
+var configFile string
+var cacheDir string
+
 func init() {
 	rootCmd.AddCommand(newBuildCmd())
 	rootCmd.AddCommand(newPullCmd())
+	rootCmd.AddCommand(newPushCmd())
+	rootCmd.AddCommand(newCopyCmd())
+	rootCmd.AddCommand(newInspectCmd())
+	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.AddCommand(newLsCmd())
+	rootCmd.AddCommand(newTagsCmd())
+	rootCmd.AddCommand(newTagCmd())
+	rootCmd.AddCommand(newRmCmd())
+	rootCmd.AddCommand(newFsckCmd())
+	rootCmd.AddCommand(newLoginCmd())
+	rootCmd.AddCommand(newLogoutCmd())
+
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "", registry.DefaultConfigFile(), "Specify the config file containing the settings for remote registries")
+	rootCmd.PersistentFlags().StringVarP(&cacheDir, "cache-dir", "", registry.DefaultCacheDir(), "Specify the folder used to store partially downloaded blobs so that interrupted pulls can be resumed, layers are copied to the folder while downloading, set to \"\" to disable")
+	// --output is already used by build and pull for the output folder so
+	// the error format has its own flag
+	rootCmd.PersistentFlags().StringVarP(&errorFormat, "error-format", "", "text", "Specify the format used to report errors, options: [text, json]")
+
+	// report invalid flags as usage errors so they get a distinct exit code
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &usageError{err}
+	})
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "kapsule",
 	Short: "Kapsule enables large language models to be bundled into OCI images",
 	Long:  "Kapsule enables large language models to be bundled into OCI images",
+	// errors are written by main using the requested error format
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateErrorFormat(errorFormat)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Do Stuff Here
 	},
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	// cancel the context on interrupt so that any running pulls, pushes or
+	// writes can stop and clean up partial output
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		code := writeError(os.Stderr, errorFormat, err)
+
+		stop()
+		os.Exit(code)
 	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule"
+	"github.com/spf13/cobra"
+)
+
+func newTagCmd() *cobra.Command {
+	tagCmd := &cobra.Command{
+		Use:   "tag <src> <dst>",
+		Short: "Tag an existing image without uploading it again",
+		Long: `
+			Points the tag dst at the manifest of the image src. In a remote registry dst must be
+			in the same registry as src, when dst is in another repository the blobs are mounted
+			from the source repository rather than uploaded.
+
+			Images in a local OCI layout or Ollama store are tagged in the same store using the
+			oci-layout://path:tag, oci:path:tag or ollama://path:model scheme, dst can omit the
+			scheme and path i.e. kapsule tag oci:./output:mistral:v1 mistral:latest
+			`,
+		Args: usageArgs(cobra.OnlyValidArgs, cobra.ExactArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.New(os.Stdout)
+			logger.SetReportTimestamp(false)
+
+			if debug {
+				logger.SetLevel(log.DebugLevel)
+			}
+
+			ro, err := getRegistryOptions()
+			if err != nil {
+				return err
+			}
+
+			err = kapsule.Tag(cmd.Context(), args[0], args[1], getOptions(logger, ro)...)
+			if err != nil {
+				return fmt.Errorf("failed to tag image: %w", err)
+			}
+
+			logger.Info("Tagged image", "src", args[0], "dst", args[1])
+
+			return nil
+		},
+	}
+
+	tagCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "Skip verification of the TLS certificate of the remote registry")
+	tagCmd.Flags().BoolVarP(&plainHTTP, "plain-http", "", false, "Connect to the remote registry using plain HTTP rather than HTTPS")
+	tagCmd.Flags().StringVarP(&caCert, "ca-cert", "", "", "Specify a PEM bundle of certificate authorities to trust for the remote registry")
+	tagCmd.Flags().StringVarP(&clientCert, "client-cert", "", "", "Specify the PEM client certificate for remote registries that require mutual TLS")
+	tagCmd.Flags().StringVarP(&clientKey, "client-key", "", "", "Specify the PEM client key for remote registries that require mutual TLS")
+	tagCmd.Flags().StringVarP(&registryUsername, "username", "", "", "Specify the username for the remote registry")
+	tagCmd.Flags().StringVarP(&registryPassword, "password", "", "", "Specify the password for the remote registry, prefer kapsule login as the password is visible in the shell history")
+	tagCmd.Flags().BoolVarP(&debug, "debug", "", false, "Enable logging in debug mode")
+
+	return tagCmd
+}
@@ -1,44 +1,203 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/charmbracelet/log"
+	"golang.org/x/term"
 
+	"github.com/nicholasjackson/kapsule"
 	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/nicholasjackson/kapsule/reader"
+	"github.com/nicholasjackson/kapsule/registry"
+	"github.com/nicholasjackson/kapsule/types"
 )
 
+// getKeyProvider returns the provider for the key flags, images are encrypted
+// for every public key or vault key so that any of the matching private keys
+// can decrypt the image
 func getKeyProvider(
 	l *log.Logger,
-	publicKey,
-	privateKey,
-	encryptionVaultKey,
+	publicKeys []string,
+	privateKey string,
+	encryptionVaultKeys []string,
 	encryptionVaultPath,
 	encryptionVaultAuthToken,
 	encryptionVaultAuthAddr,
 	encryptionVaultAuthNamespace string) (keyproviders.Provider, error) {
 
 	// if no key is specified return nil provider
-	if publicKey == "" && privateKey == "" && encryptionVaultKey == "" {
+	if len(publicKeys) == 0 && privateKey == "" && len(encryptionVaultKeys) == 0 {
 		return &keyproviders.NullProvider{}, nil
 	}
 
 	// ensure that the user has not specified both a file based key and a vault key
-	if (publicKey != "" || privateKey != "") && encryptionVaultKey != "" {
+	if (len(publicKeys) > 0 || privateKey != "") && len(encryptionVaultKeys) > 0 {
 		return nil, fmt.Errorf("cannot specify both encryption key and vault key")
 	}
 
-	if encryptionVaultKey != "" && (encryptionVaultAuthAddr == "" || encryptionVaultPath == "") {
+	if len(encryptionVaultKeys) > 0 && (encryptionVaultAuthAddr == "" || encryptionVaultPath == "") {
 		return nil, fmt.Errorf("you must specify the vault address, transit path, and keyname when using a vault key")
 	}
 
-	if publicKey != "" || privateKey != "" {
-		return keyproviders.NewFile(publicKey, privateKey), nil
+	providers := []keyproviders.Provider{}
+
+	if len(publicKeys) > 0 || privateKey != "" {
+		// the private key is paired with the first public key, the other
+		// public keys are only used to encrypt the image
+		publicKey := ""
+		if len(publicKeys) > 0 {
+			publicKey = publicKeys[0]
+		}
+
+		providers = append(providers, keyproviders.NewFile(publicKey, privateKey))
+
+		for _, k := range publicKeys[min(1, len(publicKeys)):] {
+			providers = append(providers, keyproviders.NewFile(k, ""))
+		}
+	}
+
+	for _, k := range encryptionVaultKeys {
+		providers = append(providers, keyproviders.NewVault(l, encryptionVaultPath, k, "", encryptionVaultAuthToken, encryptionVaultAuthAddr, encryptionVaultAuthNamespace))
+	}
+
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+
+	return keyproviders.NewMulti(l, providers...), nil
+}
+
+// getReader returns the reader for the given reference and the reference
+// of the image within the source. References using the oci-layout:// or oci:
+// scheme are read from a local OCI layout, references using the ollama://
+// scheme from an Ollama store and all others from a remote registry.
+func getReader(l *log.Logger, ref string, ro registry.Options) (reader.Registry, string) {
+	if p, r, ok := reader.ParseOCILayoutRef(ref); ok {
+		return reader.NewOCILayout(l, p), r
+	}
+
+	if p, r, ok := reader.ParseOllamaRef(ref); ok {
+		return reader.NewOllama(l, p), r
+	}
+
+	return reader.NewOCIRegistry(l, ro), ref
+}
+
+// getImageName returns the name the image read from ref is written as,
+// images in a local OCI layout use the name recorded in the layout so that
+// selecting an image by tag or digest keeps its full name
+func getImageName(ctx context.Context, r reader.Registry, ref string) (string, error) {
+	if l, ok := r.(*reader.OCILayout); ok {
+		return l.Name(ctx, ref)
+	}
+
+	return ref, nil
+}
+
+// getRegistryOptions returns the options used to connect to remote registries,
+// the TLS flags take precedence over the settings in the config file and
+// --plain-http applies in addition to the insecure registries in the file
+func getRegistryOptions() (registry.Options, error) {
+	c, err := registry.LoadConfig(configFile)
+	if err != nil {
+		return registry.Options{}, err
+	}
+
+	return registry.Options{
+		Username: registryUsername,
+		Password: registryPassword,
+		TLS: registry.TLS{
+			CACert:             caCert,
+			ClientCert:         clientCert,
+			ClientKey:          clientKey,
+			InsecureSkipVerify: insecure,
+		},
+		PlainHTTP: plainHTTP,
+		Config:    c,
+		CacheDir:  cacheDir,
+	}, nil
+}
+
+// getProgressReporter returns the reporter for --progress, auto draws a
+// progress bar when stderr is a terminal and otherwise logs the progress.
+// The bar and JSON lines are written to stderr so that they are not mixed
+// with the log output.
+func getProgressReporter(l *log.Logger) (progress.Reporter, error) {
+	switch progressFormat {
+	case "auto":
+		if term.IsTerminal(int(os.Stderr.Fd())) {
+			return progress.NewBarReporter(os.Stderr), nil
+		}
+
+		return progress.NewLogReporter(l, 0), nil
+	case "bar":
+		return progress.NewBarReporter(os.Stderr), nil
+	case "log":
+		return progress.NewLogReporter(l, 0), nil
+	case "json":
+		return progress.NewJSONReporter(os.Stderr), nil
+	case "none":
+		return progress.Nop{}, nil
+	default:
+		return nil, &usageError{fmt.Errorf("unsupported progress: %s", progressFormat)}
+	}
+}
+
+// getOptions returns the options for the functions of the kapsule package
+// that connect to the registries using the given registry options
+func getOptions(l *log.Logger, ro registry.Options) []kapsule.Option {
+	return []kapsule.Option{
+		kapsule.WithLogger(l),
+		kapsule.WithAuth(ro.Username, ro.Password),
+		kapsule.WithInsecure(ro.TLS.InsecureSkipVerify),
+		kapsule.WithPlainHTTP(ro.PlainHTTP),
+		kapsule.WithTLS(ro.TLS.CACert, ro.TLS.ClientCert, ro.TLS.ClientKey),
+		kapsule.WithRegistryConfig(ro.Config),
+		kapsule.WithCacheDir(ro.CacheDir),
 	}
+}
+
+// layerTypes are the Kapsule layer types that can be selected using
+// --encrypt-layers
+var layerTypes = []string{
+	types.LayerType(types.KAPSULE_MEDIA_TYPE_MODEL),
+	types.LayerType(types.KAPSULE_MEDIA_TYPE_ADAPTER),
+	types.LayerType(types.KAPSULE_MEDIA_TYPE_TEMPLATE),
+	types.LayerType(types.KAPSULE_MEDIA_TYPE_SYSTEM),
+	types.LayerType(types.KAPSULE_MEDIA_TYPE_PARAMETERS),
+	types.LayerType(types.KAPSULE_MEDIA_TYPE_LICENCE),
+}
+
+// getEncryptLayers validates --encrypt-layers, layers are selected by Kapsule
+// layer type or by media type and can only be selected when encrypting
+func getEncryptLayers(encrypt bool) ([]string, error) {
+	if len(encryptLayers) == 0 {
+		return nil, nil
+	}
+
+	if !encrypt {
+		return nil, &usageError{fmt.Errorf("'--encrypt-layers' requires an encryption key")}
+	}
+
+	for _, l := range encryptLayers {
+		if strings.Contains(l, "/") {
+			continue
+		}
+
+		valid := false
+		for _, t := range layerTypes {
+			valid = valid || l == t
+		}
 
-	if encryptionVaultPath != "" && encryptionVaultKey != "" && encryptionVaultAuthAddr != "" {
-		return keyproviders.NewVault(l, encryptionVaultPath, encryptionVaultKey, "", encryptionVaultAuthToken, encryptionVaultAuthAddr, encryptionVaultAuthNamespace), nil
+		if !valid {
+			return nil, &usageError{fmt.Errorf("unsupported layer type: %s, options: [%s] or a media type", l, strings.Join(layerTypes, ", "))}
+		}
 	}
 
-	return nil, fmt.Errorf("you must specify either a file based key or a vault key")
+	return encryptLayers, nil
 }
@@ -1,41 +1,166 @@
 package main
 
 import (
+	"context"
 	"testing"
 
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/nicholasjackson/kapsule/reader"
+	"github.com/nicholasjackson/kapsule/registry"
+	"github.com/nicholasjackson/kapsule/testutils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
 )
 
 func TestProviderReturnsErrorWithFileParamsAndVaultParams(t *testing.T) {
-	_, err := getKeyProvider(nil, "public", "private", "/keys/", "kapsule", "token", "addr", "namespace")
+	_, err := getKeyProvider(nil, []string{"public"}, "private", []string{"/keys/"}, "kapsule", "token", "addr", "namespace")
 	require.Error(t, err)
 }
 
 func TestProviderReturnsErrorWithMissingVaultParams(t *testing.T) {
-	_, err := getKeyProvider(nil, "", "", "kapsule", "", "", "", "")
+	_, err := getKeyProvider(nil, nil, "", []string{"kapsule"}, "", "", "", "")
 	require.Error(t, err)
 
-	_, err = getKeyProvider(nil, "", "", "/keys/", "", "addr", "", "")
+	_, err = getKeyProvider(nil, nil, "", []string{"/keys/"}, "", "addr", "", "")
 	require.Error(t, err)
 }
 
 func TestProviderReturnsFileProvider(t *testing.T) {
-	kp, err := getKeyProvider(nil, "public.key", "", "", "", "", "", "")
+	kp, err := getKeyProvider(nil, []string{"public.key"}, "", nil, "", "", "", "")
 	require.NoError(t, err)
 	require.NotNil(t, kp)
 	require.IsType(t, &keyproviders.File{}, kp)
 }
 func TestProviderReturnsVaultProvider(t *testing.T) {
-	kp, err := getKeyProvider(nil, "", "", "kapsule", "transit", "http://addr", "root", "")
+	kp, err := getKeyProvider(nil, nil, "", []string{"kapsule"}, "transit", "http://addr", "root", "")
 	require.NoError(t, err)
 	require.NotNil(t, kp)
 	require.IsType(t, &keyproviders.Vault{}, kp)
 }
 
+func TestProviderReturnsMultiProviderForSeveralKeys(t *testing.T) {
+	kp, err := getKeyProvider(testutils.CreateTestLogger(t), []string{"../test_fixtures/keys/public.key", "../test_fixtures/keys/breakglass_public.key"}, "../test_fixtures/keys/private.key", nil, "", "", "", "")
+	require.NoError(t, err)
+	require.IsType(t, &keyproviders.Multi{}, kp)
+
+	keys, err := keyproviders.PublicKeys(context.Background(), kp)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	_, err = kp.PrivateKey(context.Background())
+	require.NoError(t, err)
+
+	kp, err = getKeyProvider(nil, nil, "", []string{"production", "breakglass"}, "transit", "http://addr", "root", "")
+	require.NoError(t, err)
+	require.IsType(t, &keyproviders.Multi{}, kp)
+}
+
 func TestProviderReturnsNullProvider(t *testing.T) {
-	kp, err := getKeyProvider(nil, "", "", "", "", "", "", "")
+	kp, err := getKeyProvider(nil, nil, "", nil, "", "", "", "")
 	require.NoError(t, err)
 	require.NotNil(t, kp)
 	require.IsType(t, &keyproviders.NullProvider{}, kp)
 }
+
+func TestGetReaderReturnsLayoutReaderForLayoutScheme(t *testing.T) {
+	r, ref := getReader(nil, "oci-layout://./output:kapsule.io/nicholasjackson/test:v1", registry.Options{})
+	require.IsType(t, &reader.OCILayout{}, r)
+	require.Equal(t, "kapsule.io/nicholasjackson/test:v1", ref)
+}
+
+func TestGetReaderReturnsRegistryReader(t *testing.T) {
+	r, ref := getReader(nil, "docker.io/nicholasjackson/test:v1", registry.Options{})
+	require.IsType(t, &reader.OCIRegistry{}, r)
+	require.Equal(t, "docker.io/nicholasjackson/test:v1", ref)
+}
+
+func TestGetReaderReturnsOllamaReaderForOllamaScheme(t *testing.T) {
+	r, ref := getReader(nil, "ollama://./models:registry.ollama.ai/library/mistral:latest", registry.Options{})
+	require.IsType(t, &reader.Ollama{}, r)
+	require.Equal(t, "registry.ollama.ai/library/mistral:latest", ref)
+}
+
+func TestGetImageNameReturnsNameFromLayoutForTagOrDigest(t *testing.T) {
+	td := t.TempDir()
+
+	p, err := layout.Write(td, empty.Index)
+	require.NoError(t, err)
+
+	i, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	err = p.AppendImage(i, layout.WithAnnotations(map[string]string{ocispec.AnnotationRefName: "kapsule.io/nicholasjackson/test:plain"}))
+	require.NoError(t, err)
+
+	d, err := i.Digest()
+	require.NoError(t, err)
+
+	for _, ref := range []string{"oci:" + td + ":plain", "oci:" + td + ":" + d.String()} {
+		r, source := getReader(testutils.CreateTestLogger(t), ref, registry.Options{})
+
+		_, err = r.Pull(context.Background(), source)
+		require.NoError(t, err)
+
+		n, err := getImageName(context.Background(), r, source)
+		require.NoError(t, err, ref)
+		require.Equal(t, "kapsule.io/nicholasjackson/test:plain", n)
+	}
+}
+
+func TestGetImageNameReturnsRefForRegistry(t *testing.T) {
+	r, source := getReader(nil, "docker.io/nicholasjackson/test:v1", registry.Options{})
+
+	n, err := getImageName(context.Background(), r, source)
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/nicholasjackson/test:v1", n)
+}
+
+func TestProgressReporterReturnsReporterForFormat(t *testing.T) {
+	defer func() { progressFormat = "" }()
+
+	progressFormat = "json"
+	r, err := getProgressReporter(nil)
+	require.NoError(t, err)
+	require.IsType(t, &progress.JSONReporter{}, r)
+
+	progressFormat = "none"
+	r, err = getProgressReporter(nil)
+	require.NoError(t, err)
+	require.IsType(t, progress.Nop{}, r)
+}
+
+func TestProgressReporterReturnsUsageErrorForUnknownFormat(t *testing.T) {
+	defer func() { progressFormat = "" }()
+
+	progressFormat = "fancy"
+	_, err := getProgressReporter(nil)
+	require.Error(t, err)
+	require.IsType(t, &usageError{}, err)
+}
+
+func TestGetEncryptLayersValidatesLayerTypes(t *testing.T) {
+	t.Cleanup(func() { encryptLayers = nil })
+
+	encryptLayers = []string{"model", "application/vnd.kapsule.image.adapter+gzip"}
+	l, err := getEncryptLayers(true)
+	require.NoError(t, err)
+	require.Equal(t, encryptLayers, l)
+
+	encryptLayers = []string{"weights"}
+	_, err = getEncryptLayers(true)
+	require.Error(t, err)
+	require.IsType(t, &usageError{}, err)
+}
+
+func TestGetEncryptLayersRequiresEncryption(t *testing.T) {
+	t.Cleanup(func() { encryptLayers = nil })
+
+	encryptLayers = []string{"model"}
+	_, err := getEncryptLayers(false)
+	require.Error(t, err)
+	require.IsType(t, &usageError{}, err)
+}
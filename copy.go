@@ -0,0 +1,62 @@
+package kapsule
+
+import (
+	"context"
+
+	"github.com/nicholasjackson/kapsule/reader"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/nicholasjackson/kapsule/writer"
+)
+
+// Copy streams the image at src to dst without writing it to disk, both
+// references must be in remote registries. Blobs that already exist at dst
+// are skipped and when src and dst are in the same registry the blobs are
+// mounted rather than uploaded. Layers are decrypted when WithDecryption is
+// specified and encrypted for the recipient of WithEncryption, to re-encrypt
+// an encrypted image specify both.
+func Copy(ctx context.Context, src, dst string, opts ...Option) error {
+	o := newOptions(opts)
+
+	o.logger.Info("Copying image", "src", src, "dst", dst)
+
+	// the layers are streamed straight to the destination so there is no
+	// need to keep partially downloaded blobs
+	ro := o.registry
+	ro.CacheDir = ""
+
+	i, err := reader.NewOCIRegistry(o.logger, ro).Pull(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	if o.decryption != nil {
+		i, err = writer.DecryptImage(ctx, o.decryption, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	w := writer.NewOCIRegistry(o.logger, o.keyProvider(), ro)
+	w.SetJobs(o.jobs)
+	w.SetProgress(o.reporter())
+	w.SetEncryptLayers(o.encryptLayers)
+
+	if o.encryption == nil {
+		return w.Write(ctx, i, dst, false, false)
+	}
+
+	// encrypting layers that are already encrypted would make the image
+	// unreadable by the new recipient
+	if o.decryption == nil {
+		enc, err := writer.IsEncrypted(i)
+		if err != nil {
+			return err
+		}
+
+		if enc {
+			return types.Errorf(types.ErrorKindCrypto, "image %s is encrypted, a decryption key must be specified to re-encrypt it", src)
+		}
+	}
+
+	return w.WriteEncrypted(ctx, i, dst)
+}
@@ -8,6 +8,7 @@ import (
 	"hash"
 	"io"
 	"strings"
+	"sync"
 
 	"github.com/containers/ocicrypt"
 	"github.com/containers/ocicrypt/config"
@@ -20,37 +21,55 @@ import (
 
 type DecryptedLayer struct {
 	layer         v1.Layer
-	key           []byte
+	keys          [][]byte
 	decryptConfig *config.DecryptConfig
 	annotations   map[string]string
-	digest        v1.Hash
-	size          int64
-	done          bool
+
+	// mu guards the fields set once the layer has been read, the layer can
+	// be inspected while it is being written
+	mu     sync.Mutex
+	digest v1.Hash
+	size   int64
+	done   bool
 }
 
-func NewDecryptedLayer(l v1.Layer, key []byte, annotations map[string]string) (*DecryptedLayer, error) {
-	// check the key is a public key
-	priv, _ := utils.IsPrivateKey(key, nil)
-	if !priv {
-		return nil, fmt.Errorf("gven key is not a private key")
+// NewDecryptedLayer returns a layer that is decrypted as it is read, each of
+// the private keys is tried until one can unwrap the key of the layer
+func NewDecryptedLayer(l v1.Layer, keys [][]byte, annotations map[string]string) (*DecryptedLayer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no private key given")
+	}
+
+	// check the keys are private keys
+	passwords := [][]byte{}
+	for i, k := range keys {
+		priv, _ := utils.IsPrivateKey(k, nil)
+		if !priv {
+			return nil, fmt.Errorf("given key %d is not a private key", i+1)
+		}
+
+		passwords = append(passwords, []byte(""))
 	}
 
 	dc := &config.DecryptConfig{
 		Parameters: map[string][][]byte{
-			"privkeys":           [][]byte{key},
-			"privkeys-passwords": [][]byte{[]byte("")},
+			"privkeys":           keys,
+			"privkeys-passwords": passwords,
 		},
 	}
 
 	return &DecryptedLayer{
 		layer:         l,
-		key:           key,
+		keys:          keys,
 		decryptConfig: dc,
 		annotations:   annotations,
 	}, nil
 }
 
 func (el *DecryptedLayer) Digest() (v1.Hash, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
 	if el.done {
 		return el.digest, nil
 	}
@@ -62,6 +81,9 @@ func (el *DecryptedLayer) Digest() (v1.Hash, error) {
 }
 
 func (el *DecryptedLayer) Annotations() (map[string]string, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
 	if el.done {
 		// return the annotations that are created by the encryption process
 		// this can only be called after the layer has been consumed
@@ -107,6 +129,9 @@ func (el *DecryptedLayer) Compressed() (io.ReadCloser, error) {
 			return fmt.Errorf("unable to create hash from encrypted data: %w", err)
 		}
 
+		el.mu.Lock()
+		defer el.mu.Unlock()
+
 		el.digest = digest
 		el.size = count
 
@@ -130,6 +155,9 @@ func (el *DecryptedLayer) Uncompressed() (io.ReadCloser, error) {
 }
 
 func (el *DecryptedLayer) Size() (int64, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
 	if el.done {
 		return int64(el.size), nil
 	}
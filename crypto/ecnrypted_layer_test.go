@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"io"
 	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/containers/ocicrypt"
@@ -87,3 +88,63 @@ func TestEncryptedLayerEncryptsContent(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "hello world", string(d))
 }
+
+func TestEncryptedLayerCanBeDecryptedByEachRecipient(t *testing.T) {
+	l := stream.NewLayer(
+		io.NopCloser(bytes.NewReader([]byte("hello world"))),
+		stream.WithCompressionLevel(gzip.DefaultCompression),
+		stream.WithMediaType(types.KAPSULE_MEDIA_TYPE_TEMPLATE),
+	)
+
+	pub, err := os.ReadFile("../test_fixtures/keys/public.key")
+	require.NoError(t, err)
+
+	breakGlassPub, err := os.ReadFile("../test_fixtures/keys/breakglass_public.key")
+	require.NoError(t, err)
+
+	el, err := NewEncryptedLayer(l, pub, breakGlassPub)
+	require.NoError(t, err)
+
+	rc, err := el.Compressed()
+	require.NoError(t, err)
+
+	d, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	ann, err := el.Annotations()
+	require.NoError(t, err)
+
+	r, err := Recipients(ann)
+	require.NoError(t, err)
+	require.Len(t, r, 2)
+
+	for _, k := range []string{"../test_fixtures/keys/private.key", "../test_fixtures/keys/breakglass_private.key"} {
+		priv, err := os.ReadFile(k)
+		require.NoError(t, err)
+
+		dc := &config.DecryptConfig{
+			Parameters: map[string][][]byte{
+				"privkeys":           {priv},
+				"privkeys-passwords": {[]byte("")},
+			},
+		}
+
+		dr, _, err := ocicrypt.DecryptLayer(dc, bytes.NewReader(d), ocispec.Descriptor{Annotations: ann}, false)
+		require.NoError(t, err, k)
+
+		gz, err := gzip.NewReader(dr)
+		require.NoError(t, err)
+
+		plain, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		require.Equal(t, "hello world", string(plain))
+	}
+}
+
+func TestEncryptedLayerReturnsErrorWithoutPublicKey(t *testing.T) {
+	l := stream.NewLayer(io.NopCloser(bytes.NewReader([]byte("hello world"))))
+
+	_, err := NewEncryptedLayer(l)
+	require.Error(t, err)
+}
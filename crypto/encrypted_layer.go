@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"sync"
 
 	"github.com/containers/ocicrypt"
 	"github.com/containers/ocicrypt/config"
@@ -19,36 +20,51 @@ import (
 
 type EncryptedLayer struct {
 	layer         v1.Layer
-	key           []byte
+	keys          [][]byte
 	encryptConfig *config.EncryptConfig
-	annotations   map[string]string
-	digest        v1.Hash
-	size          int64
-	done          bool
+
+	// mu guards the fields set once the layer has been read, the layer can
+	// be inspected while it is being written
+	mu          sync.Mutex
+	annotations map[string]string
+	digest      v1.Hash
+	size        int64
+	done        bool
 }
 
-func NewEncryptedLayer(l v1.Layer, key []byte) (*EncryptedLayer, error) {
-	// check the key is a public key
-	pub := utils.IsPublicKey(key)
-	if !pub {
-		return nil, fmt.Errorf("gven key is not a public key")
+// NewEncryptedLayer returns a layer that is encrypted as it is read, the key
+// used to encrypt the layer is wrapped for each of the given public keys so
+// that the layer can be decrypted with any of the matching private keys
+func NewEncryptedLayer(l v1.Layer, keys ...[]byte) (*EncryptedLayer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public key given")
+	}
+
+	// check the keys are public keys
+	for i, k := range keys {
+		if !utils.IsPublicKey(k) {
+			return nil, fmt.Errorf("given key %d is not a public key", i+1)
+		}
 	}
 
 	ec := &config.EncryptConfig{
 		Parameters: map[string][][]byte{
-			"pubkeys": {key},
+			"pubkeys": keys,
 		},
 		DecryptConfig: config.DecryptConfig{},
 	}
 
 	return &EncryptedLayer{
 		layer:         l,
-		key:           key,
+		keys:          keys,
 		encryptConfig: ec,
 	}, nil
 }
 
 func (el *EncryptedLayer) Digest() (v1.Hash, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
 	if el.done {
 		return el.digest, nil
 	}
@@ -60,6 +76,9 @@ func (el *EncryptedLayer) Digest() (v1.Hash, error) {
 }
 
 func (el *EncryptedLayer) Annotations() (map[string]string, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
 	if el.done {
 		// return the annotations that are created by the encryption process
 		// this can only be called after the layer has been consumed
@@ -76,7 +95,11 @@ func (el *EncryptedLayer) DiffID() (v1.Hash, error) {
 }
 
 func (el *EncryptedLayer) Compressed() (io.ReadCloser, error) {
-	if el.done {
+	el.mu.Lock()
+	done := el.done
+	el.mu.Unlock()
+
+	if done {
 		// with a standard layer we can only compress the data once and if the stream
 		// has been written we should return an error. However with an ecnypted layer
 		// the image is written twice, once with the encrypted data then the manifest
@@ -114,15 +137,17 @@ func (el *EncryptedLayer) Compressed() (io.ReadCloser, error) {
 			return fmt.Errorf("unable to get annotations from encrypted reader: %w", err)
 		}
 
-		// set the annotations on the encrypted layer
-		el.annotations = annot
-
 		// get the hash of the encrypted data
 		digest, err := v1.NewHash("sha256:" + hex.EncodeToString(h.Sum(nil)))
 		if err != nil {
 			return fmt.Errorf("unable to create hash from encrypted data: %w", err)
 		}
 
+		el.mu.Lock()
+		defer el.mu.Unlock()
+
+		// set the annotations on the encrypted layer
+		el.annotations = annot
 		el.digest = digest
 		el.size = count
 
@@ -146,6 +171,9 @@ func (el *EncryptedLayer) Uncompressed() (io.ReadCloser, error) {
 }
 
 func (el *EncryptedLayer) Size() (int64, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
 	if el.size == 0 {
 		return 0, stream.ErrNotComputed
 	}
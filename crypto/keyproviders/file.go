@@ -1,6 +1,7 @@
 package keyproviders
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -19,7 +20,7 @@ func NewFile(publicKeyPath, privateKeyPath string) *File {
 	}
 }
 
-func (kp *File) PublicKey() ([]byte, error) {
+func (kp *File) PublicKey(ctx context.Context) ([]byte, error) {
 	if kp.publicKeyPath == "" {
 		return nil, fmt.Errorf("no public key configured")
 	}
@@ -36,7 +37,7 @@ func (kp *File) PublicKey() ([]byte, error) {
 	return d, nil
 }
 
-func (kp *File) PrivateKey() ([]byte, error) {
+func (kp *File) PrivateKey(ctx context.Context) ([]byte, error) {
 	if kp.privateKeyPath == "" {
 		return nil, fmt.Errorf("no private key configured")
 	}
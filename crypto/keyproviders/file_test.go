@@ -1,6 +1,7 @@
 package keyproviders
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -9,7 +10,7 @@ import (
 func TestReturnsErrorWhenNoPublicKey(t *testing.T) {
 	kp := NewFile("./notexists.key", "")
 
-	_, err := kp.PublicKey()
+	_, err := kp.PublicKey(context.Background())
 
 	require.Error(t, err)
 }
@@ -17,7 +18,7 @@ func TestReturnsErrorWhenNoPublicKey(t *testing.T) {
 func TestReturnsErrorWhenNotPublicKey(t *testing.T) {
 	kp := NewFile("../../test_fixtures/keys/private.key", "")
 
-	_, err := kp.PublicKey()
+	_, err := kp.PublicKey(context.Background())
 
 	require.Error(t, err)
 }
@@ -25,7 +26,7 @@ func TestReturnsErrorWhenNotPublicKey(t *testing.T) {
 func TestReturnsPublicKey(t *testing.T) {
 	kp := NewFile("../../test_fixtures/keys/public.key", "")
 
-	_, err := kp.PublicKey()
+	_, err := kp.PublicKey(context.Background())
 
 	require.NoError(t, err)
 }
@@ -33,7 +34,7 @@ func TestReturnsPublicKey(t *testing.T) {
 func TestReturnsErrorWhenNoPrivateKey(t *testing.T) {
 	kp := NewFile("", "./notexists.key")
 
-	_, err := kp.PrivateKey()
+	_, err := kp.PrivateKey(context.Background())
 
 	require.Error(t, err)
 }
@@ -41,7 +42,7 @@ func TestReturnsErrorWhenNoPrivateKey(t *testing.T) {
 func TestReturnsErrorWhenNotPrivateKey(t *testing.T) {
 	kp := NewFile("", "../../test_fixtures/keys/public.key")
 
-	_, err := kp.PrivateKey()
+	_, err := kp.PrivateKey(context.Background())
 
 	require.Error(t, err)
 }
@@ -49,7 +50,7 @@ func TestReturnsErrorWhenNotPrivateKey(t *testing.T) {
 func TestReturnsPrivateKey(t *testing.T) {
 	kp := NewFile("", "../../test_fixtures/keys/private.key")
 
-	_, err := kp.PrivateKey()
+	_, err := kp.PrivateKey(context.Background())
 
 	require.NoError(t, err)
 }
@@ -0,0 +1,93 @@
+package keyproviders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/charmbracelet/log"
+)
+
+// Multi combines several providers so that images are encrypted for the
+// recipients of every provider, i.e. a production key and a break glass key
+type Multi struct {
+	logger    *log.Logger
+	providers []Provider
+}
+
+func NewMulti(l *log.Logger, providers ...Provider) *Multi {
+	return &Multi{
+		logger:    l,
+		providers: providers,
+	}
+}
+
+// PublicKey returns the public key of the first provider
+func (kp *Multi) PublicKey(ctx context.Context) ([]byte, error) {
+	if len(kp.providers) == 0 {
+		return nil, fmt.Errorf("no public key configured")
+	}
+
+	return kp.providers[0].PublicKey(ctx)
+}
+
+// PublicKeys returns the public key of each provider
+func (kp *Multi) PublicKeys(ctx context.Context) ([][]byte, error) {
+	if len(kp.providers) == 0 {
+		return nil, fmt.Errorf("no public key configured")
+	}
+
+	keys := [][]byte{}
+	for _, p := range kp.providers {
+		k, err := PublicKeys(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k...)
+	}
+
+	return keys, nil
+}
+
+// PrivateKey returns the private key of the first provider that has one
+func (kp *Multi) PrivateKey(ctx context.Context) ([]byte, error) {
+	keys, err := kp.PrivateKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys[0], nil
+}
+
+// PrivateKeys returns the private key of each provider that has one, the
+// error of each provider is returned when none of them have a key. When
+// some of the providers have a key the errors of the others are logged.
+func (kp *Multi) PrivateKeys(ctx context.Context) ([][]byte, error) {
+	keys := [][]byte{}
+	errs := []error{}
+
+	for _, p := range kp.providers {
+		k, err := PrivateKeys(ctx, p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		keys = append(keys, k...)
+	}
+
+	if len(keys) > 0 {
+		for _, err := range errs {
+			kp.logger.Warn("Unable to get private key, using the keys of the other providers", "error", err)
+		}
+
+		return keys, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no private key configured")
+	}
+
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,96 @@
+package keyproviders
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiReturnsPublicKeyOfEachProvider(t *testing.T) {
+	kp := NewMulti(
+		testutils.CreateTestLogger(t),
+		NewFile("../../test_fixtures/keys/public.key", ""),
+		NewFile("../../test_fixtures/keys/breakglass_public.key", ""),
+	)
+
+	keys, err := PublicKeys(context.Background(), kp)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.NotEqual(t, keys[0], keys[1])
+
+	k, err := kp.PublicKey(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, keys[0], k)
+}
+
+func TestMultiReturnsErrorWhenPublicKeyInvalid(t *testing.T) {
+	kp := NewMulti(
+		testutils.CreateTestLogger(t),
+		NewFile("../../test_fixtures/keys/public.key", ""),
+		NewFile("./notexists.key", ""),
+	)
+
+	_, err := kp.PublicKeys(context.Background())
+	require.Error(t, err)
+}
+
+func TestMultiReturnsFirstPrivateKey(t *testing.T) {
+	kp := NewMulti(
+		testutils.CreateTestLogger(t),
+		NewFile("../../test_fixtures/keys/public.key", ""),
+		NewFile("../../test_fixtures/keys/breakglass_public.key", "../../test_fixtures/keys/breakglass_private.key"),
+	)
+
+	k, err := kp.PrivateKey(context.Background())
+	require.NoError(t, err)
+
+	bg, err := NewFile("", "../../test_fixtures/keys/breakglass_private.key").PrivateKey(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, bg, k)
+}
+
+func TestMultiReturnsErrorWhenNoPrivateKey(t *testing.T) {
+	kp := NewMulti(testutils.CreateTestLogger(t), NewFile("../../test_fixtures/keys/public.key", ""))
+
+	_, err := kp.PrivateKey(context.Background())
+	require.Error(t, err)
+}
+
+func TestPublicKeysReturnsKeyOfSingleProvider(t *testing.T) {
+	keys, err := PublicKeys(context.Background(), NewFile("../../test_fixtures/keys/public.key", ""))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+}
+
+func TestMultiReturnsPrivateKeyOfEachProvider(t *testing.T) {
+	kp := NewMulti(
+		testutils.CreateTestLogger(t),
+		NewFile("", "../../test_fixtures/keys/private.key"),
+		NewFile("../../test_fixtures/keys/breakglass_public.key", ""),
+		NewFile("", "../../test_fixtures/keys/breakglass_private.key"),
+	)
+
+	keys, err := PrivateKeys(context.Background(), kp)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.NotEqual(t, keys[0], keys[1])
+}
+
+func TestMultiLogsErrorsOfProvidersWithoutPrivateKey(t *testing.T) {
+	bf := &bytes.Buffer{}
+
+	kp := NewMulti(
+		log.New(bf),
+		NewFile("", "./notexists.key"),
+		NewFile("", "../../test_fixtures/keys/breakglass_private.key"),
+	)
+
+	keys, err := PrivateKeys(context.Background(), kp)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Contains(t, bf.String(), "notexists.key")
+}
@@ -1,13 +1,16 @@
 package keyproviders
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type NullProvider struct{}
 
-func (n *NullProvider) PublicKey() ([]byte, error) {
+func (n *NullProvider) PublicKey(ctx context.Context) ([]byte, error) {
 	return nil, fmt.Errorf("no public key available")
 }
 
-func (n *NullProvider) PrivateKey() ([]byte, error) {
+func (n *NullProvider) PrivateKey(ctx context.Context) ([]byte, error) {
 	return nil, fmt.Errorf("no private key available")
 }
@@ -1,8 +1,49 @@
 package keyproviders
 
+import "context"
+
 type Provider interface {
 	// PublicKey returns the public key
-	PublicKey() ([]byte, error)
+	PublicKey(ctx context.Context) ([]byte, error)
 	// PrivateKey returns the private key
-	PrivateKey() ([]byte, error)
+	PrivateKey(ctx context.Context) ([]byte, error)
+}
+
+// MultiProvider is a provider that encrypts images for several recipients
+type MultiProvider interface {
+	Provider
+	// PublicKeys returns the public key of each recipient
+	PublicKeys(ctx context.Context) ([][]byte, error)
+	// PrivateKeys returns the private key of each recipient that has one
+	PrivateKeys(ctx context.Context) ([][]byte, error)
+}
+
+// PublicKeys returns the public keys of every recipient of the provider,
+// providers with a single recipient return their public key
+func PublicKeys(ctx context.Context, kp Provider) ([][]byte, error) {
+	if mp, ok := kp.(MultiProvider); ok {
+		return mp.PublicKeys(ctx)
+	}
+
+	k, err := kp.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{k}, nil
+}
+
+// PrivateKeys returns the private keys of every recipient of the provider,
+// providers with a single recipient return their private key
+func PrivateKeys(ctx context.Context, kp Provider) ([][]byte, error) {
+	if mp, ok := kp.(MultiProvider); ok {
+		return mp.PrivateKeys(ctx)
+	}
+
+	k, err := kp.PrivateKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{k}, nil
 }
@@ -1,16 +1,22 @@
 package keyproviders
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 )
 
+// vaultRequestTimeout is the maximum time to wait for a response from Vault
+const vaultRequestTimeout = 30 * time.Second
+
 type Vault struct {
+	client        *http.Client
 	logger        *log.Logger
 	transitPath   string
 	key           string
@@ -28,6 +34,7 @@ func NewVault(l *log.Logger, transitPath, key, version, authToken, authAddr, aut
 	}
 
 	return &Vault{
+		client:        &http.Client{Timeout: vaultRequestTimeout},
 		logger:        l,
 		transitPath:   strings.TrimSuffix(strings.TrimPrefix(transitPath, "/"), "/"),
 		key:           key,
@@ -38,12 +45,12 @@ func NewVault(l *log.Logger, transitPath, key, version, authToken, authAddr, aut
 	}
 }
 
-func (kp *Vault) PublicKey() ([]byte, error) {
+func (kp *Vault) PublicKey(ctx context.Context) ([]byte, error) {
 	if kp.publicKey != nil {
 		return kp.publicKey, nil
 	}
 
-	k, err := kp.getVaultKey("public-key")
+	k, err := kp.getVaultKey(ctx, "public-key")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get public key: %w", err)
 	}
@@ -54,12 +61,12 @@ func (kp *Vault) PublicKey() ([]byte, error) {
 	return kp.publicKey, nil
 }
 
-func (kp *Vault) PrivateKey() ([]byte, error) {
+func (kp *Vault) PrivateKey(ctx context.Context) ([]byte, error) {
 	if kp.privateKey != nil {
 		return kp.privateKey, nil
 	}
 
-	k, err := kp.getVaultKey("encryption-key")
+	k, err := kp.getVaultKey(ctx, "encryption-key")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get private key: %w", err)
 	}
@@ -70,9 +77,9 @@ func (kp *Vault) PrivateKey() ([]byte, error) {
 	return kp.privateKey, nil
 }
 
-func (kp *Vault) getVaultKey(keyType string) ([]byte, error) {
+func (kp *Vault) getVaultKey(ctx context.Context, keyType string) ([]byte, error) {
 	path := fmt.Sprintf("%s/v1/%s/export/%s/%s/%s", kp.authAddr, kp.transitPath, keyType, kp.key, kp.version)
-	r, err := http.NewRequest("GET", path, nil)
+	r, err := http.NewRequestWithContext(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -87,7 +94,7 @@ func (kp *Vault) getVaultKey(keyType string) ([]byte, error) {
 	}
 
 	// send the request
-	resp, err := http.DefaultClient.Do(r)
+	resp, err := kp.client.Do(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -1,6 +1,9 @@
 package keyproviders
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -23,7 +26,7 @@ func TestACCVaultReturnsErrorWhenNoPublicKey(t *testing.T) {
 
 	kp := setupVault(t, "notfound")
 
-	_, err := kp.PublicKey()
+	_, err := kp.PublicKey(context.Background())
 	require.Error(t, err)
 }
 
@@ -34,7 +37,7 @@ func TestACCVaultReturnsPublicKey(t *testing.T) {
 
 	kp := setupVault(t, "kapsule")
 
-	k, err := kp.PublicKey()
+	k, err := kp.PublicKey(context.Background())
 	require.NoError(t, err)
 
 	// check is valid public key
@@ -48,7 +51,7 @@ func TestACCVaultReturnsErrorWhenNoPrivateKey(t *testing.T) {
 
 	kp := setupVault(t, "notfound")
 
-	_, err := kp.PrivateKey()
+	_, err := kp.PrivateKey(context.Background())
 	require.Error(t, err)
 }
 
@@ -59,10 +62,25 @@ func TestACCVaultReturnsPrivateKey(t *testing.T) {
 
 	kp := setupVault(t, "kapsule")
 
-	k, err := kp.PrivateKey()
+	k, err := kp.PrivateKey(context.Background())
 	require.NoError(t, err)
 
 	// check is valid public key
 	isPK, _ := utils.IsPrivateKey(k, nil)
 	require.True(t, isPK, "Invalid private key")
 }
+
+func TestVaultReturnsErrorWhenContextCancelled(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(s.Close)
+
+	kp := NewVault(testutils.CreateTestLogger(t), "transit", "kapsule", "latest", "root", s.URL, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := kp.PublicKey(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JWEAnnotation is the layer annotation containing the wrapped layer keys,
+// the value is a comma separated list of base64 encoded JWE messages
+const JWEAnnotation = "org.opencontainers.image.enc.keys.jwe"
+
+// Recipient describes a key that can decrypt an encrypted layer
+type Recipient struct {
+	// Algorithm is the JWE key management algorithm i.e. RSA-OAEP
+	Algorithm string `json:"algorithm"`
+	// KeyID is the kid header, it is empty unless set when encrypting
+	KeyID string `json:"key_id,omitempty"`
+	// KeySize is the size in bits of RSA keys, it is derived from the
+	// length of the wrapped key
+	KeySize int `json:"key_size,omitempty"`
+}
+
+// jweHeader is the subset of the JWE header used to describe a recipient
+type jweHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type jweRecipient struct {
+	Header       jweHeader `json:"header"`
+	EncryptedKey string    `json:"encrypted_key"`
+}
+
+// jweMessage is a JWE using the JSON serialization, messages with a single
+// recipient use the flattened form where the recipient is not in a list
+type jweMessage struct {
+	Protected    string         `json:"protected"`
+	Header       jweHeader      `json:"unprotected"`
+	Recipients   []jweRecipient `json:"recipients"`
+	RecipientHdr jweHeader      `json:"header"`
+	EncryptedKey string         `json:"encrypted_key"`
+}
+
+// Recipients returns the recipients of an encrypted layer from the JWE
+// annotation, layers without the annotation have no recipients
+func Recipients(annotations map[string]string) ([]Recipient, error) {
+	v := annotations[JWEAnnotation]
+	if v == "" {
+		return nil, nil
+	}
+
+	recipients := []Recipient{}
+
+	for _, enc := range strings.Split(v, ",") {
+		d, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode JWE: %w", err)
+		}
+
+		m := jweMessage{}
+		err = json.Unmarshal(d, &m)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse JWE: %w", err)
+		}
+
+		// headers shared by all recipients are in the protected header
+		shared := jweHeader{}
+		if m.Protected != "" {
+			p, err := base64.RawURLEncoding.DecodeString(m.Protected)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode JWE protected header: %w", err)
+			}
+
+			err = json.Unmarshal(p, &shared)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse JWE protected header: %w", err)
+			}
+		}
+
+		rs := m.Recipients
+		if len(rs) == 0 {
+			rs = []jweRecipient{{Header: m.RecipientHdr, EncryptedKey: m.EncryptedKey}}
+		}
+
+		for _, r := range rs {
+			recipients = append(recipients, newRecipient(r, shared, m.Header))
+		}
+	}
+
+	return recipients, nil
+}
+
+// newRecipient merges the per recipient header with the shared headers, the
+// per recipient header takes precedence
+func newRecipient(r jweRecipient, headers ...jweHeader) Recipient {
+	rc := Recipient{Algorithm: r.Header.Algorithm, KeyID: r.Header.KeyID}
+
+	for _, h := range headers {
+		if rc.Algorithm == "" {
+			rc.Algorithm = h.Algorithm
+		}
+
+		if rc.KeyID == "" {
+			rc.KeyID = h.KeyID
+		}
+	}
+
+	// the wrapped key of RSA algorithms is the size of the modulus
+	if strings.HasPrefix(rc.Algorithm, "RSA") {
+		if k, err := base64.RawURLEncoding.DecodeString(r.EncryptedKey); err == nil {
+			rc.KeySize = len(k) * 8
+		}
+	}
+
+	return rc
+}
+
+// String returns the recipient i.e. RSA-OAEP 2048-bit
+func (r Recipient) String() string {
+	s := r.Algorithm
+	if r.KeySize > 0 {
+		s += fmt.Sprintf(" %d-bit", r.KeySize)
+	}
+
+	if r.KeyID != "" {
+		s += " " + r.KeyID
+	}
+
+	return s
+}
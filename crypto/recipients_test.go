@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/stream"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecipientsReturnsRecipientOfEncryptedLayer(t *testing.T) {
+	l := stream.NewLayer(
+		io.NopCloser(bytes.NewReader([]byte("hello world"))),
+		stream.WithCompressionLevel(gzip.DefaultCompression),
+		stream.WithMediaType(types.KAPSULE_MEDIA_TYPE_TEMPLATE),
+	)
+
+	pub, err := os.ReadFile("../test_fixtures/keys/public.key")
+	require.NoError(t, err)
+
+	el, err := NewEncryptedLayer(l, pub)
+	require.NoError(t, err)
+
+	rc, err := el.Compressed()
+	require.NoError(t, err)
+
+	_, err = io.Copy(io.Discard, rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	ann, err := el.Annotations()
+	require.NoError(t, err)
+
+	r, err := Recipients(ann)
+	require.NoError(t, err)
+	require.Len(t, r, 1)
+	require.Equal(t, "RSA-OAEP", r[0].Algorithm)
+	require.Equal(t, 1024, r[0].KeySize)
+}
+
+func TestRecipientsReturnsEachRecipientOfGeneralJWE(t *testing.T) {
+	key := base64.RawURLEncoding.EncodeToString(make([]byte, 256))
+	jwe := `{"protected":"` + base64.RawURLEncoding.EncodeToString([]byte(`{"enc":"A256GCM"}`)) + `",` +
+		`"recipients":[` +
+		`{"header":{"alg":"RSA-OAEP","kid":"production"},"encrypted_key":"` + key + `"},` +
+		`{"header":{"alg":"ECDH-ES+A256KW"},"encrypted_key":"abcd"}]}`
+
+	r, err := Recipients(map[string]string{JWEAnnotation: base64.StdEncoding.EncodeToString([]byte(jwe))})
+	require.NoError(t, err)
+	require.Len(t, r, 2)
+	require.Equal(t, Recipient{Algorithm: "RSA-OAEP", KeyID: "production", KeySize: 2048}, r[0])
+	require.Equal(t, "RSA-OAEP 2048-bit production", r[0].String())
+	require.Equal(t, Recipient{Algorithm: "ECDH-ES+A256KW"}, r[1])
+}
+
+func TestRecipientsReturnsNoneForPlainLayer(t *testing.T) {
+	r, err := Recipients(map[string]string{})
+	require.NoError(t, err)
+	require.Empty(t, r)
+}
+
+func TestRecipientsReturnsErrorForInvalidAnnotation(t *testing.T) {
+	_, err := Recipients(map[string]string{JWEAnnotation: "not base64!"})
+	require.Error(t, err)
+}
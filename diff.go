@@ -0,0 +1,251 @@
+package kapsule
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// layer statuses reported by Diff
+const (
+	DiffIdentical = "identical"
+	DiffChanged   = "changed"
+	DiffAdded     = "added"
+	DiffRemoved   = "removed"
+	// DiffUnknown is reported when the digests of encrypted layers differ,
+	// layers are encrypted with a new key each time so the contents may
+	// still be the same
+	DiffUnknown = "unknown"
+)
+
+// ImageDiff describes the differences between two images
+type ImageDiff struct {
+	A       string `json:"a"`
+	B       string `json:"b"`
+	DigestA string `json:"digest_a"`
+	DigestB string `json:"digest_b"`
+	// Identical is true when both refs point at the same manifest
+	Identical bool `json:"identical"`
+	// WeightsIdentical is true when the model and adapter layers of both
+	// images have the same digests
+	WeightsIdentical bool        `json:"weights_identical"`
+	Layers           []LayerDiff `json:"layers"`
+	// Template and System are unified diffs, they are empty when the
+	// template or system prompt has not changed
+	Template   string      `json:"template,omitempty"`
+	System     string      `json:"system,omitempty"`
+	Parameters []ValueDiff `json:"parameters,omitempty"`
+	Labels     []ValueDiff `json:"labels,omitempty"`
+	Config     []ValueDiff `json:"config,omitempty"`
+}
+
+// LayerDiff compares the layers of the same type in both images, layers
+// with the same type are compared in the order they appear in the image
+type LayerDiff struct {
+	Type    string `json:"type"`
+	DigestA string `json:"digest_a,omitempty"`
+	DigestB string `json:"digest_b,omitempty"`
+	SizeA   int64  `json:"size_a,omitempty"`
+	SizeB   int64  `json:"size_b,omitempty"`
+	Status  string `json:"status"`
+}
+
+// ValueDiff is a key that has been added, removed or changed
+type ValueDiff struct {
+	Key    string `json:"key"`
+	A      string `json:"a,omitempty"`
+	B      string `json:"b,omitempty"`
+	Status string `json:"status"`
+}
+
+// Diff compares the images at a and b layer by layer. Like Inspect only the
+// manifest, config and small layers are fetched so the template, system
+// prompt and parameters can be compared without downloading the weights.
+// Encrypted templates and parameters are compared when WithDecryption is
+// specified.
+func Diff(ctx context.Context, a, b string, opts ...Option) (*ImageDiff, error) {
+	da, err := Inspect(ctx, a, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect %s: %w", a, err)
+	}
+
+	db, err := Inspect(ctx, b, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect %s: %w", b, err)
+	}
+
+	return diffDetails(da, db), nil
+}
+
+func diffDetails(a, b *ImageDetails) *ImageDiff {
+	d := &ImageDiff{
+		A:                a.Ref,
+		B:                b.Ref,
+		DigestA:          a.Digest,
+		DigestB:          b.Digest,
+		Identical:        a.Digest == b.Digest,
+		WeightsIdentical: true,
+		Layers:           diffLayers(a.Layers, b.Layers),
+		Template:         unifiedDiff(a.Ref+" template", b.Ref+" template", a.Template, b.Template),
+		System:           unifiedDiff(a.Ref+" system", b.Ref+" system", a.System, b.System),
+		Parameters:       diffValues(joinParameters(a.Parameters), joinParameters(b.Parameters)),
+		Labels:           diffValues(a.Config.Labels, b.Config.Labels),
+		Config:           diffValues(configValues(a), configValues(b)),
+	}
+
+	for _, l := range d.Layers {
+		if (l.Type == "model" || l.Type == "adapter") && l.Status != DiffIdentical {
+			d.WeightsIdentical = false
+		}
+	}
+
+	return d
+}
+
+// diffLayers pairs the layers of each type in the order they appear in the
+// images, layers without a Kapsule type are paired by media type
+func diffLayers(a, b []LayerDetails) []LayerDiff {
+	key := func(l LayerDetails) string {
+		if l.Type != "" {
+			return l.Type
+		}
+
+		return l.MediaType
+	}
+
+	byKey := map[string][]LayerDetails{}
+	for _, l := range b {
+		byKey[key(l)] = append(byKey[key(l)], l)
+	}
+
+	paired := map[string]int{}
+	diffs := []LayerDiff{}
+
+	for _, la := range a {
+		k := key(la)
+		ld := LayerDiff{Type: k, DigestA: la.Digest, SizeA: la.Size, Status: DiffRemoved}
+
+		if i := paired[k]; i < len(byKey[k]) {
+			lb := byKey[k][i]
+			paired[k]++
+
+			ld.DigestB = lb.Digest
+			ld.SizeB = lb.Size
+
+			switch {
+			case la.Digest == lb.Digest:
+				ld.Status = DiffIdentical
+			case la.Encrypted || lb.Encrypted:
+				ld.Status = DiffUnknown
+			default:
+				ld.Status = DiffChanged
+			}
+		}
+
+		diffs = append(diffs, ld)
+	}
+
+	// layers of b that have not been paired with a layer of a
+	for _, lb := range b {
+		k := key(lb)
+		if paired[k] > 0 {
+			paired[k]--
+			continue
+		}
+
+		diffs = append(diffs, LayerDiff{Type: k, DigestB: lb.Digest, SizeB: lb.Size, Status: DiffAdded})
+	}
+
+	return diffs
+}
+
+// unifiedDiff returns the unified diff of the contents of a and b, the
+// result is empty when the contents are the same
+func unifiedDiff(from, to, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	ud := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: from,
+		ToFile:   to,
+		Context:  3,
+	}
+
+	s, err := difflib.GetUnifiedDiffString(ud)
+	if err != nil {
+		return ""
+	}
+
+	return s
+}
+
+// diffValues returns the keys that have been added, removed or changed
+// sorted by key
+func diffValues(a, b map[string]string) []ValueDiff {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+
+	for k := range b {
+		keys[k] = true
+	}
+
+	diffs := []ValueDiff{}
+	for k := range keys {
+		va, inA := a[k]
+		vb, inB := b[k]
+
+		switch {
+		case !inA:
+			diffs = append(diffs, ValueDiff{Key: k, B: vb, Status: DiffAdded})
+		case !inB:
+			diffs = append(diffs, ValueDiff{Key: k, A: va, Status: DiffRemoved})
+		case va != vb:
+			diffs = append(diffs, ValueDiff{Key: k, A: va, B: vb, Status: DiffChanged})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+
+	return diffs
+}
+
+// joinParameters returns the parameters with multiple values, such as stop,
+// joined into a single value
+func joinParameters(p map[string][]string) map[string]string {
+	m := map[string]string{}
+	for k, v := range p {
+		m[k] = strings.Join(v, ", ")
+	}
+
+	return m
+}
+
+// configValues returns the metadata of the config that is compared
+func configValues(d *ImageDetails) map[string]string {
+	m := map[string]string{
+		"media_type":   d.MediaType,
+		"architecture": d.Config.Architecture,
+		"os":           d.Config.OS,
+	}
+
+	if d.Config.Created != nil {
+		m["created"] = d.Config.Created.Format(time.RFC3339)
+	}
+
+	for k, v := range m {
+		if v == "" {
+			delete(m, k)
+		}
+	}
+
+	return m
+}
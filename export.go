@@ -0,0 +1,59 @@
+package kapsule
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/nicholasjackson/kapsule/writer"
+)
+
+// Export writes the image to the folder at path using the format set by
+// WithFormat. Layers are encrypted when WithEncryption is specified or
+// decrypted when WithDecryption is specified. For FormatOllamaAPI path is
+// the address of the Ollama server, if empty OLLAMA_HOST or the default
+// address is used.
+func Export(ctx context.Context, image v1.Image, ref, path string, opts ...Option) error {
+	o := newOptions(opts)
+
+	o.logger.Info("Exporting image", "ref", ref, "path", path, "format", o.format)
+
+	switch o.format {
+	case FormatOllama:
+		if o.encryption != nil {
+			return fmt.Errorf("encryption is not supported for the %s format", o.format)
+		}
+
+		w := writer.NewOllamaWriter(o.logger, o.keyProvider(), path)
+		w.SetJobs(o.jobs)
+		w.SetProgress(o.reporter())
+		return w.Write(ctx, image, ref, o.decryption != nil, o.unzip)
+	case FormatOllamaAPI:
+		if o.encryption != nil {
+			return fmt.Errorf("encryption is not supported for the %s format", o.format)
+		}
+
+		if path == "" {
+			path = writer.OllamaAddress()
+		}
+
+		w := writer.NewOllamaAPIWriter(o.logger, o.keyProvider(), path)
+		w.SetJobs(o.jobs)
+		w.SetProgress(o.reporter())
+		w.SetTempDir(o.registry.CacheDir)
+		return w.Write(ctx, image, ref, o.decryption != nil, o.unzip)
+	case FormatOCI:
+		w := writer.NewPathWriter(o.logger, o.keyProvider(), path)
+		w.SetJobs(o.jobs)
+		w.SetProgress(o.reporter())
+		w.SetEncryptLayers(o.encryptLayers)
+
+		if o.encryption != nil {
+			return w.WriteEncrypted(ctx, image, ref)
+		}
+
+		return w.Write(ctx, image, ref, o.decryption != nil, o.unzip)
+	default:
+		return fmt.Errorf("unsupported format: %s", o.format)
+	}
+}
@@ -0,0 +1,227 @@
+package kapsule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/nicholasjackson/kapsule/crypto"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// MaxInspectLayerSize is the largest layer that Inspect reads to show the
+// template, system prompt and parameters, larger layers are never fetched
+const MaxInspectLayerSize = 1 << 20
+
+// maxInspectContentSize limits the uncompressed contents read from a layer
+const maxInspectContentSize = 16 << 20
+
+// ImageDetails describes the manifest and layers of an image
+type ImageDetails struct {
+	Ref         string            `json:"ref"`
+	Digest      string            `json:"digest"`
+	MediaType   string            `json:"media_type"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Config      ConfigDetails     `json:"config"`
+	Layers      []LayerDetails    `json:"layers"`
+	// Template, System and Parameters are read from the layers of the
+	// image, they are empty when the layers are encrypted and no
+	// decryption key has been specified
+	Template   string              `json:"template,omitempty"`
+	System     string              `json:"system,omitempty"`
+	Parameters map[string][]string `json:"parameters,omitempty"`
+}
+
+// ConfigDetails describes the config of an image
+type ConfigDetails struct {
+	MediaType    string            `json:"media_type"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Created      *time.Time        `json:"created,omitempty"`
+	Architecture string            `json:"architecture,omitempty"`
+	OS           string            `json:"os,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// LayerDetails describes a single layer in an image
+type LayerDetails struct {
+	// Type is the kind of Kapsule layer i.e. model or template, it is empty
+	// for layers that do not have a Kapsule media type
+	Type        string             `json:"type,omitempty"`
+	MediaType   string             `json:"media_type"`
+	Digest      string             `json:"digest"`
+	Size        int64              `json:"size"`
+	Encrypted   bool               `json:"encrypted"`
+	Recipients  []crypto.Recipient `json:"recipients,omitempty"`
+	Annotations map[string]string  `json:"annotations,omitempty"`
+}
+
+// Inspect fetches the manifest and config for the image at ref and returns
+// the details of the image. The template, system prompt and parameters are
+// read from their layers when smaller than MaxInspectLayerSize, the model
+// weights are never downloaded. Encrypted layers are read when
+// WithDecryption is specified.
+func Inspect(ctx context.Context, ref string, opts ...Option) (*ImageDetails, error) {
+	o := newOptions(opts)
+
+	image, err := Pull(ctx, ref, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to pull image: %w", err)
+	}
+
+	details, err := inspectImage(ref, image)
+	if err != nil {
+		return nil, err
+	}
+
+	err = inspectContents(ctx, details, image, o.decryption)
+	if err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+func inspectImage(ref string, image v1.Image) (*ImageDetails, error) {
+	mf, err := image.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+
+	d, err := image.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read digest: %w", err)
+	}
+
+	details := &ImageDetails{
+		Ref:         ref,
+		Digest:      d.String(),
+		MediaType:   string(mf.MediaType),
+		Annotations: mf.Annotations,
+		Config: ConfigDetails{
+			MediaType: string(mf.Config.MediaType),
+			Digest:    mf.Config.Digest.String(),
+			Size:      mf.Config.Size,
+		},
+		Layers: []LayerDetails{},
+	}
+
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %w", err)
+	}
+
+	if !cf.Created.IsZero() {
+		details.Config.Created = &cf.Created.Time
+	}
+
+	details.Config.Architecture = cf.Architecture
+	details.Config.OS = cf.OS
+	details.Config.Labels = cf.Config.Labels
+
+	for _, l := range mf.Layers {
+		r, err := crypto.Recipients(l.Annotations)
+		if err != nil {
+			return nil, types.Errorf(types.ErrorKindParse, "unable to read recipients of layer %s: %w", l.Digest, err)
+		}
+
+		details.Size += l.Size
+		details.Layers = append(details.Layers, LayerDetails{
+			Type:        types.LayerType(string(l.MediaType)),
+			MediaType:   string(l.MediaType),
+			Digest:      l.Digest.String(),
+			Size:        l.Size,
+			Encrypted:   types.IsEncryptedMediaType(string(l.MediaType)),
+			Recipients:  r,
+			Annotations: l.Annotations,
+		})
+	}
+
+	return details, nil
+}
+
+// inspectContents reads the template, system prompt and parameters from the
+// small layers of the image, encrypted layers are only read when a key
+// provider for decryption is given
+func inspectContents(ctx context.Context, details *ImageDetails, image v1.Image, kp keyproviders.Provider) error {
+	layers, err := image.Layers()
+	if err != nil {
+		return fmt.Errorf("unable to read layers: %w", err)
+	}
+
+	var pk [][]byte
+
+	for i, ld := range details.Layers {
+		if ld.Type != "template" && ld.Type != "system" && ld.Type != "params" {
+			continue
+		}
+
+		if ld.Size > MaxInspectLayerSize || i >= len(layers) {
+			continue
+		}
+
+		l := layers[i]
+
+		if ld.Encrypted {
+			if kp == nil {
+				continue
+			}
+
+			if pk == nil {
+				pk, err = keyproviders.PrivateKeys(ctx, kp)
+				if err != nil {
+					return types.Errorf(types.ErrorKindCrypto, "unable to get private keys: %w", err)
+				}
+			}
+
+			l, err = crypto.NewDecryptedLayer(l, pk, ld.Annotations)
+			if err != nil {
+				return types.Errorf(types.ErrorKindCrypto, "unable to decrypt layer %s: %w", ld.Digest, err)
+			}
+		}
+
+		b, err := readLayer(l)
+		if err != nil {
+			return fmt.Errorf("unable to read layer %s: %w", ld.Digest, err)
+		}
+
+		switch ld.Type {
+		case "template":
+			details.Template = string(b)
+		case "system":
+			details.System = string(b)
+		case "params":
+			p := map[string][]string{}
+			err := json.Unmarshal(b, &p)
+			if err != nil {
+				return types.Errorf(types.ErrorKindParse, "unable to parse parameters: %w", err)
+			}
+
+			details.Parameters = p
+		}
+	}
+
+	return nil
+}
+
+// readLayer returns the uncompressed contents of the layer, layers written
+// uncompressed are returned unchanged
+func readLayer(l v1.Layer) ([]byte, error) {
+	rc, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	r, err := types.Decompress(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(io.LimitReader(r, maxInspectContentSize))
+}
@@ -0,0 +1,217 @@
+// Package kapsule provides a Go API for building, pushing, pulling, exporting
+// and inspecting Large Language Models packaged as OCI images.
+//
+// The package wires together the builder, reader, writer and keyproviders
+// packages so that applications embedding Kapsule do not need to construct
+// them by hand. Behaviour is configured using functional options:
+//
+//	img, err := kapsule.Build(ctx, "./modelfile", "./context")
+//	if err != nil {
+//		return err
+//	}
+//
+//	err = kapsule.Push(ctx, img, "docker.io/nicholasjackson/mistral:encrypted",
+//		kapsule.WithAuth(username, password),
+//		kapsule.WithEncryption(keyproviders.NewFile("./public.key", "")),
+//	)
+package kapsule
+
+import (
+	"io"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/nicholasjackson/kapsule/registry"
+	"github.com/nicholasjackson/kapsule/writer"
+)
+
+// Format defines the on disk format used when exporting an image
+type Format string
+
+const (
+	// FormatOCI writes the image as an OCI image layout
+	FormatOCI Format = "oci"
+	// FormatOllama writes the image to an Ollama model store
+	FormatOllama Format = "ollama"
+	// FormatOllamaAPI creates the model on a running Ollama server using
+	// the Ollama API, the path passed to Export is the address of the server
+	FormatOllamaAPI Format = "ollama-api"
+)
+
+// Option configures the behaviour of the Kapsule functions
+type Option func(*options)
+
+type options struct {
+	logger     *log.Logger
+	encryption keyproviders.Provider
+	decryption keyproviders.Provider
+	// encryptLayers are the layers encrypted by WithEncryption
+	encryptLayers []string
+	registry      registry.Options
+	format        Format
+	unzip         bool
+	jobs          int
+	progress      progress.Reporter
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		logger: log.New(io.Discard),
+		format: FormatOCI,
+		jobs:   writer.DefaultJobs,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithLogger sets the logger used to report progress, by default all
+// log output is discarded
+func WithLogger(l *log.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithEncryption encrypts the layers of the image using the public key
+// returned by the given provider when pushing or exporting, use
+// keyproviders.NewMulti to encrypt the image for several recipients
+func WithEncryption(kp keyproviders.Provider) Option {
+	return func(o *options) {
+		o.encryption = kp
+	}
+}
+
+// WithEncryptLayers only encrypts the given layers when WithEncryption is
+// specified, layers are selected by Kapsule layer type i.e. model or adapter
+// or by media type. Other layers such as the template and parameters are
+// written unencrypted so they can still be inspected.
+func WithEncryptLayers(layers ...string) Option {
+	return func(o *options) {
+		o.encryptLayers = layers
+	}
+}
+
+// WithDecryption decrypts any encrypted layers of the image using the private
+// key returned by the given provider when exporting
+func WithDecryption(kp keyproviders.Provider) Option {
+	return func(o *options) {
+		o.decryption = kp
+	}
+}
+
+// WithAuth sets the username and password used to authenticate with
+// a remote registry
+func WithAuth(username, password string) Option {
+	return func(o *options) {
+		o.registry.Username = username
+		o.registry.Password = password
+	}
+}
+
+// WithInsecure disables TLS verification when connecting to a remote registry
+func WithInsecure(insecure bool) Option {
+	return func(o *options) {
+		o.registry.TLS.InsecureSkipVerify = insecure
+	}
+}
+
+// WithPlainHTTP connects to remote registries using plain HTTP, this is
+// intended for local development registries such as localhost:5000
+func WithPlainHTTP(plainHTTP bool) Option {
+	return func(o *options) {
+		o.registry.PlainHTTP = plainHTTP
+	}
+}
+
+// WithTLS sets the CA certificate and the client certificate used when
+// connecting to a remote registry, use WithInsecure to disable verification
+func WithTLS(caCert, clientCert, clientKey string) Option {
+	return func(o *options) {
+		o.registry.TLS.CACert = caCert
+		o.registry.TLS.ClientCert = clientCert
+		o.registry.TLS.ClientKey = clientKey
+	}
+}
+
+// WithRegistryConfig sets the config for individual registries, options
+// such as WithTLS take precedence over the config
+func WithRegistryConfig(c *registry.Config) Option {
+	return func(o *options) {
+		o.registry.Config = c
+	}
+}
+
+// WithCacheDir sets the folder used to store partially downloaded blobs so
+// that interrupted pulls are resumed, by default partial blobs are not kept
+func WithCacheDir(dir string) Option {
+	return func(o *options) {
+		o.registry.CacheDir = dir
+	}
+}
+
+// WithRetry sets how failed requests to registries are retried, defaults to
+// registry.DefaultRetry
+func WithRetry(r registry.Retry) Option {
+	return func(o *options) {
+		o.registry.Retry = r
+	}
+}
+
+// WithJobs sets the number of layers that are fetched, decrypted and written
+// concurrently, defaults to writer.DefaultJobs
+func WithJobs(jobs int) Option {
+	return func(o *options) {
+		o.jobs = jobs
+	}
+}
+
+// WithProgress sets the reporter that receives the progress of each layer,
+// by default the progress is written to the logger
+func WithProgress(r progress.Reporter) Option {
+	return func(o *options) {
+		o.progress = r
+	}
+}
+
+// WithFormat sets the format used when exporting an image, defaults to FormatOCI
+func WithFormat(f Format) Option {
+	return func(o *options) {
+		o.format = f
+	}
+}
+
+// WithUnzip uncompresses the layers of the image when exporting to disk
+func WithUnzip(unzip bool) Option {
+	return func(o *options) {
+		o.unzip = unzip
+	}
+}
+
+// keyProvider returns the key provider that is passed to the writers, the
+// writers use a single provider for both encryption and decryption
+func (o *options) keyProvider() keyproviders.Provider {
+	if o.encryption != nil {
+		return o.encryption
+	}
+
+	if o.decryption != nil {
+		return o.decryption
+	}
+
+	return &keyproviders.NullProvider{}
+}
+
+// reporter returns the progress reporter, logging the progress when
+// WithProgress has not been specified
+func (o *options) reporter() progress.Reporter {
+	if o.progress != nil {
+		return o.progress
+	}
+
+	return progress.NewLogReporter(o.logger, 0)
+}
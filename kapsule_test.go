@@ -0,0 +1,709 @@
+package kapsule
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/testutils"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/stretchr/testify/require"
+)
+
+func setupKapsule(t *testing.T) (string, Option) {
+	s := httptest.NewServer(registry.New())
+	t.Cleanup(s.Close)
+
+	return strings.TrimPrefix(s.URL, "http://"), WithLogger(testutils.CreateTestLogger(t))
+}
+
+func TestBuildReturnsImage(t *testing.T) {
+	_, l := setupKapsule(t)
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	layers, err := i.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 4)
+}
+
+func TestPushAndInspectImage(t *testing.T) {
+	reg, l := setupKapsule(t)
+	ref := reg + "/testmodel:plain"
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, ref, l)
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), ref, l)
+	require.NoError(t, err)
+	require.Len(t, d.Layers, 4)
+	require.Equal(t, types.KAPSULE_MEDIA_TYPE_MODEL, d.Layers[0].MediaType)
+	require.False(t, d.Layers[0].Encrypted)
+}
+
+func TestPushEncryptedAndInspectImage(t *testing.T) {
+	reg, l := setupKapsule(t)
+	ref := reg + "/testmodel:enc"
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "")
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, ref, l, WithEncryption(kp))
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), ref, l)
+	require.NoError(t, err)
+	require.Len(t, d.Layers, 4)
+	require.Equal(t, types.KAPSULE_MEDIA_TYPE_MODEL+"+enc", d.Layers[0].MediaType)
+	require.True(t, d.Layers[0].Encrypted)
+}
+
+func TestExportWritesOllamaFormat(t *testing.T) {
+	reg, l := setupKapsule(t)
+	ref := reg + "/testmodel:enc"
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "./test_fixtures/keys/private.key")
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, ref, l, WithEncryption(kp))
+	require.NoError(t, err)
+
+	i, err = Pull(context.Background(), ref, l)
+	require.NoError(t, err)
+
+	out := t.TempDir()
+	err = Export(context.Background(), i, "testmodel:enc", out, l, WithFormat(FormatOllama), WithDecryption(kp))
+	require.NoError(t, err)
+
+	require.FileExists(t, path.Join(out, "manifests", "kapsule.io", "library", "testmodel", "enc"))
+}
+
+func TestExportWritesOCIFormat(t *testing.T) {
+	_, l := setupKapsule(t)
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	out := t.TempDir()
+	err = Export(context.Background(), i, "testmodel:plain", out, l)
+	require.NoError(t, err)
+
+	require.FileExists(t, path.Join(out, "index.json"))
+}
+
+func TestExportReturnsErrorForUnknownFormat(t *testing.T) {
+	_, l := setupKapsule(t)
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Export(context.Background(), i, "testmodel:plain", t.TempDir(), l, WithFormat("pytorch"))
+	require.Error(t, err)
+}
+
+func TestInspectReadsImageFromLocalLayout(t *testing.T) {
+	_, l := setupKapsule(t)
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	out := t.TempDir()
+	err = Export(context.Background(), i, "nicholasjackson/testmodel:plain", out, l)
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), "oci-layout://"+out+":nicholasjackson/testmodel:plain", l)
+	require.NoError(t, err)
+	require.Len(t, d.Layers, 4)
+
+	d, err = Inspect(context.Background(), "oci:"+out+":plain", l)
+	require.NoError(t, err)
+	require.Len(t, d.Layers, 4)
+}
+
+// recordingRegistry records the method and path of each request made to the
+// in memory registry
+type recordingRegistry struct {
+	handler http.Handler
+	mu      sync.Mutex
+	reqs    []string
+}
+
+func (r *recordingRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.reqs = append(r.reqs, req.Method+" "+req.URL.Path)
+	r.mu.Unlock()
+
+	r.handler.ServeHTTP(w, req)
+}
+
+func (r *recordingRegistry) count(method, prefix string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, req := range r.reqs {
+		if strings.HasPrefix(req, method+" "+prefix) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (r *recordingRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reqs = nil
+}
+
+func setupRecordingRegistry(t *testing.T) (string, *recordingRegistry) {
+	rr := &recordingRegistry{handler: registry.New()}
+
+	s := httptest.NewServer(rr)
+	t.Cleanup(s.Close)
+
+	return strings.TrimPrefix(s.URL, "http://"), rr
+}
+
+func TestCopyWithinRegistryMountsLayers(t *testing.T) {
+	reg, rr := setupRecordingRegistry(t)
+	l := WithLogger(testutils.CreateTestLogger(t))
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, reg+"/staging/testmodel:plain", l)
+	require.NoError(t, err)
+
+	src, err := Inspect(context.Background(), reg+"/staging/testmodel:plain", l)
+	require.NoError(t, err)
+
+	rr.reset()
+
+	err = Copy(context.Background(), reg+"/staging/testmodel:plain", reg+"/production/testmodel:plain", l)
+	require.NoError(t, err)
+
+	// the layers are mounted from the source repository and never read
+	for _, ld := range src.Layers {
+		require.Equal(t, 0, rr.count(http.MethodGet, "/v2/staging/testmodel/blobs/"+ld.Digest))
+	}
+
+	require.Equal(t, 0, rr.count(http.MethodPatch, "/v2/production/testmodel/blobs/"))
+
+	dst, err := Inspect(context.Background(), reg+"/production/testmodel:plain", l)
+	require.NoError(t, err)
+	require.Equal(t, src.Digest, dst.Digest)
+}
+
+func TestCopyBetweenRegistriesSkipsExistingBlobs(t *testing.T) {
+	srcReg, l := setupKapsule(t)
+	dstReg, rr := setupRecordingRegistry(t)
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, srcReg+"/testmodel:plain", l)
+	require.NoError(t, err)
+
+	err = Copy(context.Background(), srcReg+"/testmodel:plain", dstReg+"/testmodel:plain", l)
+	require.NoError(t, err)
+	require.Greater(t, rr.count(http.MethodPatch, "/v2/testmodel/blobs/"), 0)
+
+	src, err := Inspect(context.Background(), srcReg+"/testmodel:plain", l)
+	require.NoError(t, err)
+
+	dst, err := Inspect(context.Background(), dstReg+"/testmodel:plain", l)
+	require.NoError(t, err)
+	require.Equal(t, src.Digest, dst.Digest)
+
+	// copying again does not upload any blobs
+	rr.reset()
+
+	err = Copy(context.Background(), srcReg+"/testmodel:plain", dstReg+"/testmodel:latest", l)
+	require.NoError(t, err)
+	require.Equal(t, 0, rr.count(http.MethodPatch, "/v2/testmodel/blobs/"))
+	require.Equal(t, 0, rr.count(http.MethodPost, "/v2/testmodel/blobs/"))
+}
+
+func TestCopyDecryptsLayers(t *testing.T) {
+	reg, l := setupKapsule(t)
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "./test_fixtures/keys/private.key")
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, reg+"/testmodel:enc", l, WithEncryption(kp))
+	require.NoError(t, err)
+
+	err = Copy(context.Background(), reg+"/testmodel:enc", reg+"/testmodel:plain", l, WithDecryption(kp))
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), reg+"/testmodel:plain", l)
+	require.NoError(t, err)
+	require.Len(t, d.Layers, 4)
+	require.Equal(t, types.KAPSULE_MEDIA_TYPE_MODEL, d.Layers[0].MediaType)
+	require.False(t, d.Layers[0].Encrypted)
+}
+
+func TestCopyReEncryptsLayers(t *testing.T) {
+	reg, l := setupKapsule(t)
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "./test_fixtures/keys/private.key")
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, reg+"/testmodel:enc", l, WithEncryption(kp))
+	require.NoError(t, err)
+
+	err = Copy(context.Background(), reg+"/testmodel:enc", reg+"/testmodel:reenc", l, WithDecryption(kp), WithEncryption(kp))
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), reg+"/testmodel:reenc", l)
+	require.NoError(t, err)
+	require.Len(t, d.Layers, 4)
+	require.True(t, d.Layers[0].Encrypted)
+
+	// the re-encrypted image can be decrypted
+	i, err = Pull(context.Background(), reg+"/testmodel:reenc", l)
+	require.NoError(t, err)
+
+	out := t.TempDir()
+	err = Export(context.Background(), i, "testmodel:reenc", out, l, WithFormat(FormatOllama), WithDecryption(kp))
+	require.NoError(t, err)
+}
+
+func TestCopyEncryptedImageWithoutDecryptionReturnsError(t *testing.T) {
+	reg, l := setupKapsule(t)
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "")
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, reg+"/testmodel:enc", l, WithEncryption(kp))
+	require.NoError(t, err)
+
+	err = Copy(context.Background(), reg+"/testmodel:enc", reg+"/testmodel:reenc", l, WithEncryption(kp))
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindCrypto, types.ErrorKindOf(err))
+}
+
+func TestInspectReturnsModelDetails(t *testing.T) {
+	reg, l := setupKapsule(t)
+	ref := reg + "/testmodel:plain"
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, ref, l)
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), ref, l)
+	require.NoError(t, err)
+	require.Equal(t, "model", d.Layers[0].Type)
+	require.Equal(t, "template", d.Layers[1].Type)
+	require.Equal(t, `[INST] {{ .System }} {{ .Prompt }} [/INST]`, d.Template)
+	require.Equal(t, "You are brain from Pinky and the Brain, acting as an assitant.", d.System)
+	require.Equal(t, []string{"[/INST]", "[INST]"}, d.Parameters["stop"])
+	require.Equal(t, []string{"0.8"}, d.Parameters["temperature"])
+	require.NotEmpty(t, d.Config.Digest)
+}
+
+func TestInspectDoesNotReadModelLayer(t *testing.T) {
+	reg, rr := setupRecordingRegistry(t)
+	l := WithLogger(testutils.CreateTestLogger(t))
+	ref := reg + "/testmodel:plain"
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, ref, l)
+	require.NoError(t, err)
+
+	rr.reset()
+
+	d, err := Inspect(context.Background(), ref, l)
+	require.NoError(t, err)
+	require.Equal(t, 0, rr.count(http.MethodGet, "/v2/testmodel/blobs/"+d.Layers[0].Digest))
+	require.Equal(t, 1, rr.count(http.MethodGet, "/v2/testmodel/blobs/"+d.Layers[1].Digest))
+}
+
+func TestInspectEncryptedImageReturnsRecipients(t *testing.T) {
+	reg, l := setupKapsule(t)
+	ref := reg + "/testmodel:enc"
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "./test_fixtures/keys/private.key")
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, ref, l, WithEncryption(kp))
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), ref, l)
+	require.NoError(t, err)
+	require.Equal(t, "template", d.Layers[1].Type)
+	require.Len(t, d.Layers[1].Recipients, 1)
+	require.Equal(t, "RSA-OAEP", d.Layers[1].Recipients[0].Algorithm)
+	require.Empty(t, d.Template)
+
+	d, err = Inspect(context.Background(), ref, l, WithDecryption(kp))
+	require.NoError(t, err)
+	require.Equal(t, `[INST] {{ .System }} {{ .Prompt }} [/INST]`, d.Template)
+	require.Equal(t, []string{"0.8"}, d.Parameters["temperature"])
+}
+
+func buildWithLabels(t *testing.T, l Option, labels string) v1.Image {
+	mf := path.Join(t.TempDir(), "modelfile")
+	err := os.WriteFile(mf, []byte("FROM ./test.gguf\nLABEL "+labels), os.ModePerm)
+	require.NoError(t, err)
+
+	i, err := Build(context.Background(), mf, "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	return i
+}
+
+func TestTagsReturnsLabelsOfEncryptedImages(t *testing.T) {
+	reg, l := setupKapsule(t)
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "")
+
+	err := Push(context.Background(), buildWithLabels(t, l, "quantization=Q4_K_M"), reg+"/testmodel:q4", l, WithEncryption(kp))
+	require.NoError(t, err)
+
+	err = Push(context.Background(), buildWithLabels(t, l, "quantization=Q8_0"), reg+"/testmodel:q8", l)
+	require.NoError(t, err)
+
+	s, err := Tags(context.Background(), reg+"/testmodel", l)
+	require.NoError(t, err)
+	require.Len(t, s, 2)
+	require.Equal(t, "Q4_K_M", s[0].Labels["quantization"])
+	require.Equal(t, "Q8_0", s[1].Labels["quantization"])
+	require.NotNil(t, s[0].Created)
+
+	repos, err := Catalog(context.Background(), reg, l)
+	require.NoError(t, err)
+	require.Equal(t, []string{"testmodel"}, repos)
+}
+
+func TestListReturnsImagesInLocalLayout(t *testing.T) {
+	_, l := setupKapsule(t)
+
+	out := t.TempDir()
+	err := Export(context.Background(), buildWithLabels(t, l, "quantization=Q4_K_M"), "testmodel:q4", out, l)
+	require.NoError(t, err)
+
+	s, err := List(context.Background(), "oci:"+out, l)
+	require.NoError(t, err)
+	require.Len(t, s, 1)
+	require.Equal(t, "kapsule.io/library/testmodel:q4", s[0].Ref)
+	require.Equal(t, "Q4_K_M", s[0].Labels["quantization"])
+}
+
+func TestTagWithinRepositoryDoesNotUploadBlobs(t *testing.T) {
+	reg, rr := setupRecordingRegistry(t)
+	l := WithLogger(testutils.CreateTestLogger(t))
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, reg+"/testmodel:v1", l)
+	require.NoError(t, err)
+
+	rr.reset()
+
+	err = Tag(context.Background(), reg+"/testmodel:v1", reg+"/testmodel:latest", l)
+	require.NoError(t, err)
+
+	require.Equal(t, 0, rr.count(http.MethodPost, "/v2/testmodel/blobs/"))
+	require.Equal(t, 0, rr.count(http.MethodGet, "/v2/testmodel/blobs/"))
+	require.Equal(t, 1, rr.count(http.MethodPut, "/v2/testmodel/manifests/latest"))
+
+	s, err := Tags(context.Background(), reg+"/testmodel", l)
+	require.NoError(t, err)
+	require.Len(t, s, 2)
+	require.Equal(t, s[0].Digest, s[1].Digest)
+}
+
+func TestTagInOtherRepositoryMountsLayers(t *testing.T) {
+	reg, rr := setupRecordingRegistry(t)
+	l := WithLogger(testutils.CreateTestLogger(t))
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, reg+"/staging/testmodel:v1", l)
+	require.NoError(t, err)
+
+	src, err := Inspect(context.Background(), reg+"/staging/testmodel:v1", l)
+	require.NoError(t, err)
+
+	rr.reset()
+
+	err = Tag(context.Background(), reg+"/staging/testmodel:v1", reg+"/production/testmodel:v1", l)
+	require.NoError(t, err)
+
+	for _, ld := range src.Layers {
+		require.Equal(t, 0, rr.count(http.MethodGet, "/v2/staging/testmodel/blobs/"+ld.Digest))
+	}
+
+	dst, err := Inspect(context.Background(), reg+"/production/testmodel:v1", l)
+	require.NoError(t, err)
+	require.Equal(t, src.Digest, dst.Digest)
+}
+
+func TestTagReturnsErrorForOtherRegistry(t *testing.T) {
+	reg, l := setupKapsule(t)
+
+	err := Tag(context.Background(), reg+"/testmodel:v1", "docker.io/testmodel:v1", l)
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindParse, types.ErrorKindOf(err))
+}
+
+func TestRemoveDeletesTagFromRegistry(t *testing.T) {
+	reg, l := setupKapsule(t)
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, reg+"/testmodel:v1", l)
+	require.NoError(t, err)
+
+	err = Tag(context.Background(), reg+"/testmodel:v1", reg+"/testmodel:v2", l)
+	require.NoError(t, err)
+
+	err = Remove(context.Background(), reg+"/testmodel:v1", l)
+	require.NoError(t, err)
+
+	s, err := Tags(context.Background(), reg+"/testmodel", l)
+	require.NoError(t, err)
+	require.Len(t, s, 1)
+	require.Equal(t, reg+"/testmodel:v2", s[0].Ref)
+}
+
+func TestTagAndRemoveInLocalLayout(t *testing.T) {
+	_, l := setupKapsule(t)
+
+	out := t.TempDir()
+	err := Export(context.Background(), buildWithLabels(t, l, "quantization=Q4_K_M"), "testmodel:v1", out, l)
+	require.NoError(t, err)
+
+	err = Tag(context.Background(), "oci:"+out+":testmodel:v1", "testmodel:latest", l)
+	require.NoError(t, err)
+
+	err = Tag(context.Background(), "oci:"+out+":testmodel:v1", "oci:"+out+":testmodel:v2", l)
+	require.NoError(t, err)
+
+	s, err := List(context.Background(), "oci:"+out, l)
+	require.NoError(t, err)
+	require.Len(t, s, 3)
+
+	err = Remove(context.Background(), "oci:"+out+":testmodel:v1", l)
+	require.NoError(t, err)
+
+	s, err = List(context.Background(), "oci:"+out, l)
+	require.NoError(t, err)
+	require.Len(t, s, 2)
+	require.Equal(t, "kapsule.io/library/testmodel:latest", s[0].Ref)
+	require.Equal(t, "kapsule.io/library/testmodel:v2", s[1].Ref)
+}
+
+func TestTagReturnsErrorForOtherStore(t *testing.T) {
+	_, l := setupKapsule(t)
+
+	out := t.TempDir()
+	err := Export(context.Background(), buildWithLabels(t, l, "quantization=Q4_K_M"), "testmodel:v1", out, l)
+	require.NoError(t, err)
+
+	err = Tag(context.Background(), "oci:"+out+":testmodel:v1", "oci:"+t.TempDir()+":testmodel:v2", l)
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindParse, types.ErrorKindOf(err))
+
+	err = Tag(context.Background(), "oci:"+out+":testmodel:v1", "ollama://"+out+":testmodel:v2", l)
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindParse, types.ErrorKindOf(err))
+}
+
+func buildFromModelfile(t *testing.T, l Option, modelfile string) v1.Image {
+	mf := path.Join(t.TempDir(), "modelfile")
+	err := os.WriteFile(mf, []byte(modelfile), os.ModePerm)
+	require.NoError(t, err)
+
+	i, err := Build(context.Background(), mf, "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	return i
+}
+
+func TestDiffReportsPromptAndParameterChanges(t *testing.T) {
+	reg, l := setupKapsule(t)
+
+	a := buildFromModelfile(t, l, "FROM ./test.gguf\nTEMPLATE \"\"\"[INST] {{ .Prompt }} [/INST]\"\"\"\nSYSTEM You are helpful.\nPARAMETER temperature 0.8\nPARAMETER stop [INST]\nLABEL stage=staging")
+	b := buildFromModelfile(t, l, "FROM ./test.gguf\nTEMPLATE \"\"\"[INST] {{ .System }} {{ .Prompt }} [/INST]\"\"\"\nSYSTEM You are helpful.\nPARAMETER temperature 0.2\nPARAMETER num_ctx 4096\nLABEL stage=production")
+
+	require.NoError(t, Push(context.Background(), a, reg+"/testmodel:a", l))
+	require.NoError(t, Push(context.Background(), b, reg+"/testmodel:b", l))
+
+	d, err := Diff(context.Background(), reg+"/testmodel:a", reg+"/testmodel:b", l)
+	require.NoError(t, err)
+
+	require.False(t, d.Identical)
+	require.True(t, d.WeightsIdentical)
+	require.Contains(t, d.Template, "-[INST] {{ .Prompt }} [/INST]")
+	require.Contains(t, d.Template, "+[INST] {{ .System }} {{ .Prompt }} [/INST]")
+	require.Empty(t, d.System)
+
+	require.Equal(t, []ValueDiff{
+		{Key: "num_ctx", B: "4096", Status: DiffAdded},
+		{Key: "stop", A: "[INST]", Status: DiffRemoved},
+		{Key: "temperature", A: "0.8", B: "0.2", Status: DiffChanged},
+	}, d.Parameters)
+
+	require.Equal(t, []ValueDiff{{Key: "stage", A: "staging", B: "production", Status: DiffChanged}}, d.Labels)
+
+	statuses := map[string]string{}
+	for _, ld := range d.Layers {
+		statuses[ld.Type] = ld.Status
+	}
+
+	require.Equal(t, DiffIdentical, statuses["model"])
+	require.Equal(t, DiffChanged, statuses["template"])
+	require.Equal(t, DiffIdentical, statuses["system"])
+	require.Equal(t, DiffChanged, statuses["params"])
+}
+
+func TestDiffReportsEncryptedWeightsAsUnknown(t *testing.T) {
+	reg, l := setupKapsule(t)
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "./test_fixtures/keys/private.key")
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	require.NoError(t, Push(context.Background(), i, reg+"/testmodel:plain", l))
+	require.NoError(t, Copy(context.Background(), reg+"/testmodel:plain", reg+"/testmodel:enc", l, WithEncryption(kp)))
+
+	d, err := Diff(context.Background(), reg+"/testmodel:plain", reg+"/testmodel:enc", l, WithDecryption(kp))
+	require.NoError(t, err)
+
+	require.False(t, d.WeightsIdentical)
+	require.Empty(t, d.Template)
+	require.Empty(t, d.Parameters)
+
+	for _, ld := range d.Layers {
+		require.Equal(t, DiffUnknown, ld.Status)
+	}
+}
+
+func TestDiffLayersReportsAddedAndRemovedLayers(t *testing.T) {
+	a := []LayerDetails{{Type: "model", Digest: "sha256:a"}, {Type: "adapter", Digest: "sha256:b"}}
+	b := []LayerDetails{{Type: "model", Digest: "sha256:a"}, {Type: "licence", Digest: "sha256:c"}}
+
+	d := diffLayers(a, b)
+	require.Equal(t, []LayerDiff{
+		{Type: "model", DigestA: "sha256:a", DigestB: "sha256:a", Status: DiffIdentical},
+		{Type: "adapter", DigestA: "sha256:b", Status: DiffRemoved},
+		{Type: "licence", DigestB: "sha256:c", Status: DiffAdded},
+	}, d)
+}
+
+func TestPushEncryptLayersKeepsTemplateInspectable(t *testing.T) {
+	reg, l := setupKapsule(t)
+	kp := keyproviders.NewFile("./test_fixtures/keys/public.key", "./test_fixtures/keys/private.key")
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, reg+"/testmodel:enc", l, WithEncryption(kp), WithEncryptLayers("model"))
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), reg+"/testmodel:enc", l)
+	require.NoError(t, err)
+	require.Contains(t, d.Template, "[INST]")
+	require.NotEmpty(t, d.Parameters)
+
+	for _, ld := range d.Layers {
+		require.Equal(t, ld.Type == "model", ld.Encrypted, ld.Type)
+	}
+
+	pi, err := Pull(context.Background(), reg+"/testmodel:enc", l)
+	require.NoError(t, err)
+
+	err = Export(context.Background(), pi, "testmodel:dec", t.TempDir(), l, WithDecryption(kp))
+	require.NoError(t, err)
+}
+
+func TestPushEncryptedForSeveralRecipientsCanBeDecryptedByEach(t *testing.T) {
+	reg, l := setupKapsule(t)
+	ref := reg + "/testmodel:enc"
+	kp := keyproviders.NewMulti(
+		testutils.CreateTestLogger(t),
+		keyproviders.NewFile("./test_fixtures/keys/public.key", ""),
+		keyproviders.NewFile("./test_fixtures/keys/breakglass_public.key", ""),
+	)
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	err = Push(context.Background(), i, ref, l, WithEncryption(kp))
+	require.NoError(t, err)
+
+	d, err := Inspect(context.Background(), ref, l)
+	require.NoError(t, err)
+
+	for _, ld := range d.Layers {
+		require.Len(t, ld.Recipients, 2, ld.Type)
+	}
+
+	for _, k := range []string{"./test_fixtures/keys/private.key", "./test_fixtures/keys/breakglass_private.key"} {
+		d, err = Inspect(context.Background(), ref, l, WithDecryption(keyproviders.NewFile("", k)))
+		require.NoError(t, err, k)
+		require.Contains(t, d.Template, "[INST]")
+
+		pi, err := Pull(context.Background(), ref, l)
+		require.NoError(t, err)
+
+		err = Export(context.Background(), pi, "testmodel:dec", t.TempDir(), l, WithDecryption(keyproviders.NewFile("", k)))
+		require.NoError(t, err, k)
+	}
+}
+
+func TestDecryptionTriesEachPrivateKey(t *testing.T) {
+	reg, l := setupKapsule(t)
+	ref := reg + "/testmodel:breakglass"
+
+	i, err := Build(context.Background(), "./test_fixtures/testmodel/modelfile", "./test_fixtures/testmodel", l)
+	require.NoError(t, err)
+
+	// the image is only encrypted for the second key
+	err = Push(context.Background(), i, ref, l, WithEncryption(keyproviders.NewFile("./test_fixtures/keys/breakglass_public.key", "")))
+	require.NoError(t, err)
+
+	kp := keyproviders.NewMulti(
+		testutils.CreateTestLogger(t),
+		keyproviders.NewFile("", "./test_fixtures/keys/private.key"),
+		keyproviders.NewFile("", "./test_fixtures/keys/breakglass_private.key"),
+	)
+
+	d, err := Inspect(context.Background(), ref, l, WithDecryption(kp))
+	require.NoError(t, err)
+	require.Contains(t, d.Template, "[INST]")
+
+	pi, err := Pull(context.Background(), ref, l)
+	require.NoError(t, err)
+
+	err = Export(context.Background(), pi, "testmodel:dec", t.TempDir(), l, WithDecryption(kp))
+	require.NoError(t, err)
+}
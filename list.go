@@ -0,0 +1,42 @@
+package kapsule
+
+import (
+	"context"
+
+	"github.com/nicholasjackson/kapsule/reader"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// Catalog returns the repositories in a remote registry using the catalog
+// API, registries that do not support the catalog API return an error
+func Catalog(ctx context.Context, registry string, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+
+	return reader.NewOCIRegistry(o.logger, o.registry).Catalog(ctx, registry)
+}
+
+// Tags returns a summary of each tagged image in a remote repository i.e.
+// docker.io/nicholasjackson/mistral, only the manifest and config of each
+// image is fetched. Use Summary.Matches to filter the images by label.
+func Tags(ctx context.Context, repository string, opts ...Option) ([]reader.Summary, error) {
+	o := newOptions(opts)
+
+	return reader.NewOCIRegistry(o.logger, o.registry).List(ctx, repository)
+}
+
+// List returns a summary of each image in a local OCI layout or Ollama store,
+// location uses the oci-layout://path, oci:path or ollama://path scheme. If
+// the path of an Ollama store is empty the default Ollama store is used.
+func List(ctx context.Context, location string, opts ...Option) ([]reader.Summary, error) {
+	o := newOptions(opts)
+
+	if p, _, ok := reader.ParseOCILayoutRef(location); ok {
+		return reader.NewOCILayout(o.logger, p).List(ctx)
+	}
+
+	if p, _, ok := reader.ParseOllamaRef(location); ok {
+		return reader.NewOllama(o.logger, p).List(ctx)
+	}
+
+	return nil, types.Errorf(types.ErrorKindParse, "unsupported location %s, use the oci-layout://, oci: or ollama:// scheme", location)
+}
@@ -3,6 +3,7 @@ package modelfile
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	"github.com/moby/buildkit/frontend/dockerfile/shell"
@@ -11,7 +12,11 @@ import (
 type ModelFile struct {
 	From       string
 	Template   string
+	System     string
 	Parameters map[string][]string
+	// Labels are stored in the image config and can be used to filter
+	// images when listing them i.e. LABEL quantization=Q4_K_M
+	Labels map[string]string
 }
 
 //go:generate mockery --name Parser
@@ -36,6 +41,7 @@ func (p *ParserImpl) Parse(file string) (*ModelFile, error) {
 
 	mf := &ModelFile{
 		Parameters: map[string][]string{},
+		Labels:     map[string]string{},
 	}
 
 	s := shell.NewLex('\\')
@@ -58,6 +64,15 @@ func (p *ParserImpl) Parse(file string) (*ModelFile, error) {
 			}
 
 			mf.Template = w[1]
+		case "SYSTEM":
+			// the system message is free text, process it as a single word
+			// so that the whitespace is preserved and any quotes are removed
+			w, err := s.ProcessWord(strings.TrimSpace(c.Original[len(c.Value):]), []string{})
+			if err != nil || w == "" {
+				return nil, fmt.Errorf("SYSTEM should be specified as SYSTEM \"The system message to use for the model\"")
+			}
+
+			mf.System = w
 		case "PARAMETER":
 			w, _ := s.ProcessWords(c.Original, []string{})
 
@@ -66,6 +81,30 @@ func (p *ParserImpl) Parse(file string) (*ModelFile, error) {
 			}
 
 			mf.Parameters[w[1]] = append(mf.Parameters[w[1]], w[2])
+		case "LABEL":
+			// the parser splits the label into key and value nodes
+			// i.e. LABEL family=llama quantization="Q4_K_M"
+			if c.Next == nil {
+				return nil, fmt.Errorf("LABEL should be specified as LABEL <key>=<value>")
+			}
+
+			for n := c.Next; n != nil; n = n.Next.Next {
+				if n.Next == nil {
+					return nil, fmt.Errorf("LABEL should be specified as LABEL <key>=<value>")
+				}
+
+				k, err := s.ProcessWord(n.Value, []string{})
+				if err != nil || k == "" {
+					return nil, fmt.Errorf("LABEL should be specified as LABEL <key>=<value>")
+				}
+
+				v, err := s.ProcessWord(n.Next.Value, []string{})
+				if err != nil {
+					return nil, fmt.Errorf("LABEL should be specified as LABEL <key>=<value>")
+				}
+
+				mf.Labels[k] = v
+			}
 		}
 	}
 
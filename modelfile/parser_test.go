@@ -1,6 +1,8 @@
 package modelfile
 
 import (
+	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -38,6 +40,27 @@ func TestModelfileWithBadTemplateReturnsError(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestParsesSystemInModelFile(t *testing.T) {
+	p := &ParserImpl{}
+
+	m, err := p.Parse("../test_fixtures/modelfile/basic_with_template.modelfile")
+	require.NoError(t, err)
+
+	require.Equal(t, `You are brain from Pinky and the Brain, acting as an assitant.`, m.System)
+}
+
+func TestParsesQuotedSystemInModelFile(t *testing.T) {
+	p := &ParserImpl{}
+
+	mf := path.Join(t.TempDir(), "modelfile")
+	os.WriteFile(mf, []byte("FROM ./model.gguf\nSYSTEM \"\"\"You are  a helpful assistant.\"\"\""), os.ModePerm)
+
+	m, err := p.Parse(mf)
+	require.NoError(t, err)
+
+	require.Equal(t, `You are  a helpful assistant.`, m.System)
+}
+
 func TestParsesParametersInModelFile(t *testing.T) {
 	p := &ParserImpl{}
 
@@ -55,3 +78,25 @@ func TestModelfileWithBadParametersReturnsError(t *testing.T) {
 	_, err := p.Parse("../test_fixtures/modelfile/basic_with_bad_parameters.modelfile")
 	require.Error(t, err)
 }
+
+func TestParsesLabelsInModelFile(t *testing.T) {
+	p := &ParserImpl{}
+
+	mf := path.Join(t.TempDir(), "modelfile")
+	os.WriteFile(mf, []byte("FROM ./model.gguf\nLABEL family=llama quantization=\"Q4_K_M\"\nLABEL description=\"Fine tuned mistral\""), os.ModePerm)
+
+	m, err := p.Parse(mf)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{"family": "llama", "quantization": "Q4_K_M", "description": "Fine tuned mistral"}, m.Labels)
+}
+
+func TestModelfileWithBadLabelReturnsError(t *testing.T) {
+	p := &ParserImpl{}
+
+	mf := path.Join(t.TempDir(), "modelfile")
+	os.WriteFile(mf, []byte("FROM ./model.gguf\nLABEL"), os.ModePerm)
+
+	_, err := p.Parse(mf)
+	require.Error(t, err)
+}
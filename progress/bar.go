@@ -0,0 +1,121 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBarInterval is the interval between redraws of the progress bar
+const DefaultBarInterval = 200 * time.Millisecond
+
+const barWidth = 30
+
+// BarReporter draws the aggregate progress as a bar on a single line of a
+// terminal, a line is printed above the bar as each layer finishes
+type BarReporter struct {
+	*Tracker
+	out    io.Writer
+	ticker *ticker
+
+	// mu serialises writes to out
+	mu sync.Mutex
+}
+
+// NewBarReporter returns a reporter that draws a progress bar to out, out
+// is expected to be a terminal
+func NewBarReporter(out io.Writer) *BarReporter {
+	r := &BarReporter{Tracker: NewTracker(), out: out}
+	r.ticker = &ticker{interval: DefaultBarInterval, render: r.render}
+
+	return r
+}
+
+func (r *BarReporter) Start(id string, total int64) {
+	r.Tracker.Start(id, total)
+	r.ticker.start()
+}
+
+func (r *BarReporter) Done(id string, err error) {
+	r.Tracker.Done(id, err)
+
+	l, _ := r.Tracker.Layer(id)
+
+	status := "done"
+	if err != nil {
+		status = "failed: " + err.Error()
+	}
+
+	r.mu.Lock()
+	fmt.Fprintf(r.out, "\r\033[K%s %s %s\n", shortID(id), HumanBytes(l.Complete), status)
+	r.mu.Unlock()
+
+	if r.Snapshot().Active == 0 {
+		r.ticker.halt()
+	}
+
+	r.render()
+}
+
+func (r *BarReporter) Close() error {
+	r.ticker.halt()
+
+	s := r.Snapshot()
+	if len(s.Layers) == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := fmt.Fprintf(r.out, "\r\033[K%s\n", bar(s))
+	return err
+}
+
+func (r *BarReporter) render() {
+	s := r.Snapshot()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fmt.Fprintf(r.out, "\r\033[K%s", bar(s))
+}
+
+// bar returns the progress bar for the snapshot
+// i.e. [=========>          ]  45.2% 1.2 GB/2.6 GB 35.1 MB/s ETA 41s (2/5 layers)
+func bar(s Snapshot) string {
+	filled := int(s.Percentage() / 100 * barWidth)
+
+	b := strings.Repeat("=", filled)
+	if filled < barWidth {
+		b += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+
+	eta := ""
+	if s.ETA > 0 {
+		eta = " ETA " + etaString(s.ETA)
+	}
+
+	return fmt.Sprintf(
+		"[%s] %5.1f%% %s/%s %s%s (%d/%d layers)",
+		b,
+		s.Percentage(),
+		HumanBytes(s.Complete),
+		HumanBytes(s.Total),
+		rateString(s.Rate),
+		eta,
+		len(s.Layers)-s.Active,
+		len(s.Layers),
+	)
+}
+
+// shortID shortens digests to the first 12 characters of the hex
+func shortID(id string) string {
+	if i := strings.Index(id, ":"); i >= 0 && len(id) > i+13 {
+		return id[i+1 : i+13]
+	}
+
+	return id
+}
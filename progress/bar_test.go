@@ -0,0 +1,36 @@
+package progress
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBarRendersAggregateProgress(t *testing.T) {
+	b := bar(Snapshot{
+		Layers:   []Layer{{ID: "a", Done: true}, {ID: "b"}},
+		Complete: 500,
+		Total:    1000,
+		Active:   1,
+		Rate:     100,
+		ETA:      5 * time.Second,
+	})
+
+	require.Equal(t, "[===============>              ]  50.0% 500 B/1.0 kB 100 B/s ETA 5s (1/2 layers)", b)
+}
+
+func TestBarReporterPrintsCompletedLayers(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	r := NewBarReporter(out)
+
+	r.Start("sha256:0123456789abcdef", 100)
+	r.Update("sha256:0123456789abcdef", 100)
+	r.Done("sha256:0123456789abcdef", nil)
+	require.NoError(t, r.Close())
+
+	require.Contains(t, out.String(), "0123456789ab 100 B done\n")
+	require.Contains(t, out.String(), "100.0% 100 B/100 B")
+	require.Nil(t, r.ticker.stop)
+}
@@ -0,0 +1,127 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// WrapImage returns an image where the compressed readers of the layers
+// report the bytes read to the reporter
+func WrapImage(image v1.Image, r Reporter) v1.Image {
+	if r == nil {
+		return image
+	}
+
+	return &reportingImage{Image: image, reporter: r}
+}
+
+// reportingImage reports the progress of reading its layers
+type reportingImage struct {
+	v1.Image
+	reporter Reporter
+}
+
+func (i *reportingImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := make([]v1.Layer, len(layers))
+	for n, l := range layers {
+		// layers that can be mounted from another repository stay mountable,
+		// mounted layers are never read so their progress is not reported
+		if ml, ok := l.(*remote.MountableLayer); ok {
+			wrapped[n] = &remote.MountableLayer{
+				Layer:     &reportingLayer{Layer: ml.Layer, reporter: i.reporter, index: n},
+				Reference: ml.Reference,
+			}
+
+			continue
+		}
+
+		wrapped[n] = &reportingLayer{Layer: l, reporter: i.reporter, index: n}
+	}
+
+	return wrapped, nil
+}
+
+// reportingLayer reports the bytes read from the compressed reader, the
+// layer is identified by its digest or by its index when the digest is not
+// known before the layer is read
+type reportingLayer struct {
+	v1.Layer
+	reporter Reporter
+	index    int
+}
+
+func (l *reportingLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("layer-%d", l.index)
+	if d, err := l.Layer.Digest(); err == nil {
+		id = d.String()
+	}
+
+	total := int64(-1)
+	if s, err := l.Layer.Size(); err == nil {
+		total = s
+	}
+
+	return NewReader(rc, l.reporter, id, total), nil
+}
+
+// Descriptor returns the descriptor of the wrapped layer so that fields
+// such as annotations are kept
+func (l *reportingLayer) Descriptor() (*v1.Descriptor, error) {
+	return partial.Descriptor(l.Layer)
+}
+
+// NewReader returns a reader that reports the bytes read from rc, the
+// transfer is done when rc returns io.EOF, an error or is closed
+func NewReader(rc io.ReadCloser, r Reporter, id string, total int64) io.ReadCloser {
+	r.Start(id, total)
+	return &reader{rc: rc, reporter: r, id: id}
+}
+
+type reader struct {
+	rc       io.ReadCloser
+	reporter Reporter
+	id       string
+	read     int64
+	once     sync.Once
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.reporter.Update(r.id, r.read)
+	}
+
+	switch {
+	case err == io.EOF:
+		r.done(nil)
+	case err != nil:
+		r.done(err)
+	}
+
+	return n, err
+}
+
+func (r *reader) Close() error {
+	r.done(nil)
+	return r.rc.Close()
+}
+
+func (r *reader) done(err error) {
+	r.once.Do(func() { r.reporter.Done(r.id, err) })
+}
@@ -0,0 +1,100 @@
+package progress
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a Reporter that records the calls it receives
+type recorder struct {
+	mu      sync.Mutex
+	started map[string]int64
+	updates map[string]int64
+	done    map[string][]error
+}
+
+func newRecorder() *recorder {
+	return &recorder{started: map[string]int64{}, updates: map[string]int64{}, done: map[string][]error{}}
+}
+
+func (r *recorder) Start(id string, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started[id] = total
+}
+
+func (r *recorder) Update(id string, complete int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates[id] = complete
+}
+
+func (r *recorder) Done(id string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done[id] = append(r.done[id], err)
+}
+
+func (r *recorder) Close() error { return nil }
+
+func TestWrapImageReportsLayerProgress(t *testing.T) {
+	i, err := random.Image(1024, 2)
+	require.NoError(t, err)
+
+	rec := newRecorder()
+	wi := WrapImage(i, rec)
+
+	layers, err := wi.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 2)
+
+	for _, l := range layers {
+		rc, err := l.Compressed()
+		require.NoError(t, err)
+
+		_, err = io.Copy(io.Discard, rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+
+		d, _ := l.Digest()
+		size, _ := l.Size()
+
+		require.Equal(t, size, rec.started[d.String()])
+		require.Equal(t, size, rec.updates[d.String()])
+		require.Equal(t, []error{nil}, rec.done[d.String()])
+	}
+}
+
+func TestWrapImageKeepsDigests(t *testing.T) {
+	i, err := random.Image(1024, 2)
+	require.NoError(t, err)
+
+	wi := WrapImage(i, newRecorder())
+
+	d, _ := i.Digest()
+	wd, err := wi.Digest()
+	require.NoError(t, err)
+	require.Equal(t, d, wd)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) { return 0, errors.New("connection reset") }
+func (failingReader) Close() error               { return nil }
+
+func TestReaderReportsErrorOnce(t *testing.T) {
+	rec := newRecorder()
+	rc := NewReader(failingReader{}, rec, "layer-0", -1)
+
+	_, err := io.ReadAll(rc)
+	require.Error(t, err)
+	require.NoError(t, rc.Close())
+
+	require.Len(t, rec.done["layer-0"], 1)
+	require.EqualError(t, rec.done["layer-0"][0], "connection reset")
+}
@@ -0,0 +1,117 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultJSONInterval is the interval between progress events
+const DefaultJSONInterval = time.Second
+
+// Event is a single line written by the JSONReporter
+type Event struct {
+	// Event is one of start, progress, done or summary
+	Event string `json:"event"`
+	// ID, Complete, Total and Error are set for start and done events
+	ID       string `json:"id,omitempty"`
+	Complete int64  `json:"complete"`
+	Total    int64  `json:"total"`
+	Error    string `json:"error,omitempty"`
+	// Layers is set for progress events and contains the active layers
+	Layers []Layer `json:"layers,omitempty"`
+	// Rate is in bytes per second, ETA and Elapsed are in seconds
+	Rate    float64 `json:"rate,omitempty"`
+	ETA     float64 `json:"eta,omitempty"`
+	Elapsed float64 `json:"elapsed,omitempty"`
+}
+
+// JSONReporter writes progress as JSON lines so that it can be consumed by
+// other programs. An event is written when each layer starts and finishes,
+// progress events containing the aggregate and the active layers are
+// written at a fixed interval and a summary event is written on Close.
+type JSONReporter struct {
+	*Tracker
+	ticker *ticker
+
+	// mu serialises writes to enc
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONReporter returns a reporter that writes JSON lines to out
+func NewJSONReporter(out io.Writer) *JSONReporter {
+	r := &JSONReporter{Tracker: NewTracker(), enc: json.NewEncoder(out)}
+	r.ticker = &ticker{interval: DefaultJSONInterval, render: r.render}
+
+	return r
+}
+
+func (r *JSONReporter) Start(id string, total int64) {
+	r.Tracker.Start(id, total)
+	r.write(Event{Event: "start", ID: id, Total: total})
+	r.ticker.start()
+}
+
+func (r *JSONReporter) Done(id string, err error) {
+	r.Tracker.Done(id, err)
+
+	l, _ := r.Tracker.Layer(id)
+
+	e := Event{Event: "done", ID: id, Complete: l.Complete, Total: l.Total}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	r.write(e)
+
+	if r.Snapshot().Active == 0 {
+		r.ticker.halt()
+	}
+}
+
+func (r *JSONReporter) Close() error {
+	r.ticker.halt()
+
+	s := r.Snapshot()
+	if len(s.Layers) == 0 {
+		return nil
+	}
+
+	e := aggregateEvent("summary", s)
+	e.Layers = nil
+
+	return r.write(e)
+}
+
+func (r *JSONReporter) render() {
+	s := r.Snapshot()
+
+	e := aggregateEvent("progress", s)
+	for _, l := range s.Layers {
+		if !l.Done {
+			e.Layers = append(e.Layers, l)
+		}
+	}
+
+	r.write(e)
+}
+
+func (r *JSONReporter) write(e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.enc.Encode(e)
+}
+
+func aggregateEvent(name string, s Snapshot) Event {
+	return Event{
+		Event:    name,
+		Complete: s.Complete,
+		Total:    s.Total,
+		Rate:     s.Rate,
+		ETA:      s.ETA.Seconds(),
+		Elapsed:  s.Elapsed.Seconds(),
+	}
+}
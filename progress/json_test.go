@@ -0,0 +1,56 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readEvents(t *testing.T, out *bytes.Buffer) []Event {
+	events := []Event{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		e := Event{}
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestJSONReporterWritesEvents(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	r := NewJSONReporter(out)
+
+	r.Start("sha256:abc", 100)
+	r.Update("sha256:abc", 100)
+	r.Done("sha256:abc", nil)
+	r.Start("sha256:def", 50)
+	r.Update("sha256:def", 10)
+	r.Done("sha256:def", errors.New("boom"))
+	require.NoError(t, r.Close())
+
+	events := readEvents(t, out)
+	require.Len(t, events, 5)
+
+	require.Equal(t, Event{Event: "start", ID: "sha256:abc", Total: 100}, events[0])
+	require.Equal(t, Event{Event: "done", ID: "sha256:abc", Complete: 100, Total: 100}, events[1])
+	require.Equal(t, "boom", events[3].Error)
+
+	require.Equal(t, "summary", events[4].Event)
+	require.Equal(t, int64(110), events[4].Complete)
+	require.Equal(t, int64(150), events[4].Total)
+}
+
+func TestJSONReporterStopsTickerWhenLayersAreDone(t *testing.T) {
+	r := NewJSONReporter(bytes.NewBuffer(nil))
+
+	r.Start("sha256:abc", 100)
+	require.NotNil(t, r.ticker.stop)
+
+	r.Done("sha256:abc", nil)
+	require.Nil(t, r.ticker.stop)
+}
@@ -0,0 +1,98 @@
+package progress
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// DefaultLogInterval is the interval between progress log lines
+const DefaultLogInterval = 5 * time.Second
+
+// LogReporter writes the progress of layers to a logger, the aggregate
+// progress is logged at a fixed interval while layers are transferring
+type LogReporter struct {
+	*Tracker
+	logger *log.Logger
+	ticker *ticker
+}
+
+// NewLogReporter returns a reporter that logs progress every interval, if
+// interval is zero DefaultLogInterval is used
+func NewLogReporter(logger *log.Logger, interval time.Duration) *LogReporter {
+	if interval <= 0 {
+		interval = DefaultLogInterval
+	}
+
+	r := &LogReporter{Tracker: NewTracker(), logger: logger}
+	r.ticker = &ticker{interval: interval, render: r.render}
+
+	return r
+}
+
+func (r *LogReporter) Start(id string, total int64) {
+	r.Tracker.Start(id, total)
+	r.logger.Debug("Transferring layer", "id", id, "size", sizeString(total))
+	r.ticker.start()
+}
+
+func (r *LogReporter) Done(id string, err error) {
+	r.Tracker.Done(id, err)
+
+	l, _ := r.Tracker.Layer(id)
+	if err != nil {
+		r.logger.Warn("Layer transfer failed", "id", id, "transferred", HumanBytes(l.Complete), "error", err)
+	} else {
+		r.logger.Info("Layer complete", "id", id, "size", HumanBytes(l.Complete))
+	}
+
+	if r.Snapshot().Active == 0 {
+		r.ticker.halt()
+	}
+}
+
+func (r *LogReporter) Close() error {
+	r.ticker.halt()
+
+	s := r.Snapshot()
+	if len(s.Layers) > 0 {
+		r.logger.Info("Transfer complete", "layers", len(s.Layers), "transferred", HumanBytes(s.Complete), "elapsed", s.Elapsed.Round(time.Millisecond), "rate", rateString(s.Rate))
+	}
+
+	return nil
+}
+
+func (r *LogReporter) render() {
+	s := r.Snapshot()
+
+	r.logger.Info(
+		"Transferring layers",
+		"complete", fmt.Sprintf("%.1f%%", s.Percentage()),
+		"transferred", HumanBytes(s.Complete),
+		"total", HumanBytes(s.Total),
+		"rate", rateString(s.Rate),
+		"eta", etaString(s.ETA),
+		"active", s.Active,
+	)
+}
+
+func sizeString(total int64) string {
+	if total < 0 {
+		return "unknown"
+	}
+
+	return HumanBytes(total)
+}
+
+func rateString(rate float64) string {
+	return HumanBytes(int64(rate)) + "/s"
+}
+
+func etaString(eta time.Duration) string {
+	if eta <= 0 {
+		return "unknown"
+	}
+
+	return eta.Round(time.Second).String()
+}
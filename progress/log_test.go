@@ -0,0 +1,50 @@
+package progress
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a buffer that can be written by the ticker goroutine while
+// it is read by the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestLogReporterLogsProgressWhileLayersAreActive(t *testing.T) {
+	out := &syncBuffer{}
+	r := NewLogReporter(log.New(out), time.Millisecond)
+
+	r.Start("sha256:abc", 1000)
+	r.Update("sha256:abc", 333)
+
+	require.Eventually(t, func() bool {
+		return bytes.Contains([]byte(out.String()), []byte("complete=33.3%"))
+	}, time.Second, time.Millisecond)
+
+	r.Done("sha256:abc", nil)
+	require.NoError(t, r.Close())
+
+	require.Contains(t, out.String(), "Layer complete")
+	require.Contains(t, out.String(), "Transfer complete")
+}
@@ -0,0 +1,251 @@
+// Package progress reports the transfer of image layers. Readers of layer
+// data report the bytes they have read to a Reporter, the reporters in this
+// package aggregate the progress of every layer and render it as a terminal
+// progress bar, log lines or JSON lines.
+package progress
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Reporter receives the progress of the layers that are being transferred,
+// methods can be called concurrently from the goroutines transferring layers
+type Reporter interface {
+	// Start is called when the transfer of a layer starts, total is the size
+	// of the layer in bytes or -1 when the size is not known
+	Start(id string, total int64)
+	// Update is called with the number of bytes of the layer transferred so far
+	Update(id string, complete int64)
+	// Done is called when the transfer of a layer finishes, err is nil when
+	// the layer was transferred successfully
+	Done(id string, err error)
+	// Close stops any background rendering and reports the final summary
+	Close() error
+}
+
+// Layer is the progress of a single layer
+type Layer struct {
+	ID       string `json:"id"`
+	Complete int64  `json:"complete"`
+	// Total is -1 when the size of the layer is not known
+	Total int64 `json:"total"`
+	Done  bool  `json:"done"`
+	Error error `json:"-"`
+}
+
+// Snapshot is the aggregate progress of all the layers that have started
+type Snapshot struct {
+	Layers []Layer
+	// Complete and Total are the bytes of all layers, Total only includes
+	// the layers where the size is known
+	Complete int64
+	Total    int64
+	// Active is the number of layers that have started and not finished
+	Active int
+	// Rate is the number of bytes transferred per second since the first
+	// layer started
+	Rate float64
+	// ETA is the estimated time until all started layers are complete,
+	// zero when it can not be estimated
+	ETA     time.Duration
+	Elapsed time.Duration
+}
+
+// Percentage returns the percentage of the total bytes that are complete
+func (s Snapshot) Percentage() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+
+	p := float64(s.Complete) / float64(s.Total) * 100
+	if p > 100 {
+		return 100
+	}
+
+	return p
+}
+
+// Tracker records the progress of each layer, reporters embed a Tracker and
+// render the Snapshot it returns
+type Tracker struct {
+	mu     sync.Mutex
+	layers map[string]*Layer
+	order  []string
+	start  time.Time
+	now    func() time.Time
+}
+
+// NewTracker returns an empty Tracker
+func NewTracker() *Tracker {
+	return &Tracker{layers: map[string]*Layer{}, now: time.Now}
+}
+
+// Start records the start of a layer, a layer that is started again
+// replaces its earlier progress
+func (t *Tracker) Start(id string, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.start.IsZero() {
+		t.start = t.now()
+	}
+
+	if _, ok := t.layers[id]; !ok {
+		t.order = append(t.order, id)
+	}
+
+	t.layers[id] = &Layer{ID: id, Total: total}
+}
+
+// Update records the bytes of the layer transferred so far
+func (t *Tracker) Update(id string, complete int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l, ok := t.layers[id]; ok {
+		l.Complete = complete
+	}
+}
+
+// Done records that the layer has finished
+func (t *Tracker) Done(id string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l, ok := t.layers[id]; ok {
+		l.Done = true
+		l.Error = err
+	}
+}
+
+// Layer returns the progress of the layer
+func (t *Tracker) Layer(id string) (Layer, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.layers[id]
+	if !ok {
+		return Layer{}, false
+	}
+
+	return *l, true
+}
+
+// Snapshot returns the aggregate progress of the layers, layers are listed
+// in the order they started
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Snapshot{}
+	for _, id := range t.order {
+		l := t.layers[id]
+		s.Layers = append(s.Layers, *l)
+		s.Complete += l.Complete
+
+		if l.Total >= 0 {
+			s.Total += l.Total
+		}
+
+		if !l.Done {
+			s.Active++
+		}
+	}
+
+	if t.start.IsZero() {
+		return s
+	}
+
+	s.Elapsed = t.now().Sub(t.start)
+	if s.Elapsed > 0 {
+		s.Rate = float64(s.Complete) / s.Elapsed.Seconds()
+	}
+
+	if s.Rate > 0 && s.Total > s.Complete {
+		s.ETA = time.Duration(float64(s.Total-s.Complete) / s.Rate * float64(time.Second))
+	}
+
+	return s
+}
+
+// Nop is a Reporter that discards all progress
+type Nop struct{}
+
+func (Nop) Start(id string, total int64)     {}
+func (Nop) Update(id string, complete int64) {}
+func (Nop) Done(id string, err error)        {}
+func (Nop) Close() error                     { return nil }
+
+// HumanBytes formats a number of bytes using SI units i.e. 1.5 GB
+func HumanBytes(b int64) string {
+	const unit = 1000
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
+}
+
+// ticker calls render at a fixed interval while layers are active, it is
+// started by the first layer and stopped when no layers are active so that
+// reporters that are never closed do not leak a goroutine
+type ticker struct {
+	interval time.Duration
+	render   func()
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (t *ticker) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stop != nil {
+		return
+	}
+
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		tk := time.NewTicker(t.interval)
+		defer tk.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tk.C:
+				t.render()
+			}
+		}
+	}(t.stop, t.done)
+}
+
+// halt stops the ticker and waits for the current render to finish, it must
+// not be called while holding a lock that render acquires
+func (t *ticker) halt() {
+	t.mu.Lock()
+	stop, done := t.stop, t.done
+	t.stop, t.done = nil, nil
+	t.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	<-done
+}
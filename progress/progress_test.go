@@ -0,0 +1,87 @@
+package progress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setupTracker returns a tracker with a clock that is advanced manually
+func setupTracker(t *testing.T) (*Tracker, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tr := NewTracker()
+	tr.now = func() time.Time { return now }
+
+	return tr, &now
+}
+
+func TestPercentageIsFractional(t *testing.T) {
+	s := Snapshot{Complete: 1, Total: 3}
+	require.InDelta(t, 33.33, s.Percentage(), 0.01)
+}
+
+func TestPercentageWithUnknownTotalIsZero(t *testing.T) {
+	s := Snapshot{Complete: 100, Total: 0}
+	require.Equal(t, float64(0), s.Percentage())
+}
+
+func TestSnapshotAggregatesLayers(t *testing.T) {
+	tr, now := setupTracker(t)
+
+	tr.Start("a", 1000)
+	tr.Start("b", 3000)
+	tr.Start("c", -1)
+
+	tr.Update("a", 1000)
+	tr.Update("b", 500)
+	tr.Update("c", 500)
+	tr.Done("a", nil)
+
+	*now = now.Add(2 * time.Second)
+
+	s := tr.Snapshot()
+	require.Len(t, s.Layers, 3)
+	require.Equal(t, "a", s.Layers[0].ID)
+	require.Equal(t, int64(2000), s.Complete)
+	require.Equal(t, int64(4000), s.Total)
+	require.Equal(t, 2, s.Active)
+	require.Equal(t, float64(1000), s.Rate)
+	require.Equal(t, 2*time.Second, s.ETA)
+	require.Equal(t, 2*time.Second, s.Elapsed)
+}
+
+func TestSnapshotWithoutLayersIsEmpty(t *testing.T) {
+	tr, _ := setupTracker(t)
+
+	s := tr.Snapshot()
+	require.Empty(t, s.Layers)
+	require.Zero(t, s.Rate)
+	require.Zero(t, s.ETA)
+}
+
+func TestHumanBytes(t *testing.T) {
+	require.Equal(t, "999 B", HumanBytes(999))
+	require.Equal(t, "1.5 kB", HumanBytes(1500))
+	require.Equal(t, "4.2 GB", HumanBytes(4200000000))
+}
+
+func TestTickerStopsWhenHalted(t *testing.T) {
+	renders := make(chan struct{}, 10)
+	tk := &ticker{interval: time.Millisecond, render: func() {
+		select {
+		case renders <- struct{}{}:
+		default:
+		}
+	}}
+
+	tk.start()
+	<-renders
+	tk.halt()
+
+	require.Nil(t, tk.stop)
+
+	// halting a stopped ticker is a no-op
+	tk.halt()
+}
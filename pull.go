@@ -0,0 +1,30 @@
+package kapsule
+
+import (
+	"context"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/nicholasjackson/kapsule/reader"
+)
+
+// Pull loads an image from a remote registry, layers are fetched lazily
+// when the image is exported. References using the oci-layout://path:tag
+// or oci:path:tag scheme are read from a local OCI layout and references
+// using the ollama://path:model scheme from an Ollama store. Encrypted
+// layers are returned as is, use WithDecryption with Export to decrypt them.
+func Pull(ctx context.Context, ref string, opts ...Option) (v1.Image, error) {
+	o := newOptions(opts)
+
+	o.logger.Info("Pulling image", "ref", ref)
+
+	if p, r, ok := reader.ParseOCILayoutRef(ref); ok {
+		return reader.NewOCILayout(o.logger, p).Pull(ctx, r)
+	}
+
+	if p, r, ok := reader.ParseOllamaRef(ref); ok {
+		return reader.NewOllama(o.logger, p).Pull(ctx, r)
+	}
+
+	r := reader.NewOCIRegistry(o.logger, o.registry)
+	return r.Pull(ctx, ref)
+}
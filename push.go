@@ -0,0 +1,25 @@
+package kapsule
+
+import (
+	"context"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/nicholasjackson/kapsule/writer"
+)
+
+// Push writes the image to a remote registry, if WithEncryption is specified
+// the layers are encrypted before they are pushed
+func Push(ctx context.Context, image v1.Image, ref string, opts ...Option) error {
+	o := newOptions(opts)
+
+	w := writer.NewOCIRegistry(o.logger, o.keyProvider(), o.registry)
+	w.SetJobs(o.jobs)
+	w.SetProgress(o.reporter())
+	w.SetEncryptLayers(o.encryptLayers)
+
+	if o.encryption != nil {
+		return w.WriteEncrypted(ctx, image, ref)
+	}
+
+	return w.Write(ctx, image, ref, o.decryption != nil, false)
+}
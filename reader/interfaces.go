@@ -1,8 +1,12 @@
 package reader
 
-import v1 "github.com/google/go-containerregistry/pkg/v1"
+import (
+	"context"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
 
 type Registry interface {
 	// Pull loads an image from a remote OCI registry
-	Pull(ref string) (v1.Image, error)
+	Pull(ctx context.Context, ref string) (v1.Image, error)
 }
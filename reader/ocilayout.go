@@ -0,0 +1,160 @@
+package reader
+
+import (
+	"context"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/nicholasjackson/kapsule/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// OCILayoutScheme is the prefix for references to images in a local OCI layout
+	// i.e. oci-layout://./output:kapsule.io/nicholasjackson/mistral:tune
+	OCILayoutScheme = "oci-layout://"
+	// OCIScheme is the short form of OCILayoutScheme i.e. oci:./output:mistral:tune
+	OCIScheme = "oci:"
+)
+
+// ParseOCILayoutRef splits a reference that uses the oci-layout:// or oci:
+// scheme into the path of the layout and the tag or digest of the image.
+// If ref does not use either scheme ok is false.
+func ParseOCILayoutRef(ref string) (path, imageRef string, ok bool) {
+	switch {
+	case strings.HasPrefix(ref, OCILayoutScheme):
+		ref = strings.TrimPrefix(ref, OCILayoutScheme)
+	case strings.HasPrefix(ref, OCIScheme):
+		ref = strings.TrimPrefix(ref, OCIScheme)
+	default:
+		return "", "", false
+	}
+
+	path, imageRef, _ = strings.Cut(ref, ":")
+	return path, imageRef, true
+}
+
+// OCILayout reads images from an OCI image layout on the local disk
+type OCILayout struct {
+	logger   *log.Logger
+	filePath string
+}
+
+func NewOCILayout(logger *log.Logger, path string) *OCILayout {
+	return &OCILayout{
+		logger:   logger,
+		filePath: path,
+	}
+}
+
+// Pull loads an image from the layout, imageRef can be the manifest digest,
+// the name recorded in the org.opencontainers.image.ref.name annotation or
+// the tag part of that name. If imageRef is empty and the layout contains a
+// single image that image is returned.
+func (l *OCILayout) Pull(ctx context.Context, imageRef string) (v1.Image, error) {
+	idx, d, err := l.find(ctx, imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	l.logger.Debug("Found image in OCI layout", "path", l.filePath, "ref", imageRef, "digest", d.Digest)
+
+	return idx.Image(d.Digest)
+}
+
+// Name returns the name recorded in the org.opencontainers.image.ref.name
+// annotation of the image matching imageRef, imageRef is resolved the same
+// way as Pull so a tag or digest returns the full name of the image
+func (l *OCILayout) Name(ctx context.Context, imageRef string) (string, error) {
+	_, d, err := l.find(ctx, imageRef)
+	if err != nil {
+		return "", err
+	}
+
+	n := d.Annotations[ocispec.AnnotationRefName]
+	if n == "" {
+		return "", types.Errorf(types.ErrorKindNotFound, "image %s in OCI layout at %s has no name", d.Digest, l.filePath)
+	}
+
+	return n, nil
+}
+
+// find returns the index of the layout and the descriptor of the image
+// matching imageRef
+func (l *OCILayout) find(ctx context.Context, imageRef string) (v1.ImageIndex, *v1.Descriptor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	p, err := layout.FromPath(l.filePath)
+	if err != nil {
+		return nil, nil, types.Errorf(types.ErrorKindNotFound, "unable to open OCI layout at %s: %w", l.filePath, err)
+	}
+
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, nil, types.Errorf(types.ErrorKindIO, "unable to read index for OCI layout at %s: %w", l.filePath, err)
+	}
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, nil, types.Errorf(types.ErrorKindIO, "unable to read index for OCI layout at %s: %w", l.filePath, err)
+	}
+
+	d, err := types.FindManifest(im.Manifests, imageRef)
+	if err != nil {
+		return nil, nil, types.Errorf(types.ErrorKindNotFound, "unable to find image %s in OCI layout at %s: %w", imageRef, l.filePath, err)
+	}
+
+	return idx, d, nil
+}
+
+// List returns a summary of each image in the layout, images are identified
+// by the name in the org.opencontainers.image.ref.name annotation or by
+// their digest when the annotation is not set
+func (l *OCILayout) List(ctx context.Context) ([]Summary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p, err := layout.FromPath(l.filePath)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindNotFound, "unable to open OCI layout at %s: %w", l.filePath, err)
+	}
+
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindIO, "unable to read index for OCI layout at %s: %w", l.filePath, err)
+	}
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindIO, "unable to read index for OCI layout at %s: %w", l.filePath, err)
+	}
+
+	summaries := []Summary{}
+	for _, m := range im.Manifests {
+		ref := m.Annotations[ocispec.AnnotationRefName]
+		if ref == "" {
+			ref = m.Digest.String()
+		}
+
+		i, err := idx.Image(m.Digest)
+		if err != nil {
+			return nil, types.Errorf(types.ErrorKindNotFound, "unable to read image %s in OCI layout at %s: %w", ref, l.filePath, err)
+		}
+
+		s, err := summarize(ref, i)
+		if err != nil {
+			return nil, types.Errorf(types.ErrorKindCorrupt, "unable to read image %s in OCI layout at %s: %w", ref, l.filePath, err)
+		}
+
+		summaries = append(summaries, s)
+	}
+
+	sortSummaries(summaries)
+
+	return summaries, nil
+}
@@ -0,0 +1,146 @@
+package reader
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/nicholasjackson/kapsule/testutils"
+	"github.com/nicholasjackson/kapsule/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func setupLayout(t *testing.T, names ...string) (*OCILayout, string, []v1.Image) {
+	td := t.TempDir()
+
+	p, err := layout.Write(td, empty.Index)
+	require.NoError(t, err)
+
+	images := []v1.Image{}
+	for _, n := range names {
+		i, err := random.Image(100, 1)
+		require.NoError(t, err)
+
+		err = p.AppendImage(i, layout.WithAnnotations(map[string]string{ocispec.AnnotationRefName: n}))
+		require.NoError(t, err)
+
+		images = append(images, i)
+	}
+
+	return NewOCILayout(testutils.CreateTestLogger(t), td), td, images
+}
+
+func requireSameImage(t *testing.T, expected, actual v1.Image) {
+	ed, err := expected.Digest()
+	require.NoError(t, err)
+
+	ad, err := actual.Digest()
+	require.NoError(t, err)
+
+	require.Equal(t, ed, ad)
+}
+
+func TestParseOCILayoutRef(t *testing.T) {
+	p, r, ok := ParseOCILayoutRef("oci-layout://./output:kapsule.io/nicholasjackson/test:v1")
+	require.True(t, ok)
+	require.Equal(t, "./output", p)
+	require.Equal(t, "kapsule.io/nicholasjackson/test:v1", r)
+
+	p, r, ok = ParseOCILayoutRef("oci:/tmp/output:v1")
+	require.True(t, ok)
+	require.Equal(t, "/tmp/output", p)
+	require.Equal(t, "v1", r)
+
+	_, _, ok = ParseOCILayoutRef("docker.io/nicholasjackson/test:v1")
+	require.False(t, ok)
+}
+
+func TestLayoutPullReturnsImageByDigest(t *testing.T) {
+	l, _, images := setupLayout(t, "kapsule.io/nicholasjackson/test:v1", "kapsule.io/nicholasjackson/test:v2")
+
+	d, err := images[1].Digest()
+	require.NoError(t, err)
+
+	i, err := l.Pull(context.Background(), d.String())
+	require.NoError(t, err)
+	requireSameImage(t, images[1], i)
+}
+
+func TestLayoutPullReturnsImageByName(t *testing.T) {
+	l, _, images := setupLayout(t, "kapsule.io/nicholasjackson/test:v1", "kapsule.io/nicholasjackson/test:v2")
+
+	i, err := l.Pull(context.Background(), "nicholasjackson/test:v2")
+	require.NoError(t, err)
+	requireSameImage(t, images[1], i)
+}
+
+func TestLayoutPullReturnsImageByTag(t *testing.T) {
+	l, _, images := setupLayout(t, "kapsule.io/nicholasjackson/test:v1", "kapsule.io/nicholasjackson/test:v2")
+
+	i, err := l.Pull(context.Background(), "v1")
+	require.NoError(t, err)
+	requireSameImage(t, images[0], i)
+}
+
+func TestLayoutPullReturnsErrorWhenTagIsAmbiguous(t *testing.T) {
+	l, _, _ := setupLayout(t, "kapsule.io/nicholasjackson/test:v1", "kapsule.io/nicholasjackson/other:v1")
+
+	_, err := l.Pull(context.Background(), "v1")
+	require.Error(t, err)
+}
+
+func TestLayoutPullReturnsOnlyImageWhenRefEmpty(t *testing.T) {
+	l, _, images := setupLayout(t, "kapsule.io/nicholasjackson/test:v1")
+
+	i, err := l.Pull(context.Background(), "")
+	require.NoError(t, err)
+	requireSameImage(t, images[0], i)
+}
+
+func TestLayoutPullReturnsNotFound(t *testing.T) {
+	l, _, _ := setupLayout(t, "kapsule.io/nicholasjackson/test:v1")
+
+	_, err := l.Pull(context.Background(), "nicholasjackson/test:v2")
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindNotFound, types.ErrorKindOf(err))
+}
+
+func TestLayoutNameReturnsNameOfImageByTagOrDigest(t *testing.T) {
+	l, _, images := setupLayout(t, "kapsule.io/nicholasjackson/test:v1", "kapsule.io/nicholasjackson/test:v2")
+
+	n, err := l.Name(context.Background(), "v2")
+	require.NoError(t, err)
+	require.Equal(t, "kapsule.io/nicholasjackson/test:v2", n)
+
+	d, err := images[0].Digest()
+	require.NoError(t, err)
+
+	n, err = l.Name(context.Background(), d.String())
+	require.NoError(t, err)
+	require.Equal(t, "kapsule.io/nicholasjackson/test:v1", n)
+}
+
+func TestLayoutListReturnsImages(t *testing.T) {
+	l, _, images := setupLayout(t, "kapsule.io/library/mistral:b", "kapsule.io/library/mistral:a")
+
+	s, err := l.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, s, 2)
+
+	require.Equal(t, "kapsule.io/library/mistral:a", s[0].Ref)
+	d, _ := images[1].Digest()
+	require.Equal(t, d.String(), s[0].Digest)
+	mf, _ := images[1].Manifest()
+	require.Equal(t, mf.Layers[0].Size, s[0].Size)
+}
+
+func TestLayoutListReturnsNotFound(t *testing.T) {
+	l := NewOCILayout(testutils.CreateTestLogger(t), t.TempDir())
+
+	_, err := l.List(context.Background())
+	require.Equal(t, types.ErrorKindNotFound, types.ErrorKindOf(err))
+}
@@ -1,94 +1,178 @@
 package reader
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
-	"net/http"
-	"time"
+	"sort"
 
 	"github.com/charmbracelet/log"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/nicholasjackson/kapsule/registry"
 )
 
 type OCIRegistry struct {
-	logger   *log.Logger
-	username string
-	password string
-	insecure bool
+	logger  *log.Logger
+	options registry.Options
 }
 
-func NewOCIRegistry(logger *log.Logger, username, password string, insecure bool) *OCIRegistry {
+func NewOCIRegistry(logger *log.Logger, options registry.Options) *OCIRegistry {
 	return &OCIRegistry{
-		logger:   logger,
-		username: username,
-		password: password,
-		insecure: insecure,
+		logger:  logger,
+		options: options,
 	}
 }
 
-// PullFromRegistry loads an image from a remote OCI registry
-func (r *OCIRegistry) Pull(imageRef string) (v1.Image, error) {
-	ref, err := name.ParseReference(imageRef)
+// Pull loads an image from a remote OCI registry, the context is used for
+// fetching the manifest and any layers that are subsequently read. When
+// mirrors are configured for the registry they are tried in order before
+// falling back to the upstream registry.
+func (r *OCIRegistry) Pull(ctx context.Context, imageRef string) (v1.Image, error) {
+	// registries that serve plain HTTP are accessed using http
+	ref, err := r.options.ParseReference(imageRef)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	b := authn.Basic{
-		Username: r.username,
-		Password: r.password,
+	mirrors, err := r.options.Mirrors(ref)
+	if err != nil {
+		return nil, err
 	}
 
-	cfg, err := b.Authorization()
+	for _, m := range mirrors {
+		i, err := r.pull(ctx, m)
+		if err == nil {
+			r.logger.Info("Pulling image from mirror", "endpoint", m.Context().RegistryStr(), "ref", m.String())
+			return i, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		r.logger.Warn("Unable to pull image from mirror, trying next endpoint", "ref", m.String(), "error", err)
+	}
+
+	i, err := r.pull(ctx, ref)
 	if err != nil {
 		return nil, err
 	}
 
-	auth := authn.FromConfig(*cfg)
+	r.logger.Info("Pulling image from registry", "endpoint", ref.Context().RegistryStr(), "ref", ref.String())
 
-	transport := remote.DefaultTransport
-	if r.insecure {
-		transport.(*http.Transport).TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+	return i, nil
+}
+
+// pull fetches the manifest of the image from the registry of ref
+func (r *OCIRegistry) pull(ctx context.Context, ref name.Reference) (v1.Image, error) {
+	// each registry gets its own transport so that the TLS settings are
+	// never shared with other registries
+	transport, err := r.options.Transport(ref.Context().Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	// credentials passed explicitly take precedence over those stored by
+	// kapsule login, docker login or podman login
+	i, err := remote.Image(
+		ref,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(r.options.Keychain()),
+		remote.WithTransport(transport),
+		remote.WithRetryBackoff(r.options.RetryBackoff()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// layers are downloaded from the registry that served the manifest,
+	// interrupted downloads are resumed rather than restarted
+	return &resumableImage{Image: i, ctx: ctx, ref: ref, options: r.options}, nil
+}
+
+// Catalog returns the repositories in the registry using the catalog API,
+// registries that do not support the catalog API return an error
+func (r *OCIRegistry) Catalog(ctx context.Context, host string) ([]string, error) {
+	reg, err := r.options.ParseRegistry(host)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := r.options.Transport(reg)
+	if err != nil {
+		return nil, err
 	}
 
-	return remote.Image(ref, remote.WithAuth(auth), remote.WithProgress(r.progressReport()), remote.WithTransport(transport))
+	repos, err := remote.Catalog(
+		ctx,
+		reg,
+		remote.WithAuthFromKeychain(r.options.Keychain()),
+		remote.WithTransport(transport),
+		remote.WithRetryBackoff(r.options.RetryBackoff()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list repositories in %s: %w", host, err)
+	}
+
+	sort.Strings(repos)
+
+	return repos, nil
 }
 
-func (r *OCIRegistry) progressReport() chan v1.Update {
-	ch := make(chan v1.Update, 1)
-	total := int64(0)
-	completed := int64(0)
+// Tags returns the tags of the repository
+func (r *OCIRegistry) Tags(ctx context.Context, repository string) ([]string, error) {
+	repo, err := r.options.ParseRepository(repository)
+	if err != nil {
+		return nil, err
+	}
 
-	t := time.AfterFunc(5*time.Second, func() {
-		percentage := "0%"
+	transport, err := r.options.Transport(repo.Registry)
+	if err != nil {
+		return nil, err
+	}
 
-		if completed > 0 && total > 0 {
-			p := int((completed / total) * 100)
+	tags, err := remote.List(
+		repo,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(r.options.Keychain()),
+		remote.WithTransport(transport),
+		remote.WithRetryBackoff(r.options.RetryBackoff()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tags for %s: %w", repository, err)
+	}
+
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+// List returns a summary of each tagged image in the repository, only the
+// manifest and config of each image is fetched
+func (r *OCIRegistry) List(ctx context.Context, repository string) ([]Summary, error) {
+	tags, err := r.Tags(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
 
-			percentage = fmt.Sprintf("%d%%", p)
+	summaries := []Summary{}
+	for _, t := range tags {
+		ref := repository + ":" + t
 
-			r.logger.Info("Pulling image", "complete", percentage, "total", total, "completed", completed)
+		i, err := r.Pull(ctx, ref)
+		if err != nil {
+			return nil, err
 		}
-	})
-
-	go func() {
-		for {
-			update, ok := <-ch
-			if !ok {
-				r.logger.Info("Pull image complete")
-				t.Stop()
-				return
-			}
-
-			total = update.Total
-			completed = update.Complete
+
+		s, err := summarize(ref, i)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read image %s: %w", ref, err)
 		}
-	}()
 
-	return ch
+		summaries = append(summaries, s)
+	}
+
+	return summaries, nil
 }
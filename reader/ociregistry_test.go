@@ -1,13 +1,26 @@
 package reader
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/charmbracelet/log"
+	"github.com/google/go-containerregistry/pkg/name"
+	memregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 
 	"github.com/nicholasjackson/kapsule/builder"
 	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/registry"
 	"github.com/nicholasjackson/kapsule/testutils"
 	"github.com/nicholasjackson/kapsule/writer"
 	"github.com/stretchr/testify/require"
@@ -19,17 +32,17 @@ func setupRegistry(t *testing.T, ref string) (*OCIRegistry, *log.Logger) {
 	// create a builder and push to a registry
 	kp := keyproviders.NewFile("../test_fixtures/testmodel/public.key", "../test_fixtures/testmodel/private.key")
 	b := builder.NewBuilder()
-	w := writer.NewOCIRegistry(l, kp, "admin", "password", true)
+	w := writer.NewOCIRegistry(l, kp, registry.Options{Username: "admin", Password: "password", TLS: registry.TLS{InsecureSkipVerify: true}})
 
 	// build the image
-	i, err := b.Build("../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
+	i, err := b.Build(context.Background(), "../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
 	require.NoError(t, err)
 
 	// push the image
-	err = w.Write(i, ref, false, false)
+	err = w.Write(context.Background(), i, ref, false, false)
 	require.NoError(t, err)
 
-	return NewOCIRegistry(l, "admin", "password", true), l
+	return NewOCIRegistry(l, registry.Options{Username: "admin", Password: "password", TLS: registry.TLS{InsecureSkipVerify: true}}), l
 }
 
 func TestACCPullFromRegistry(t *testing.T) {
@@ -41,7 +54,150 @@ func TestACCPullFromRegistry(t *testing.T) {
 
 	r, _ := setupRegistry(t, ref)
 
-	i, err := r.Pull(ref)
+	i, err := r.Pull(context.Background(), ref)
 	require.NoError(t, err)
 	require.NotNil(t, i)
 }
+
+// setupMemoryRegistry starts an in memory registry, returning its host and
+// the number of requests it has served
+func setupMemoryRegistry(t *testing.T) (string, *int64) {
+	requests := new(int64)
+	reg := memregistry.New()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	return strings.TrimPrefix(ts.URL, "http://"), requests
+}
+
+func pushRandomImage(t *testing.T, ref string) v1.Image {
+	i, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	r, err := name.ParseReference(ref)
+	require.NoError(t, err)
+
+	require.NoError(t, remote.Write(r, i))
+
+	return i
+}
+
+func TestRegistryPullUsesMirror(t *testing.T) {
+	upstream, upstreamRequests := setupMemoryRegistry(t)
+	mirror, _ := setupMemoryRegistry(t)
+
+	i := pushRandomImage(t, mirror+"/dockerhub/library/mistral:latest")
+
+	r := NewOCIRegistry(testutils.CreateTestLogger(t), registry.Options{
+		Config: &registry.Config{Mirrors: map[string][]string{upstream: {mirror + "/dockerhub"}}},
+	})
+
+	pi, err := r.Pull(context.Background(), upstream+"/library/mistral:latest")
+	require.NoError(t, err)
+
+	d, _ := i.Digest()
+	pd, _ := pi.Digest()
+	require.Equal(t, d, pd)
+	require.Zero(t, atomic.LoadInt64(upstreamRequests))
+}
+
+func TestRegistryPullFallsBackToUpstream(t *testing.T) {
+	upstream, _ := setupMemoryRegistry(t)
+	empty, emptyRequests := setupMemoryRegistry(t)
+
+	i := pushRandomImage(t, upstream+"/library/mistral:latest")
+
+	// the first mirror is unavailable and the second does not have the image
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	r := NewOCIRegistry(testutils.CreateTestLogger(t), registry.Options{
+		Config: &registry.Config{Mirrors: map[string][]string{
+			upstream: {strings.TrimPrefix(down.URL, "http://"), empty},
+		}},
+	})
+
+	pi, err := r.Pull(context.Background(), upstream+"/library/mistral:latest")
+	require.NoError(t, err)
+
+	d, _ := i.Digest()
+	pd, _ := pi.Digest()
+	require.Equal(t, d, pd)
+	require.NotZero(t, atomic.LoadInt64(emptyRequests))
+}
+
+func TestRegistryPullReadsLayersFromRegistryThatServedImage(t *testing.T) {
+	upstream, _ := setupMemoryRegistry(t)
+	mirror, mirrorRequests := setupMemoryRegistry(t)
+
+	i := pushRandomImage(t, mirror+"/dockerhub/library/mistral:latest")
+	dir := t.TempDir()
+
+	r := NewOCIRegistry(testutils.CreateTestLogger(t), registry.Options{
+		Config:   &registry.Config{Mirrors: map[string][]string{upstream: {mirror + "/dockerhub"}}},
+		CacheDir: dir,
+	})
+
+	pi, err := r.Pull(context.Background(), upstream+"/library/mistral:latest")
+	require.NoError(t, err)
+
+	before := atomic.LoadInt64(mirrorRequests)
+
+	layers, err := pi.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+
+	want, _ := i.Layers()
+	wd, _ := want[0].Digest()
+
+	rc, err := layers[0].Compressed()
+	require.NoError(t, err)
+	defer rc.Close()
+
+	_, err = io.Copy(io.Discard, rc)
+	require.NoError(t, err)
+
+	d, _ := layers[0].Digest()
+	require.Equal(t, wd, d)
+	require.Greater(t, atomic.LoadInt64(mirrorRequests), before)
+
+	// completed downloads do not leave partial blobs
+	partials, _ := filepath.Glob(filepath.Join(dir, "blobs", "sha256", "*.partial"))
+	require.Empty(t, partials)
+}
+
+func TestRegistryCatalogReturnsRepositories(t *testing.T) {
+	host, _ := setupMemoryRegistry(t)
+
+	pushRandomImage(t, host+"/library/mistral:latest")
+	pushRandomImage(t, host+"/library/llama:latest")
+
+	r := NewOCIRegistry(testutils.CreateTestLogger(t), registry.Options{})
+
+	repos, err := r.Catalog(context.Background(), host)
+	require.NoError(t, err)
+	require.Equal(t, []string{"library/llama", "library/mistral"}, repos)
+}
+
+func TestRegistryListReturnsTags(t *testing.T) {
+	host, _ := setupMemoryRegistry(t)
+
+	i := pushRandomImage(t, host+"/library/mistral:tune")
+	pushRandomImage(t, host+"/library/mistral:latest")
+
+	r := NewOCIRegistry(testutils.CreateTestLogger(t), registry.Options{})
+
+	s, err := r.List(context.Background(), host+"/library/mistral")
+	require.NoError(t, err)
+	require.Len(t, s, 2)
+
+	d, _ := i.Digest()
+	require.Equal(t, host+"/library/mistral:tune", s[1].Ref)
+	require.Equal(t, d.String(), s[1].Digest)
+	mf, _ := i.Manifest()
+	require.Equal(t, mf.Layers[0].Size, s[1].Size)
+}
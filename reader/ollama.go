@@ -0,0 +1,281 @@
+package reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/stream"
+	gtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// OllamaScheme is the prefix for references to models in a local Ollama
+// store i.e. ollama://./models:registry.ollama.ai/library/mistral:latest,
+// when the path is empty the default Ollama store is used
+// i.e. ollama://:registry.ollama.ai/library/mistral:latest
+const OllamaScheme = "ollama://"
+
+// ParseOllamaRef splits a reference that uses the ollama:// scheme into the
+// path of the Ollama store and the model name. If ref does not use the scheme
+// ok is false.
+func ParseOllamaRef(ref string) (path, imageRef string, ok bool) {
+	if !strings.HasPrefix(ref, OllamaScheme) {
+		return "", "", false
+	}
+
+	path, imageRef, _ = strings.Cut(strings.TrimPrefix(ref, OllamaScheme), ":")
+	if path == "" {
+		path = DefaultOllamaPath()
+	}
+
+	return path, imageRef, true
+}
+
+// DefaultOllamaPath returns the location of the local Ollama model store,
+// this is the value of OLLAMA_MODELS or ~/.ollama/models
+func DefaultOllamaPath() string {
+	if p := os.Getenv("OLLAMA_MODELS"); p != "" {
+		return p
+	}
+
+	home, _ := os.UserHomeDir()
+	return path.Join(home, ".ollama", "models")
+}
+
+// Ollama reads models from an Ollama model store, the store has the
+// layout manifests/<registry>/<repo>/<tag> and blobs/sha256-<digest>
+type Ollama struct {
+	logger   *log.Logger
+	filePath string
+}
+
+func NewOllama(logger *log.Logger, path string) *Ollama {
+	return &Ollama{
+		logger:   logger,
+		filePath: path,
+	}
+}
+
+// Pull loads a model from the Ollama store and converts it into a Kapsule
+// image. Ollama media types are converted to Kapsule media types and the
+// parameters are converted to the Kapsule parameters format.
+func (o *Ollama) Pull(ctx context.Context, imageRef string) (v1.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cn := types.CanonicalRef(imageRef)
+	ref, err := name.ParseReference(cn)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindParse, "invalid image reference %s: %w", imageRef, err)
+	}
+
+	manifestPath := path.Join(o.filePath, "manifests", ref.Context().RegistryStr(), ref.Context().RepositoryStr(), ref.Identifier())
+	blobsFolder := path.Join(o.filePath, "blobs")
+
+	o.logger.Debug("Reading Ollama manifest", "path", manifestPath)
+
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, types.Errorf(types.ErrorKindNotFound, "unable to find model %s in Ollama store at %s", imageRef, o.filePath)
+		}
+
+		return nil, types.Errorf(types.ErrorKindIO, "unable to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	mf, err := v1.ParseManifest(f)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindParse, "unable to parse manifest %s: %w", manifestPath, err)
+	}
+
+	image := empty.Image
+	for _, l := range mf.Layers {
+		blob := path.Join(blobsFolder, fmt.Sprintf("%s-%s", l.Digest.Algorithm, l.Digest.Hex))
+
+		layer, err := o.convertLayer(blob, string(l.MediaType))
+		if err != nil {
+			return nil, err
+		}
+
+		image, err = mutate.AppendLayers(image, layer)
+		if err != nil {
+			return nil, fmt.Errorf("unable to append layer: %w", err)
+		}
+	}
+
+	return image, nil
+}
+
+// convertLayer creates a Kapsule layer from the uncompressed Ollama blob
+func (o *Ollama) convertLayer(blob, mediaType string) (v1.Layer, error) {
+	// layers that are not known to Kapsule keep the Ollama media type
+	// so that they can be written back to Ollama unchanged
+	mt := types.OllamaToKapsuleMediaType(mediaType)
+
+	o.logger.Debug("Converting Ollama layer", "blob", blob, "mediaType", mediaType, "newMediaType", mt)
+
+	f, err := os.Open(blob)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, types.Errorf(types.ErrorKindNotFound, "unable to find blob %s", blob)
+		}
+
+		return nil, types.Errorf(types.ErrorKindIO, "unable to open blob: %w", err)
+	}
+
+	var rc io.ReadCloser = f
+
+	if mt == types.KAPSULE_MEDIA_TYPE_PARAMETERS {
+		rc = types.ConvertOllamaParamsToKapsuleParams(f)
+		if rc == nil {
+			return nil, types.Errorf(types.ErrorKindParse, "unable to convert ollama parameters in %s", blob)
+		}
+	}
+
+	level := gzip.DefaultCompression
+	if mt == types.KAPSULE_MEDIA_TYPE_PARAMETERS {
+		level = 1
+	}
+
+	return stream.NewLayer(
+		rc,
+		stream.WithCompressionLevel(level),
+		stream.WithMediaType(gtypes.MediaType(mt)),
+	), nil
+}
+
+// ollamaLabels maps the fields of the Ollama config to the labels returned
+// by List, configs written by the Kapsule Ollama writer use model_familly
+var ollamaLabels = [][2]string{
+	{"model_format", "format"},
+	{"model_familly", "family"},
+	{"model_family", "family"},
+	{"model_type", "parameter_size"},
+	{"file_type", "quantization"},
+}
+
+// List returns a summary of each model in the store, the labels are read
+// from the Ollama config and the creation time is the modification time of
+// the manifest
+func (o *Ollama) List(ctx context.Context) ([]Summary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	manifests := path.Join(o.filePath, "manifests")
+
+	summaries := []Summary{}
+	err := filepath.WalkDir(manifests, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// hidden files are left by interrupted writes
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(manifests, p)
+		if err != nil {
+			return err
+		}
+
+		// manifests are stored as <registry>/<repo>/<tag>
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) < 3 {
+			return nil
+		}
+
+		ref := strings.Join(parts[:len(parts)-1], "/") + ":" + parts[len(parts)-1]
+
+		s, err := o.summarize(ref, p)
+		if err != nil {
+			return err
+		}
+
+		summaries = append(summaries, s)
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, types.Errorf(types.ErrorKindNotFound, "unable to find Ollama store at %s", o.filePath)
+		}
+
+		return nil, types.Errorf(types.ErrorKindIO, "unable to list models in Ollama store at %s: %w", o.filePath, err)
+	}
+
+	sortSummaries(summaries)
+
+	return summaries, nil
+}
+
+// summarize returns the summary of the model from the manifest at path
+func (o *Ollama) summarize(ref, manifestPath string) (Summary, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	fi, err := os.Stat(manifestPath)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	mf, err := v1.ParseManifest(bytes.NewReader(data))
+	if err != nil {
+		return Summary{}, types.Errorf(types.ErrorKindParse, "unable to parse manifest %s: %w", manifestPath, err)
+	}
+
+	d, _, err := v1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		return Summary{}, err
+	}
+
+	created := fi.ModTime().UTC()
+	s := Summary{Ref: ref, Digest: d.String(), Created: &created, Labels: map[string]string{}, Annotations: mf.Annotations}
+
+	for _, l := range mf.Layers {
+		s.Size += l.Size
+	}
+
+	// the config is optional, models without a config have no labels
+	blob := path.Join(o.filePath, "blobs", fmt.Sprintf("%s-%s", mf.Config.Digest.Algorithm, mf.Config.Digest.Hex))
+
+	cfg, err := os.ReadFile(blob)
+	if err != nil {
+		o.logger.Debug("Unable to read Ollama config", "ref", ref, "blob", blob, "error", err)
+		return s, nil
+	}
+
+	c := map[string]any{}
+	if err := json.Unmarshal(cfg, &c); err != nil {
+		o.logger.Debug("Unable to parse Ollama config", "ref", ref, "blob", blob, "error", err)
+		return s, nil
+	}
+
+	for _, fl := range ollamaLabels {
+		if v, ok := c[fl[0]].(string); ok && v != "" {
+			s.Labels[fl[1]] = v
+		}
+	}
+
+	return s, nil
+}
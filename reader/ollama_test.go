@@ -0,0 +1,124 @@
+package reader
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/nicholasjackson/kapsule/builder"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/testutils"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/nicholasjackson/kapsule/writer"
+	"github.com/stretchr/testify/require"
+)
+
+func setupOllama(t *testing.T, ref string) *Ollama {
+	l := testutils.CreateTestLogger(t)
+	td := t.TempDir()
+
+	// build an image and write it to an ollama store
+	b := builder.NewBuilder()
+	i, err := b.Build(context.Background(), "../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
+	require.NoError(t, err)
+
+	w := writer.NewOllamaWriter(l, &keyproviders.NullProvider{}, td)
+	err = w.Write(context.Background(), i, ref, false, true)
+	require.NoError(t, err)
+
+	return NewOllama(l, td)
+}
+
+func TestParseOllamaRef(t *testing.T) {
+	p, r, ok := ParseOllamaRef("ollama://./models:registry.ollama.ai/library/mistral:latest")
+	require.True(t, ok)
+	require.Equal(t, "./models", p)
+	require.Equal(t, "registry.ollama.ai/library/mistral:latest", r)
+
+	t.Setenv("OLLAMA_MODELS", "/ollama")
+	p, _, ok = ParseOllamaRef("ollama://:mistral")
+	require.True(t, ok)
+	require.Equal(t, "/ollama", p)
+
+	_, _, ok = ParseOllamaRef("docker.io/nicholasjackson/test:v1")
+	require.False(t, ok)
+}
+
+func TestOllamaPullConvertsLayers(t *testing.T) {
+	r := setupOllama(t, "nicholasjackson/test:v1")
+
+	i, err := r.Pull(context.Background(), "nicholasjackson/test:v1")
+	require.NoError(t, err)
+
+	layers, err := i.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 4)
+
+	mt, _ := layers[0].MediaType()
+	require.Equal(t, types.KAPSULE_MEDIA_TYPE_MODEL, string(mt))
+
+	mt, _ = layers[1].MediaType()
+	require.Equal(t, types.KAPSULE_MEDIA_TYPE_TEMPLATE, string(mt))
+
+	mt, _ = layers[2].MediaType()
+	require.Equal(t, types.KAPSULE_MEDIA_TYPE_SYSTEM, string(mt))
+
+	mt, _ = layers[3].MediaType()
+	require.Equal(t, types.KAPSULE_MEDIA_TYPE_PARAMETERS, string(mt))
+}
+
+func TestOllamaPullConvertsParameters(t *testing.T) {
+	r := setupOllama(t, "nicholasjackson/test:v1")
+
+	i, err := r.Pull(context.Background(), "nicholasjackson/test:v1")
+	require.NoError(t, err)
+
+	layers, err := i.Layers()
+	require.NoError(t, err)
+
+	rc, err := layers[3].Compressed()
+	require.NoError(t, err)
+
+	gzr, err := gzip.NewReader(rc)
+	require.NoError(t, err)
+
+	d, err := io.ReadAll(gzr)
+	require.NoError(t, err)
+
+	params := map[string][]string{}
+	err = json.Unmarshal(d, &params)
+	require.NoError(t, err)
+	require.Equal(t, []string{"[/INST]", "[INST]"}, params["stop"])
+	require.Equal(t, []string{"0.8"}, params["temperature"])
+}
+
+func TestOllamaPullReturnsNotFound(t *testing.T) {
+	r := setupOllama(t, "nicholasjackson/test:v1")
+
+	_, err := r.Pull(context.Background(), "nicholasjackson/test:v2")
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindNotFound, types.ErrorKindOf(err))
+}
+
+func TestOllamaListReturnsModels(t *testing.T) {
+	o := setupOllama(t, "kapsule.io/library/testmodel:latest")
+
+	s, err := o.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, s, 1)
+
+	require.Equal(t, "kapsule.io/library/testmodel:latest", s[0].Ref)
+	require.NotNil(t, s[0].Created)
+	require.Greater(t, s[0].Size, int64(0))
+	require.Equal(t, "llama", s[0].Labels["family"])
+	require.Equal(t, "Q4_0", s[0].Labels["quantization"])
+}
+
+func TestOllamaListReturnsNotFound(t *testing.T) {
+	o := NewOllama(testutils.CreateTestLogger(t), t.TempDir())
+
+	_, err := o.List(context.Background())
+	require.Equal(t, types.ErrorKindNotFound, types.ErrorKindOf(err))
+}
@@ -0,0 +1,114 @@
+package reader
+
+import (
+	"context"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/nicholasjackson/kapsule/registry"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// resumableImage is a remote image where the layer blobs are downloaded
+// with registry.OpenBlob so that interrupted downloads are resumed
+type resumableImage struct {
+	v1.Image
+	ctx     context.Context
+	ref     name.Reference
+	options registry.Options
+}
+
+func (i *resumableImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	rl := make([]v1.Layer, len(layers))
+	for n, l := range layers {
+		rl[n] = i.wrap(l)
+	}
+
+	return rl, nil
+}
+
+func (i *resumableImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	l, err := i.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.wrap(l), nil
+}
+
+func (i *resumableImage) LayerByDiffID(h v1.Hash) (v1.Layer, error) {
+	l, err := i.Image.LayerByDiffID(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.wrap(l), nil
+}
+
+// wrap returns the layer as a remote.MountableLayer so that pushing it to
+// another repository in the same registry mounts the blob rather than
+// uploading it again
+func (i *resumableImage) wrap(l v1.Layer) v1.Layer {
+	if ml, ok := l.(*remote.MountableLayer); ok {
+		l = ml.Layer
+	}
+
+	return &remote.MountableLayer{
+		Layer:     &resumableLayer{Layer: l, image: i},
+		Reference: i.ref,
+	}
+}
+
+// resumableLayer returns the compressed blob from registry.OpenBlob, all
+// other methods are served by the remote layer
+type resumableLayer struct {
+	v1.Layer
+	image *resumableImage
+}
+
+func (l *resumableLayer) Compressed() (io.ReadCloser, error) {
+	d, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	return l.image.options.OpenBlob(l.image.ctx, l.image.ref.Context(), d)
+}
+
+func (l *resumableLayer) Uncompressed() (io.ReadCloser, error) {
+	rc, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := types.Decompress(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return &readCloser{Reader: r, close: rc.Close}, nil
+}
+
+// Descriptor returns the descriptor of the remote layer so that fields
+// such as annotations are kept
+func (l *resumableLayer) Descriptor() (*v1.Descriptor, error) {
+	return partial.Descriptor(l.Layer)
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
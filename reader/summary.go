@@ -0,0 +1,93 @@
+package reader
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Summary describes an image in a registry or local store, it is created
+// from the manifest and config so the layers are never read
+type Summary struct {
+	Ref     string     `json:"ref"`
+	Digest  string     `json:"digest"`
+	Size    int64      `json:"size"`
+	Created *time.Time `json:"created,omitempty"`
+	// Labels are the labels from the config of the image, filters only
+	// match labels
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are the annotations of the manifest
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Matches returns true when the image has all the labels in filters, a
+// filter with an empty value only requires the label to be set
+func (s Summary) Matches(filters map[string]string) bool {
+	for k, v := range filters {
+		l, ok := s.Labels[k]
+		if !ok || (v != "" && l != v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseFilters parses filters in the form key=value or key, filters that
+// only have a key match images where the label is set
+func ParseFilters(filters []string) map[string]string {
+	m := map[string]string{}
+	for _, f := range filters {
+		k, v, _ := strings.Cut(f, "=")
+		m[k] = v
+	}
+
+	return m
+}
+
+// summarize returns the summary of the image
+func summarize(ref string, image v1.Image) (Summary, error) {
+	mf, err := image.Manifest()
+	if err != nil {
+		return Summary{}, err
+	}
+
+	d, err := image.Digest()
+	if err != nil {
+		return Summary{}, err
+	}
+
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return Summary{}, err
+	}
+
+	s := Summary{Ref: ref, Digest: d.String(), Labels: map[string]string{}, Annotations: mf.Annotations}
+
+	for _, l := range mf.Layers {
+		s.Size += l.Size
+	}
+
+	for k, v := range cf.Config.Labels {
+		s.Labels[k] = v
+	}
+
+	switch {
+	case !cf.Created.IsZero():
+		s.Created = &cf.Created.Time
+	case mf.Annotations[ocispec.AnnotationCreated] != "":
+		if t, err := time.Parse(time.RFC3339, mf.Annotations[ocispec.AnnotationCreated]); err == nil {
+			s.Created = &t
+		}
+	}
+
+	return s, nil
+}
+
+// sortSummaries sorts the summaries by reference
+func sortSummaries(s []Summary) {
+	sort.Slice(s, func(i, j int) bool { return s[i].Ref < s[j].Ref })
+}
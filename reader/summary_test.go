@@ -0,0 +1,39 @@
+package reader
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFiltersReturnsLabels(t *testing.T) {
+	f := ParseFilters([]string{"quantization=Q4_K_M", "family", "description=a=b"})
+
+	require.Equal(t, map[string]string{"quantization": "Q4_K_M", "family": "", "description": "a=b"}, f)
+}
+
+func TestSummaryMatchesFilters(t *testing.T) {
+	s := Summary{Labels: map[string]string{"quantization": "Q4_K_M", "family": "llama"}}
+
+	require.True(t, s.Matches(nil))
+	require.True(t, s.Matches(map[string]string{"quantization": "Q4_K_M"}))
+	require.True(t, s.Matches(map[string]string{"family": ""}))
+	require.False(t, s.Matches(map[string]string{"quantization": "Q8_0"}))
+	require.False(t, s.Matches(map[string]string{"family": "llama", "format": ""}))
+}
+
+func TestSummarizeKeepsAnnotationsSeparateFromLabels(t *testing.T) {
+	i, err := mutate.Config(empty.Image, v1.Config{Labels: map[string]string{"family": "llama"}})
+	require.NoError(t, err)
+
+	i = mutate.Annotations(i, map[string]string{"org.opencontainers.image.source": "https://example.com"}).(v1.Image)
+
+	s, err := summarize("test:v1", i)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"family": "llama"}, s.Labels)
+	require.Equal(t, "https://example.com", s.Annotations["org.opencontainers.image.source"])
+	require.False(t, s.Matches(map[string]string{"org.opencontainers.image.source": ""}))
+}
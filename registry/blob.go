@@ -0,0 +1,335 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// DefaultCacheDir returns the folder used to store partially downloaded
+// blobs, ~/.kapsule/cache
+func DefaultCacheDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".kapsule", "cache")
+}
+
+// OpenBlob returns a reader for the blob with the digest d in the repository.
+// Downloads that fail part way through are resumed from the last byte that
+// was read using HTTP range requests, retrying with exponential backoff.
+// When CacheDir is set the downloaded bytes are also written to a partial
+// blob so that a later pull of the same blob only downloads the remainder,
+// while the blob is downloading the cache holds a second copy of the data
+// that has been read. The digest of the blob is verified before the reader
+// returns io.EOF, the partial blob is then removed.
+func (o *Options) OpenBlob(ctx context.Context, repo name.Repository, d v1.Hash) (io.ReadCloser, error) {
+	rt, err := o.Transport(repo.Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := o.Keychain().Resolve(repo)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindAuth, "unable to get credentials for %s: %w", repo.RegistryStr(), err)
+	}
+
+	t, err := transport.NewWithContext(ctx, repo.Registry, auth, rt, []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to authenticate with %s: %w", repo.RegistryStr(), err)
+	}
+
+	br := &blobReader{
+		ctx:    ctx,
+		client: &http.Client{Transport: t},
+		url:    fmt.Sprintf("%s://%s/v2/%s/blobs/%s", repo.Scheme(), repo.RegistryStr(), repo.RepositoryStr(), d),
+		digest: d,
+		hash:   sha256.New(),
+		retry:  o.retry(),
+	}
+
+	if o.CacheDir != "" && d.Algorithm == "sha256" {
+		err = br.openPartial(filepath.Join(o.CacheDir, "blobs", d.Algorithm, d.Hex+".partial"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return br, nil
+}
+
+// blobReader reads a blob from a registry, reconnecting from the current
+// offset when the connection fails
+type blobReader struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	digest v1.Hash
+	hash   hash.Hash
+	retry  Retry
+
+	// offset is the number of bytes that have been returned
+	offset int64
+	// failures is the number of consecutive failed attempts
+	failures int
+	body     io.ReadCloser
+
+	// partial contains the bytes downloaded by an earlier pull, these bytes
+	// are returned before any data is requested from the registry. The file
+	// is renamed to partialPath when the read is incomplete.
+	partial     *os.File
+	partialPath string
+	prefix      io.Reader
+}
+
+// openPartial claims the partial blob at path by renaming it to a file that
+// is only used by this reader, concurrent reads of the same blob never
+// write to the same file. Bytes already in the file are returned before the
+// remainder of the blob is requested, when another reader has claimed the
+// partial blob the download starts from the beginning.
+func (b *blobReader) openPartial(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to create cache folder: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*")
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to open partial blob: %w", err)
+	}
+
+	// the file is closed before the rename as open files can not be
+	// replaced on all platforms
+	claimed := f.Name()
+	f.Close()
+
+	err = os.Rename(path, claimed)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		os.Remove(claimed)
+		return types.Errorf(types.ErrorKindIO, "unable to open partial blob: %w", err)
+	}
+
+	f, err = os.OpenFile(claimed, os.O_RDWR, 0644)
+	if err != nil {
+		os.Remove(claimed)
+		return types.Errorf(types.ErrorKindIO, "unable to open partial blob: %w", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		os.Remove(claimed)
+		return types.Errorf(types.ErrorKindIO, "unable to open partial blob: %w", err)
+	}
+
+	b.partial = f
+	b.partialPath = path
+	b.prefix = io.NewSectionReader(f, 0, fi.Size())
+
+	// new data is appended to the partial blob
+	_, err = f.Seek(fi.Size(), io.SeekStart)
+	if err != nil {
+		f.Close()
+		os.Remove(claimed)
+		return types.Errorf(types.ErrorKindIO, "unable to open partial blob: %w", err)
+	}
+
+	return nil
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	if b.prefix != nil {
+		n, err := b.prefix.Read(p)
+		b.hash.Write(p[:n])
+		b.offset += int64(n)
+
+		if err == io.EOF {
+			b.prefix = nil
+			err = nil
+		}
+
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+
+	if b.body == nil {
+		body, err := b.request()
+		if err != nil {
+			return 0, err
+		}
+
+		b.body = body
+	}
+
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.failures = 0
+		b.hash.Write(p[:n])
+		b.offset += int64(n)
+
+		if b.partial != nil {
+			if _, werr := b.partial.Write(p[:n]); werr != nil {
+				return n, types.Errorf(types.ErrorKindIO, "unable to write partial blob: %w", werr)
+			}
+		}
+	}
+
+	if err == io.EOF {
+		return n, b.verify()
+	}
+
+	if err != nil {
+		b.body.Close()
+		b.body = nil
+
+		// the next read reconnects from the current offset
+		if rerr := b.backoff(err); rerr != nil {
+			return n, rerr
+		}
+
+		return n, nil
+	}
+
+	return n, nil
+}
+
+// request requests the blob from the current offset, retrying requests
+// that fail with a temporary error
+func (b *blobReader) request() (io.ReadCloser, error) {
+	for {
+		body, err := b.get()
+		if err == nil {
+			return body, nil
+		}
+
+		if rerr := b.backoff(err); rerr != nil {
+			return nil, rerr
+		}
+	}
+}
+
+// get requests the blob starting at the current offset
+func (b *blobReader) get() (io.ReadCloser, error) {
+	r, err := http.NewRequestWithContext(b.ctx, http.MethodGet, b.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.offset > 0 {
+		r.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.offset))
+	}
+
+	resp, err := b.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && b.offset > 0:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusOK:
+		// the registry does not support range requests, skip the bytes
+		// that have already been read
+		if _, err := io.CopyN(io.Discard, resp.Body, b.offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial blob already contains the whole blob, the digest is
+		// verified when the empty body is read
+		resp.Body.Close()
+		return http.NoBody, nil
+	}
+
+	defer resp.Body.Close()
+
+	return nil, &statusError{code: resp.StatusCode, err: transport.CheckError(resp, http.StatusOK, http.StatusPartialContent)}
+}
+
+// backoff waits before the next attempt, returning the error when the
+// request should not be retried
+func (b *blobReader) backoff(err error) error {
+	var se *statusError
+	temporary := isTemporary(err)
+	if errors.As(err, &se) {
+		temporary = isTemporaryStatus(se.code)
+		err = se.err
+	}
+
+	b.failures++
+	if !temporary || b.failures >= b.retry.Attempts {
+		return err
+	}
+
+	return sleep(b.ctx, b.retry.delay(b.failures))
+}
+
+// verify checks the digest of the data that was read, the partial blob is
+// removed as it is no longer needed or it does not match the digest
+func (b *blobReader) verify() error {
+	b.discardPartial()
+
+	written := hex.EncodeToString(b.hash.Sum(nil))
+	if written != b.digest.Hex {
+		return types.Errorf(types.ErrorKindCorrupt, "digest of downloaded blob sha256:%s does not match %s", written, b.digest)
+	}
+
+	return io.EOF
+}
+
+func (b *blobReader) discardPartial() {
+	if b.partial == nil {
+		return
+	}
+
+	b.partial.Close()
+	os.Remove(b.partial.Name())
+	b.partial = nil
+	b.prefix = nil
+}
+
+// Close closes the connection, partial blobs of incomplete reads are
+// released so that the next read can resume
+func (b *blobReader) Close() error {
+	if b.body != nil {
+		b.body.Close()
+		b.body = nil
+	}
+
+	if b.partial != nil {
+		b.partial.Close()
+
+		// when another reader has released a partial blob in the meantime
+		// it is replaced, both contain the start of the same blob
+		if err := os.Rename(b.partial.Name(), b.partialPath); err != nil {
+			os.Remove(b.partial.Name())
+		}
+
+		b.partial = nil
+	}
+
+	return nil
+}
+
+// statusError is an unsuccessful response from the registry
+type statusError struct {
+	code int
+	err  error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
@@ -0,0 +1,299 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	memregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/stretchr/testify/require"
+)
+
+var testRetry = Retry{Attempts: 3, Backoff: time.Millisecond}
+
+// flakyRegistry is an in-process registry where blob downloads can be
+// made to fail after half of the blob has been sent
+type flakyRegistry struct {
+	host string
+	// abort returns true if the n'th blob request, starting at 1, fails
+	abort func(n int) bool
+
+	mu       sync.Mutex
+	requests int
+	ranges   []string
+}
+
+func setupFlakyRegistry(t *testing.T, abort func(n int) bool) *flakyRegistry {
+	fr := &flakyRegistry{abort: abort}
+	reg := memregistry.New()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.Contains(r.URL.Path, "/blobs/") {
+			reg.ServeHTTP(w, r)
+			return
+		}
+
+		fr.mu.Lock()
+		fr.requests++
+		n := fr.requests
+		fr.ranges = append(fr.ranges, r.Header.Get("Range"))
+		fr.mu.Unlock()
+
+		// fetch the whole blob, ranges are served from the full response
+		rng := r.Header.Get("Range")
+		r.Header.Del("Range")
+
+		rec := httptest.NewRecorder()
+		reg.ServeHTTP(rec, r)
+
+		if rec.Code != http.StatusOK {
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+			return
+		}
+
+		data := rec.Body.Bytes()
+		code := http.StatusOK
+
+		if rng != "" {
+			offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			require.NoError(t, err)
+
+			if offset >= len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(data)-1, len(data)))
+			data = data[offset:]
+			code = http.StatusPartialContent
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(code)
+
+		if fr.abort != nil && fr.abort(n) {
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		w.Write(data)
+	}))
+	t.Cleanup(ts.Close)
+
+	fr.host = strings.TrimPrefix(ts.URL, "http://")
+
+	return fr
+}
+
+func (fr *flakyRegistry) blobRanges() []string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	return append([]string{}, fr.ranges...)
+}
+
+// pushRandomLayer writes a random layer to the repository and returns the
+// digest and compressed content of the layer
+func pushRandomLayer(t *testing.T, repo name.Repository) (v1.Hash, []byte) {
+	l, err := random.Layer(4096, "application/vnd.oci.image.layer.v1.tar+gzip")
+	require.NoError(t, err)
+
+	require.NoError(t, remote.WriteLayer(repo, l))
+
+	d, err := l.Digest()
+	require.NoError(t, err)
+
+	rc, err := l.Compressed()
+	require.NoError(t, err)
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+
+	return d, data
+}
+
+func partialPath(dir string, d v1.Hash) string {
+	return filepath.Join(dir, "blobs", d.Algorithm, d.Hex+".partial")
+}
+
+func TestOpenBlobResumesInterruptedDownload(t *testing.T) {
+	fr := setupFlakyRegistry(t, func(n int) bool { return n == 1 })
+
+	repo, err := name.NewRepository(fr.host + "/nicholasjackson/mistral")
+	require.NoError(t, err)
+
+	d, data := pushRandomLayer(t, repo)
+
+	o := &Options{Retry: testRetry}
+	rc, err := o.OpenBlob(context.Background(), repo, d)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	read, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	ranges := fr.blobRanges()
+	require.Len(t, ranges, 2)
+	require.Equal(t, "", ranges[0])
+	require.True(t, strings.HasPrefix(ranges[1], "bytes="))
+}
+
+func TestOpenBlobResumesFromPartialBlob(t *testing.T) {
+	fr := setupFlakyRegistry(t, nil)
+
+	repo, err := name.NewRepository(fr.host + "/nicholasjackson/mistral")
+	require.NoError(t, err)
+
+	d, data := pushRandomLayer(t, repo)
+
+	dir := t.TempDir()
+	half := len(data) / 2
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(partialPath(dir, d)), os.ModePerm))
+	require.NoError(t, os.WriteFile(partialPath(dir, d), data[:half], 0644))
+
+	o := &Options{Retry: testRetry, CacheDir: dir}
+	rc, err := o.OpenBlob(context.Background(), repo, d)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	read, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	require.Equal(t, []string{fmt.Sprintf("bytes=%d-", half)}, fr.blobRanges())
+	require.NoFileExists(t, partialPath(dir, d))
+}
+
+func TestOpenBlobKeepsPartialBlobWhenRetriesAreExhausted(t *testing.T) {
+	fr := setupFlakyRegistry(t, func(n int) bool { return true })
+
+	repo, err := name.NewRepository(fr.host + "/nicholasjackson/mistral")
+	require.NoError(t, err)
+
+	d, _ := pushRandomLayer(t, repo)
+
+	dir := t.TempDir()
+
+	o := &Options{Retry: Retry{Attempts: 1, Backoff: time.Millisecond}, CacheDir: dir}
+	rc, err := o.OpenBlob(context.Background(), repo, d)
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(rc)
+	require.Error(t, err)
+	require.NoError(t, rc.Close())
+
+	fi, err := os.Stat(partialPath(dir, d))
+	require.NoError(t, err)
+	require.Greater(t, fi.Size(), int64(0))
+}
+
+func TestOpenBlobConcurrentReadsDoNotShareThePartialBlob(t *testing.T) {
+	fr := setupFlakyRegistry(t, nil)
+
+	repo, err := name.NewRepository(fr.host + "/nicholasjackson/mistral")
+	require.NoError(t, err)
+
+	d, data := pushRandomLayer(t, repo)
+
+	dir := t.TempDir()
+	half := len(data) / 2
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(partialPath(dir, d)), os.ModePerm))
+	require.NoError(t, os.WriteFile(partialPath(dir, d), data[:half], 0644))
+
+	o := &Options{Retry: testRetry, CacheDir: dir}
+
+	first, err := o.OpenBlob(context.Background(), repo, d)
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := o.OpenBlob(context.Background(), repo, d)
+	require.NoError(t, err)
+	defer second.Close()
+
+	// the reads are interleaved so that both readers write at the same time
+	var a, b bytes.Buffer
+	for {
+		_, aerr := io.CopyN(&a, first, 512)
+		_, berr := io.CopyN(&b, second, 512)
+
+		if aerr != nil || berr != nil {
+			require.ErrorIs(t, aerr, io.EOF)
+			require.ErrorIs(t, berr, io.EOF)
+			break
+		}
+	}
+
+	require.Equal(t, data, a.Bytes())
+	require.Equal(t, data, b.Bytes())
+
+	// only the first reader resumed from the partial blob
+	require.ElementsMatch(t, []string{fmt.Sprintf("bytes=%d-", half), ""}, fr.blobRanges())
+
+	files, err := os.ReadDir(filepath.Dir(partialPath(dir, d)))
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestOpenBlobRemovesPartialBlobWithInvalidDigest(t *testing.T) {
+	fr := setupFlakyRegistry(t, nil)
+
+	repo, err := name.NewRepository(fr.host + "/nicholasjackson/mistral")
+	require.NoError(t, err)
+
+	d, data := pushRandomLayer(t, repo)
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(partialPath(dir, d)), os.ModePerm))
+	require.NoError(t, os.WriteFile(partialPath(dir, d), bytes.Repeat([]byte("a"), len(data)/2), 0644))
+
+	o := &Options{Retry: testRetry, CacheDir: dir}
+	rc, err := o.OpenBlob(context.Background(), repo, d)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	_, err = io.ReadAll(rc)
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindCorrupt, types.ErrorKindOf(err))
+	require.NoFileExists(t, partialPath(dir, d))
+}
+
+func TestOpenBlobDoesNotRetryMissingBlob(t *testing.T) {
+	fr := setupFlakyRegistry(t, nil)
+
+	repo, err := name.NewRepository(fr.host + "/nicholasjackson/mistral")
+	require.NoError(t, err)
+
+	d, err := v1.NewHash("sha256:" + strings.Repeat("0", 64))
+	require.NoError(t, err)
+
+	o := &Options{Retry: testRetry}
+	rc, err := o.OpenBlob(context.Background(), repo, d)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	_, err = io.ReadAll(rc)
+	require.Error(t, err)
+	require.Len(t, fr.blobRanges(), 1)
+}
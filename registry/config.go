@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// Config configures the connection to individual registries, it is read
+// from DefaultConfigFile or the file set with --config
+//
+//	{
+//	  "registries": {
+//	    "registry.internal:5000": {
+//	      "ca_cert": "/etc/kapsule/ca.pem",
+//	      "client_cert": "/etc/kapsule/client.pem",
+//	      "client_key": "/etc/kapsule/client-key.pem"
+//	    }
+//	  },
+//	  "insecure_registries": ["registry.local:5000"],
+//	  "mirrors": {
+//	    "docker.io": ["registry.internal/dockerhub"]
+//	  }
+//	}
+type Config struct {
+	// Registries is keyed by the host of the registry, including the port
+	Registries map[string]RegistryConfig `json:"registries,omitempty"`
+	// InsecureRegistries are the hosts of registries that serve plain HTTP
+	InsecureRegistries []string `json:"insecure_registries,omitempty"`
+	// Mirrors is keyed by the host of the upstream registry, the values are
+	// the mirrors that are tried in order before the upstream registry. A
+	// mirror can contain a path that is prepended to the repository.
+	Mirrors map[string][]string `json:"mirrors,omitempty"`
+}
+
+// RegistryConfig configures the connection to a registry
+type RegistryConfig struct {
+	TLS
+}
+
+// TLS configures the TLS connection to a registry
+type TLS struct {
+	// CACert is a PEM bundle of certificate authorities that are trusted in
+	// addition to the system certificate authorities
+	CACert string `json:"ca_cert,omitempty"`
+	// ClientCert and ClientKey are the PEM certificate and key presented
+	// to registries that require mutual TLS
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+	// InsecureSkipVerify disables the verification of the registry certificate
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// DefaultConfigFile returns the location of the Kapsule config file,
+// ~/.kapsule/config.json
+func DefaultConfigFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".kapsule", "config.json")
+}
+
+// LoadConfig reads the config file at path, an empty config is returned
+// when the file does not exist
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{Registries: map[string]RegistryConfig{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindIO, "unable to read config %s: %w", path, err)
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindParse, "unable to parse config %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Registry returns the config for the registry, keys are normalised in the
+// same way as image references so docker.io matches index.docker.io
+func (c *Config) Registry(reg name.Registry) RegistryConfig {
+	if c == nil {
+		return RegistryConfig{}
+	}
+
+	if rc, ok := c.Registries[reg.RegistryStr()]; ok {
+		return rc
+	}
+
+	for k, rc := range c.Registries {
+		if matchRegistry(k, reg) {
+			return rc
+		}
+	}
+
+	return RegistryConfig{}
+}
+
+// IsInsecure returns true if the registry is in InsecureRegistries
+func (c *Config) IsInsecure(reg name.Registry) bool {
+	if c == nil {
+		return false
+	}
+
+	for _, k := range c.InsecureRegistries {
+		if matchRegistry(k, reg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MirrorsFor returns the mirrors configured for the registry
+func (c *Config) MirrorsFor(reg name.Registry) []string {
+	if c == nil {
+		return nil
+	}
+
+	for k, m := range c.Mirrors {
+		if matchRegistry(k, reg) {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// matchRegistry returns true if the host from the config refers to reg
+func matchRegistry(host string, reg name.Registry) bool {
+	r, err := name.NewRegistry(host)
+	return err == nil && r.RegistryStr() == reg.RegistryStr()
+}
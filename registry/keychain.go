@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// keychain resolves the credentials for a registry, credentials passed
+// explicitly take precedence over credentials stored in the auth files
+type keychain struct {
+	username string
+	password string
+}
+
+// NewKeychain returns a keychain that resolves the credentials for a registry.
+// When username or password are set they are used for every registry,
+// otherwise the credentials are read from the first auth file that contains
+// an entry for the registry, see AuthFiles. Entries that reference a
+// docker-credential-* helper are resolved using the helper. Registries that
+// have no credentials are accessed anonymously.
+func NewKeychain(username, password string) authn.Keychain {
+	return &keychain{username: username, password: password}
+}
+
+func (k *keychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if k.username != "" || k.password != "" {
+		return &authn.Basic{Username: k.username, Password: k.password}, nil
+	}
+
+	key := authKey(target.RegistryStr())
+
+	for _, f := range AuthFiles() {
+		cf, err := loadAuthFile(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		cfg, err := cf.GetAuthConfig(key)
+		if err != nil {
+			return nil, types.Errorf(types.ErrorKindAuth, "unable to get credentials for %s from %s: %w", key, f, err)
+		}
+
+		ac := authn.AuthConfig{
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			Auth:          cfg.Auth,
+			IdentityToken: cfg.IdentityToken,
+			RegistryToken: cfg.RegistryToken,
+		}
+
+		// helpers and auth files can contain an entry without credentials
+		if ac == (authn.AuthConfig{}) {
+			continue
+		}
+
+		return authn.FromConfig(ac), nil
+	}
+
+	return authn.Anonymous, nil
+}
+
+// AuthFiles returns the auth files that are searched for credentials in
+// order of precedence: $REGISTRY_AUTH_FILE, the Docker config.json in
+// $DOCKER_CONFIG or ~/.docker and the podman auth.json in $XDG_RUNTIME_DIR
+func AuthFiles() []string {
+	files := []string{}
+
+	if f := os.Getenv("REGISTRY_AUTH_FILE"); f != "" {
+		files = append(files, f)
+	}
+
+	files = append(files, dockerConfigFile())
+
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		files = append(files, filepath.Join(d, "containers", "auth.json"))
+	}
+
+	return files
+}
+
+// dockerConfigFile returns the location of the Docker config.json
+func dockerConfigFile() string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".docker")
+	}
+
+	return filepath.Join(dir, "config.json")
+}
+
+// loadAuthFile reads a Docker config.json or podman auth.json, both files
+// store credentials in the same format
+func loadAuthFile(path string) (*configfile.ConfigFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cf := configfile.New(path)
+	err = cf.LoadFromReader(f)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindParse, "unable to read auth file %s: %w", path, err)
+	}
+
+	return cf, nil
+}
+
+// authKey returns the key of the registry in an auth file, Docker Hub
+// credentials are stored under the legacy index address
+func authKey(registry string) string {
+	if registry == name.DefaultRegistry {
+		return authn.DefaultAuthKey
+	}
+
+	return registry
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+)
+
+// setupAuthFiles points all the auth file locations at empty temporary
+// folders so that tests never read the credentials of the user
+func setupAuthFiles(t *testing.T) string {
+	td := t.TempDir()
+
+	t.Setenv("REGISTRY_AUTH_FILE", "")
+	t.Setenv("DOCKER_CONFIG", filepath.Join(td, "docker"))
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(td, "run"))
+
+	return td
+}
+
+func writeAuthFile(t *testing.T, path, contents string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+}
+
+func resolve(t *testing.T, kc authn.Keychain, registry string) *authn.AuthConfig {
+	reg, err := name.NewRegistry(registry)
+	require.NoError(t, err)
+
+	a, err := kc.Resolve(reg)
+	require.NoError(t, err)
+
+	cfg, err := a.Authorization()
+	require.NoError(t, err)
+
+	return cfg
+}
+
+func TestKeychainUsesExplicitCredentials(t *testing.T) {
+	td := setupAuthFiles(t)
+	writeAuthFile(t, filepath.Join(td, "docker", "config.json"), `{"auths": {"ghcr.io": {"auth": "ZG9ja2VyOnNlY3JldA=="}}}`)
+
+	cfg := resolve(t, NewKeychain("nic", "password"), "ghcr.io")
+	require.Equal(t, "nic", cfg.Username)
+	require.Equal(t, "password", cfg.Password)
+}
+
+func TestKeychainReadsDockerConfig(t *testing.T) {
+	td := setupAuthFiles(t)
+	writeAuthFile(t, filepath.Join(td, "docker", "config.json"), `{"auths": {"ghcr.io": {"auth": "ZG9ja2VyOnNlY3JldA=="}}}`)
+
+	cfg := resolve(t, NewKeychain("", ""), "ghcr.io")
+	require.Equal(t, "docker", cfg.Username)
+	require.Equal(t, "secret", cfg.Password)
+}
+
+func TestKeychainPrefersRegistryAuthFile(t *testing.T) {
+	td := setupAuthFiles(t)
+	writeAuthFile(t, filepath.Join(td, "docker", "config.json"), `{"auths": {"ghcr.io": {"auth": "ZG9ja2VyOnNlY3JldA=="}}}`)
+	writeAuthFile(t, filepath.Join(td, "auth.json"), `{"auths": {"ghcr.io": {"identitytoken": "token"}}}`)
+	t.Setenv("REGISTRY_AUTH_FILE", filepath.Join(td, "auth.json"))
+
+	cfg := resolve(t, NewKeychain("", ""), "ghcr.io")
+	require.Equal(t, "token", cfg.IdentityToken)
+}
+
+func TestKeychainReadsPodmanAuthFile(t *testing.T) {
+	td := setupAuthFiles(t)
+	writeAuthFile(t, filepath.Join(td, "run", "containers", "auth.json"), `{"auths": {"quay.io": {"auth": "cG9kbWFuOnNlY3JldA=="}}}`)
+
+	cfg := resolve(t, NewKeychain("", ""), "quay.io")
+	require.Equal(t, "podman", cfg.Username)
+}
+
+func TestKeychainUsesDockerHubKey(t *testing.T) {
+	td := setupAuthFiles(t)
+	writeAuthFile(t, filepath.Join(td, "docker", "config.json"), `{"auths": {"https://index.docker.io/v1/": {"auth": "ZG9ja2VyOnNlY3JldA=="}}}`)
+
+	cfg := resolve(t, NewKeychain("", ""), "docker.io")
+	require.Equal(t, "docker", cfg.Username)
+}
+
+func TestKeychainReturnsAnonymousWithoutCredentials(t *testing.T) {
+	setupAuthFiles(t)
+
+	cfg := resolve(t, NewKeychain("", ""), "ghcr.io")
+	require.Equal(t, authn.AuthConfig{}, *cfg)
+}
@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/docker/cli/cli/config/configfile"
+	clitypes "github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// LoginFile returns the auth file that login writes credentials to, this is
+// $REGISTRY_AUTH_FILE when set, otherwise the Docker config.json
+func LoginFile() string {
+	if f := os.Getenv("REGISTRY_AUTH_FILE"); f != "" {
+		return f
+	}
+
+	return dockerConfigFile()
+}
+
+// Login verifies the username and password from opts with the registry and
+// stores them in the file returned by LoginFile. When the file configures a
+// credential store or a credential helper for the registry the credentials
+// are stored using the docker-credential-* helper rather than in the file.
+func Login(ctx context.Context, opts Options, server string) error {
+	reg, err := opts.ParseRegistry(server)
+	if err != nil {
+		return err
+	}
+
+	t, err := opts.Transport(reg)
+	if err != nil {
+		return err
+	}
+
+	err = verifyCredentials(ctx, reg, t, &authn.Basic{Username: opts.Username, Password: opts.Password})
+	if err != nil {
+		return err
+	}
+
+	cf, err := openLoginFile()
+	if err != nil {
+		return err
+	}
+
+	key := authKey(reg.RegistryStr())
+
+	err = cf.GetCredentialsStore(key).Store(clitypes.AuthConfig{
+		ServerAddress: key,
+		Username:      opts.Username,
+		Password:      opts.Password,
+	})
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to store credentials in %s: %w", cf.Filename, err)
+	}
+
+	return nil
+}
+
+// Logout removes the credentials for the registry from the file returned
+// by LoginFile or the credential helper configured for the registry
+func Logout(server string) error {
+	reg, err := name.NewRegistry(server)
+	if err != nil {
+		return types.Errorf(types.ErrorKindParse, "invalid registry %s: %w", server, err)
+	}
+
+	cf, err := openLoginFile()
+	if err != nil {
+		return err
+	}
+
+	key := authKey(reg.RegistryStr())
+
+	// entries using a credential helper only store the helper in the file
+	_, inFile := cf.GetAuthConfigs()[key]
+	if !inFile && cf.CredentialsStore == "" && cf.CredentialHelpers[key] == "" {
+		return types.Errorf(types.ErrorKindNotFound, "not logged in to %s", key)
+	}
+
+	err = cf.GetCredentialsStore(key).Erase(key)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to remove credentials from %s: %w", cf.Filename, err)
+	}
+
+	return nil
+}
+
+// openLoginFile reads the login file, returning an empty file that will be
+// created on save when it does not exist
+func openLoginFile() (*configfile.ConfigFile, error) {
+	f := LoginFile()
+
+	cf, err := loadAuthFile(f)
+	if os.IsNotExist(err) {
+		return configfile.New(f), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cf, nil
+}
+
+// verifyCredentials authenticates with the registry and requests the /v2/
+// endpoint, registries that use basic auth only reject invalid credentials
+// when a request is made
+func verifyCredentials(ctx context.Context, reg name.Registry, rt http.RoundTripper, auth authn.Authenticator) error {
+	t, err := transport.NewWithContext(ctx, reg, auth, rt, nil)
+	if err != nil {
+		return fmt.Errorf("unable to authenticate with %s: %w", reg.RegistryStr(), err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/v2/", reg.Scheme(), reg.RegistryStr()), nil)
+	if err != nil {
+		return fmt.Errorf("unable to create request: %w", err)
+	}
+
+	resp, err := (&http.Client{Transport: t}).Do(r)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to connect to %s: %w", reg.RegistryStr(), err)
+	}
+	defer resp.Body.Close()
+
+	err = transport.CheckError(resp, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("unable to authenticate with %s: %w", reg.RegistryStr(), err)
+	}
+
+	return nil
+}
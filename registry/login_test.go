@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setupBasicAuthRegistry returns the address of a registry that only
+// accepts the credentials nic:password
+func setupBasicAuthRegistry(t *testing.T) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != "nic" || p != "password" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	return strings.TrimPrefix(ts.URL, "http://")
+}
+
+func TestLoginStoresCredentials(t *testing.T) {
+	td := setupAuthFiles(t)
+	addr := setupBasicAuthRegistry(t)
+
+	err := Login(context.Background(), Options{Username: "nic", Password: "password"}, addr)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(td, "docker", "config.json"))
+
+	cfg := resolve(t, NewKeychain("", ""), addr)
+	require.Equal(t, "nic", cfg.Username)
+	require.Equal(t, "password", cfg.Password)
+}
+
+func TestLoginWritesRegistryAuthFile(t *testing.T) {
+	td := setupAuthFiles(t)
+	addr := setupBasicAuthRegistry(t)
+	t.Setenv("REGISTRY_AUTH_FILE", filepath.Join(td, "auth.json"))
+
+	err := Login(context.Background(), Options{Username: "nic", Password: "password"}, addr)
+	require.NoError(t, err)
+
+	require.FileExists(t, filepath.Join(td, "auth.json"))
+	require.NoFileExists(t, filepath.Join(td, "docker", "config.json"))
+}
+
+func TestLoginReturnsErrorForInvalidCredentials(t *testing.T) {
+	td := setupAuthFiles(t)
+	addr := setupBasicAuthRegistry(t)
+
+	err := Login(context.Background(), Options{Username: "nic", Password: "wrong"}, addr)
+	require.ErrorContains(t, err, "unable to authenticate")
+
+	_, err = os.Stat(filepath.Join(td, "docker", "config.json"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestLogoutRemovesCredentials(t *testing.T) {
+	setupAuthFiles(t)
+	addr := setupBasicAuthRegistry(t)
+
+	err := Login(context.Background(), Options{Username: "nic", Password: "password"}, addr)
+	require.NoError(t, err)
+
+	err = Logout(addr)
+	require.NoError(t, err)
+
+	cfg := resolve(t, NewKeychain("", ""), addr)
+	require.Empty(t, cfg.Username)
+
+	err = Logout(addr)
+	require.ErrorContains(t, err, "not logged in")
+}
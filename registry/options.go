@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// Options configures how Kapsule connects to remote registries
+type Options struct {
+	// Username and Password take precedence over stored credentials
+	Username string
+	Password string
+	// TLS applies to every registry, settings from Config are used for
+	// the fields that are not set
+	TLS TLS
+	// PlainHTTP connects to every registry using plain HTTP, registries in
+	// the InsecureRegistries of Config always use plain HTTP
+	PlainHTTP bool
+	// Config contains the settings for individual registries
+	Config *Config
+	// Retry configures how failed requests are retried, DefaultRetry is
+	// used when not set
+	Retry Retry
+	// CacheDir is the folder used to store partially downloaded blobs so that
+	// an interrupted pull can be resumed, when empty partial blobs are not kept
+	CacheDir string
+}
+
+// ParseReference parses an image reference, references to registries that
+// serve plain HTTP are parsed with name.Insecure so that they are accessed
+// using http rather than https
+func (o *Options) ParseReference(ref string) (name.Reference, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindParse, "invalid image reference %s: %w", ref, err)
+	}
+
+	if !o.isPlainHTTP(r.Context().Registry) {
+		return r, nil
+	}
+
+	return name.ParseReference(ref, name.Insecure)
+}
+
+// Mirrors returns the references to the image in the mirrors configured for
+// the registry of ref, in the order they should be tried. The repository of
+// ref is appended to the mirror i.e. docker.io/library/mistral:latest with the
+// mirror registry.internal/dockerhub returns
+// registry.internal/dockerhub/library/mistral:latest.
+func (o *Options) Mirrors(ref name.Reference) ([]name.Reference, error) {
+	refs := []name.Reference{}
+
+	for _, m := range o.Config.MirrorsFor(ref.Context().Registry) {
+		mr, err := o.ParseReference(strings.TrimSuffix(m, "/") + "/" + ref.Context().RepositoryStr() + refSuffix(ref))
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirror %s: %w", m, err)
+		}
+
+		refs = append(refs, mr)
+	}
+
+	return refs, nil
+}
+
+// refSuffix returns the tag or digest part of the reference
+func refSuffix(ref name.Reference) string {
+	if _, ok := ref.(name.Digest); ok {
+		return "@" + ref.Identifier()
+	}
+
+	return ":" + ref.Identifier()
+}
+
+// ParseRegistry parses the host of a registry, registries that serve plain
+// HTTP are parsed with name.Insecure
+func (o *Options) ParseRegistry(host string) (name.Registry, error) {
+	reg, err := name.NewRegistry(host)
+	if err != nil {
+		return name.Registry{}, types.Errorf(types.ErrorKindParse, "invalid registry %s: %w", host, err)
+	}
+
+	if !o.isPlainHTTP(reg) {
+		return reg, nil
+	}
+
+	return name.NewRegistry(host, name.Insecure)
+}
+
+// ParseRepository parses the name of a repository i.e. docker.io/library/mistral,
+// repositories in registries that serve plain HTTP are parsed with name.Insecure
+func (o *Options) ParseRepository(repo string) (name.Repository, error) {
+	r, err := name.NewRepository(repo)
+	if err != nil {
+		return name.Repository{}, types.Errorf(types.ErrorKindParse, "invalid repository %s: %w", repo, err)
+	}
+
+	if !o.isPlainHTTP(r.Registry) {
+		return r, nil
+	}
+
+	return name.NewRepository(repo, name.Insecure)
+}
+
+func (o *Options) isPlainHTTP(reg name.Registry) bool {
+	return o.PlainHTTP || o.Config.IsInsecure(reg)
+}
+
+// Keychain returns the keychain used to resolve credentials for a registry
+func (o *Options) Keychain() authn.Keychain {
+	return NewKeychain(o.Username, o.Password)
+}
+
+// TLSFor returns the TLS settings for the registry, the settings from
+// Options take precedence over the settings in Config
+func (o *Options) TLSFor(reg name.Registry) TLS {
+	t := o.Config.Registry(reg).TLS
+
+	if o.TLS.CACert != "" {
+		t.CACert = o.TLS.CACert
+	}
+
+	if o.TLS.ClientCert != "" || o.TLS.ClientKey != "" {
+		t.ClientCert = o.TLS.ClientCert
+		t.ClientKey = o.TLS.ClientKey
+	}
+
+	t.InsecureSkipVerify = t.InsecureSkipVerify || o.TLS.InsecureSkipVerify
+
+	return t
+}
+
+// Transport returns a transport for the registry, every call returns a new
+// transport so the TLS settings of one registry never affect another
+func (o *Options) Transport(reg name.Registry) (http.RoundTripper, error) {
+	return o.TLSFor(reg).transport()
+}
+
+// transport clones the go-containerregistry default transport and applies
+// the TLS settings
+func (t TLS) transport() (*http.Transport, error) {
+	base, ok := remote.DefaultTransport.(*http.Transport)
+	if !ok {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+
+	tr := base.Clone()
+	tr.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CACert != "" {
+		pem, err := os.ReadFile(t.CACert)
+		if err != nil {
+			return nil, types.Errorf(types.ErrorKindIO, "unable to read CA certificate %s: %w", t.CACert, err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, types.Errorf(types.ErrorKindParse, "no certificates found in CA certificate %s", t.CACert)
+		}
+
+		tr.TLSClientConfig.RootCAs = pool
+	}
+
+	if t.ClientCert != "" || t.ClientKey != "" {
+		if t.ClientCert == "" || t.ClientKey == "" {
+			return nil, fmt.Errorf("both the client certificate and the client key must be specified")
+		}
+
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, types.Errorf(types.ErrorKindCrypto, "unable to load client certificate %s: %w", t.ClientCert, err)
+		}
+
+		tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tr, nil
+}
@@ -0,0 +1,232 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+)
+
+// writeServerCA writes the certificate of the test server as a PEM bundle
+func writeServerCA(t *testing.T, ts *httptest.Server) string {
+	f := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	require.NoError(t, os.WriteFile(f, data, 0600))
+
+	return f
+}
+
+// writeClientCert creates a self signed client certificate and returns
+// the paths of the certificate and key and the certificate pool for the server
+func writeClientCert(t *testing.T) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kapsule"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	kd, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	td := t.TempDir()
+	cert := filepath.Join(td, "client.pem")
+	keyFile := filepath.Join(td, "client-key.pem")
+
+	require.NoError(t, os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kd}), 0600))
+
+	c, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(c)
+
+	return cert, keyFile, pool
+}
+
+func get(t *testing.T, o *Options, url string) error {
+	reg, err := name.NewRegistry(strings.TrimPrefix(url, "https://"))
+	require.NoError(t, err)
+
+	rt, err := o.Transport(reg)
+	require.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: rt}).Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	return err
+}
+
+func TestTransportTrustsCACert(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(ts.Close)
+
+	err := get(t, &Options{}, ts.URL)
+	require.Error(t, err)
+
+	err = get(t, &Options{TLS: TLS{CACert: writeServerCA(t, ts)}}, ts.URL)
+	require.NoError(t, err)
+}
+
+func TestTransportUsesCACertFromConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(ts.Close)
+
+	c := &Config{Registries: map[string]RegistryConfig{
+		strings.TrimPrefix(ts.URL, "https://"): {TLS: TLS{CACert: writeServerCA(t, ts)}},
+	}}
+
+	err := get(t, &Options{Config: c}, ts.URL)
+	require.NoError(t, err)
+}
+
+func TestTransportPresentsClientCert(t *testing.T) {
+	cert, key, pool := writeClientCert(t)
+
+	ts := httptest.NewUnstartedServer(http.NotFoundHandler())
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	ca := writeServerCA(t, ts)
+
+	err := get(t, &Options{TLS: TLS{CACert: ca}}, ts.URL)
+	require.Error(t, err)
+
+	err = get(t, &Options{TLS: TLS{CACert: ca, ClientCert: cert, ClientKey: key}}, ts.URL)
+	require.NoError(t, err)
+}
+
+func TestTransportDoesNotModifyDefaultTransport(t *testing.T) {
+	reg, _ := name.NewRegistry("registry.internal")
+
+	o := &Options{TLS: TLS{InsecureSkipVerify: true}}
+	rt, err := o.Transport(reg)
+	require.NoError(t, err)
+	require.True(t, rt.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+
+	dt := remote.DefaultTransport.(*http.Transport)
+	require.True(t, dt.TLSClientConfig == nil || !dt.TLSClientConfig.InsecureSkipVerify)
+}
+
+func TestTransportReturnsErrorWhenClientKeyMissing(t *testing.T) {
+	reg, _ := name.NewRegistry("registry.internal")
+
+	o := &Options{TLS: TLS{ClientCert: "client.pem"}}
+	_, err := o.Transport(reg)
+	require.ErrorContains(t, err, "client key")
+}
+
+func TestTLSForPrefersOptionsOverConfig(t *testing.T) {
+	reg, _ := name.NewRegistry("docker.io")
+
+	c := &Config{Registries: map[string]RegistryConfig{
+		"docker.io": {TLS: TLS{CACert: "config.pem", ClientCert: "client.pem", ClientKey: "client-key.pem"}},
+	}}
+
+	o := &Options{TLS: TLS{CACert: "flag.pem"}, Config: c}
+	tc := o.TLSFor(reg)
+
+	require.Equal(t, "flag.pem", tc.CACert)
+	require.Equal(t, "client.pem", tc.ClientCert)
+}
+
+func TestLoadConfigReturnsEmptyConfigWhenMissing(t *testing.T) {
+	c, err := LoadConfig(filepath.Join(t.TempDir(), "config.json"))
+	require.NoError(t, err)
+	require.Empty(t, c.Registries)
+}
+
+func TestLoadConfigReadsRegistries(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(f, []byte(`{"registries": {"registry.internal": {"ca_cert": "/ca.pem", "insecure_skip_verify": true}}}`), 0600)
+
+	c, err := LoadConfig(f)
+	require.NoError(t, err)
+
+	reg, _ := name.NewRegistry("registry.internal")
+	require.Equal(t, "/ca.pem", c.Registry(reg).CACert)
+	require.True(t, c.Registry(reg).InsecureSkipVerify)
+}
+
+func TestParseReferenceUsesHTTPSByDefault(t *testing.T) {
+	o := &Options{}
+
+	ref, err := o.ParseReference("registry.internal:5000/models/mistral:latest")
+	require.NoError(t, err)
+	require.Equal(t, "https", ref.Context().Registry.Scheme())
+}
+
+func TestParseReferenceUsesHTTPWhenPlainHTTP(t *testing.T) {
+	o := &Options{PlainHTTP: true}
+
+	ref, err := o.ParseReference("registry.internal:5000/models/mistral:latest")
+	require.NoError(t, err)
+	require.Equal(t, "http", ref.Context().Registry.Scheme())
+}
+
+func TestParseReferenceUsesHTTPForInsecureRegistries(t *testing.T) {
+	o := &Options{Config: &Config{InsecureRegistries: []string{"registry.internal:5000"}}}
+
+	ref, err := o.ParseReference("registry.internal:5000/models/mistral:latest")
+	require.NoError(t, err)
+	require.Equal(t, "http", ref.Context().Registry.Scheme())
+
+	ref, err = o.ParseReference("registry.other:5000/models/mistral:latest")
+	require.NoError(t, err)
+	require.Equal(t, "https", ref.Context().Registry.Scheme())
+}
+
+func TestParseRegistryUsesHTTPWhenPlainHTTP(t *testing.T) {
+	o := &Options{PlainHTTP: true}
+
+	reg, err := o.ParseRegistry("registry.internal:5000")
+	require.NoError(t, err)
+	require.Equal(t, "http", reg.Scheme())
+}
+
+func TestMirrorsReturnsReferencesInOrder(t *testing.T) {
+	o := &Options{Config: &Config{
+		Mirrors:            map[string][]string{"docker.io": {"registry.internal/dockerhub/", "mirror.local:5000"}},
+		InsecureRegistries: []string{"mirror.local:5000"},
+	}}
+
+	ref, _ := name.ParseReference("mistral:latest")
+	refs, err := o.Mirrors(ref)
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+
+	require.Equal(t, "registry.internal/dockerhub/library/mistral:latest", refs[0].String())
+	require.Equal(t, "mirror.local:5000/library/mistral:latest", refs[1].String())
+	require.Equal(t, "http", refs[1].Context().Registry.Scheme())
+
+	ref, _ = name.ParseReference("docker.io/library/mistral@sha256:" + strings.Repeat("a", 64))
+	refs, err = o.Mirrors(ref)
+	require.NoError(t, err)
+	require.Equal(t, "registry.internal/dockerhub/library/mistral@sha256:"+strings.Repeat("a", 64), refs[0].String())
+}
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Retry configures how failed requests to a registry are retried
+type Retry struct {
+	// Attempts is the maximum number of consecutive attempts for a request
+	// including the first, attempts that read data reset the count
+	Attempts int
+	// Backoff is the delay before the first retry, the delay doubles for
+	// every subsequent retry
+	Backoff time.Duration
+}
+
+// DefaultRetry is used when the Retry of Options is not set
+var DefaultRetry = Retry{Attempts: 5, Backoff: time.Second}
+
+// retry returns the retry settings, using DefaultRetry when not set
+func (o *Options) retry() Retry {
+	if o.Retry.Attempts <= 0 {
+		return DefaultRetry
+	}
+
+	return o.Retry
+}
+
+// RetryBackoff returns the backoff used by go-containerregistry when
+// retrying requests for manifests and blob uploads
+func (o *Options) RetryBackoff() remote.Backoff {
+	r := o.retry()
+
+	return remote.Backoff{
+		Duration: r.Backoff,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    r.Attempts,
+	}
+}
+
+// delay returns the backoff before the given retry, starting at 1
+func (r Retry) delay(retry int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+	}
+
+	return d
+}
+
+// sleep waits for the delay or until the context is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// isTemporary returns true for errors that are likely to succeed when the
+// request is retried, such as dropped connections and timeouts
+func isTemporary(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isTemporaryStatus returns true for response codes that are retried
+func isTemporaryStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
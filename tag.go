@@ -0,0 +1,89 @@
+package kapsule
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/nicholasjackson/kapsule/reader"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/nicholasjackson/kapsule/writer"
+)
+
+// Tag points dst at the image src without uploading the image again. In a
+// remote registry dst must be in the same registry as src, blobs are mounted
+// when dst is in another repository. Images in a local OCI layout or Ollama
+// store are tagged in the same store, dst can omit the scheme and path i.e.
+// Tag(ctx, "oci:./output:mistral:v1", "mistral:latest").
+func Tag(ctx context.Context, src, dst string, opts ...Option) error {
+	o := newOptions(opts)
+
+	if p, r, ok := reader.ParseOCILayoutRef(src); ok {
+		d, err := storeRef(p, dst, reader.ParseOCILayoutRef)
+		if err != nil {
+			return err
+		}
+
+		return writer.NewPathWriter(o.logger, nil, p).Tag(ctx, r, d)
+	}
+
+	if p, r, ok := reader.ParseOllamaRef(src); ok {
+		d, err := storeRef(p, dst, reader.ParseOllamaRef)
+		if err != nil {
+			return err
+		}
+
+		return writer.NewOllamaWriter(o.logger, nil, p).Tag(ctx, r, d)
+	}
+
+	if isLocalRef(dst) {
+		return types.Errorf(types.ErrorKindParse, "unable to tag %s as %s, use pull to write a remote image to a local store", src, dst)
+	}
+
+	return writer.NewOCIRegistry(o.logger, nil, o.registry).Tag(ctx, src, dst)
+}
+
+// Remove deletes the image at ref. Images in a remote registry are deleted
+// using the OCI delete API, deleting a digest removes the manifest and every
+// tag that points at it. Images in a local OCI layout or Ollama store are
+// removed from the store along with the blobs that are not used by any other
+// image.
+func Remove(ctx context.Context, ref string, opts ...Option) error {
+	o := newOptions(opts)
+
+	if p, r, ok := reader.ParseOCILayoutRef(ref); ok {
+		return writer.NewPathWriter(o.logger, nil, p).Remove(ctx, r)
+	}
+
+	if p, r, ok := reader.ParseOllamaRef(ref); ok {
+		return writer.NewOllamaWriter(o.logger, nil, p).Remove(ctx, r)
+	}
+
+	return writer.NewOCIRegistry(o.logger, nil, o.registry).Remove(ctx, ref)
+}
+
+// storeRef returns the reference of dst in the local store at path, dst
+// is either a reference in the same store or a reference without a scheme
+func storeRef(path, dst string, parse func(string) (string, string, bool)) (string, error) {
+	p, r, ok := parse(dst)
+	if !ok {
+		if isLocalRef(dst) {
+			return "", types.Errorf(types.ErrorKindParse, "unable to tag %s, images can only be tagged in the same store", dst)
+		}
+
+		return dst, nil
+	}
+
+	if filepath.Clean(p) != filepath.Clean(path) {
+		return "", types.Errorf(types.ErrorKindParse, "unable to tag %s, images can only be tagged in the same store", dst)
+	}
+
+	return r, nil
+}
+
+// isLocalRef returns true when ref uses the scheme of a local store
+func isLocalRef(ref string) bool {
+	_, _, layout := reader.ParseOCILayoutRef(ref)
+	_, _, ollama := reader.ParseOllamaRef(ref)
+
+	return layout || ollama
+}
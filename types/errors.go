@@ -0,0 +1,54 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies an error so that callers can decide how to handle it
+type ErrorKind string
+
+const (
+	ErrorKindUnknown  ErrorKind = "unknown"
+	ErrorKindAuth     ErrorKind = "auth"
+	ErrorKindNotFound ErrorKind = "not_found"
+	ErrorKindCrypto   ErrorKind = "crypto"
+	ErrorKindParse    ErrorKind = "parse"
+	ErrorKindIO       ErrorKind = "io"
+	ErrorKindCorrupt  ErrorKind = "corrupt"
+)
+
+// Error is an error that has been classified with an ErrorKind
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+// NewError wraps err with the given kind
+func NewError(kind ErrorKind, err error) error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// Errorf formats an error and wraps it with the given kind
+func Errorf(kind ErrorKind, format string, a ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKindOf returns the kind of the first Error in the chain of err,
+// if err has not been classified ErrorKindUnknown is returned
+func ErrorKindOf(err error) ErrorKind {
+	var ke *Error
+	if errors.As(err, &ke) {
+		return ke.Kind
+	}
+
+	return ErrorKindUnknown
+}
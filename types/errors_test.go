@@ -0,0 +1,29 @@
+package types
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorKindOfReturnsKindFromWrappedError(t *testing.T) {
+	err := fmt.Errorf("unable to write: %w", NewError(ErrorKindIO, os.ErrPermission))
+
+	require.Equal(t, ErrorKindIO, ErrorKindOf(err))
+	require.ErrorIs(t, err, os.ErrPermission)
+}
+
+func TestErrorKindOfReturnsUnknownForPlainError(t *testing.T) {
+	err := fmt.Errorf("boom")
+
+	require.Equal(t, ErrorKindUnknown, ErrorKindOf(err))
+}
+
+func TestErrorfFormatsMessage(t *testing.T) {
+	err := Errorf(ErrorKindParse, "invalid reference: %s", "foo")
+
+	require.Equal(t, "invalid reference: foo", err.Error())
+	require.Equal(t, ErrorKindParse, ErrorKindOf(err))
+}
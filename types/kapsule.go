@@ -1,6 +1,80 @@
 package types
 
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
 const KAPSULE_MEDIA_TYPE_MODEL = "application/vnd.kapsule.image.model+gzip"
 const KAPSULE_MEDIA_TYPE_LICENCE = "application/vnd.kapsule.image.licence+gzip"
 const KAPSULE_MEDIA_TYPE_TEMPLATE = "application/vnd.kapsule.image.template+gzip"
 const KAPSULE_MEDIA_TYPE_PARAMETERS = "application/vnd.kapsule.image.params+gzip"
+const KAPSULE_MEDIA_TYPE_SYSTEM = "application/vnd.kapsule.image.system+gzip"
+const KAPSULE_MEDIA_TYPE_ADAPTER = "application/vnd.kapsule.image.adapter+gzip"
+
+// LayerType returns the kind of Kapsule layer from the media type i.e.
+// application/vnd.kapsule.image.template+gzip+enc returns template, media
+// types that are not Kapsule layers return an empty string
+func LayerType(mt string) string {
+	mt = strings.TrimSuffix(mt, encryptedSuffix)
+	mt = UncompressedMediaType(mt)
+
+	t, ok := strings.CutPrefix(mt, "application/vnd.kapsule.image.")
+	if !ok {
+		return ""
+	}
+
+	return t
+}
+
+// gzipSuffix is the suffix of the media type of compressed Kapsule layers
+const gzipSuffix = "+gzip"
+
+// encryptedSuffix is the suffix added to the media type of encrypted layers
+const encryptedSuffix = "+enc"
+
+// IsEncryptedMediaType returns true if the media type is of an encrypted layer
+func IsEncryptedMediaType(mt string) bool {
+	return strings.HasSuffix(mt, encryptedSuffix)
+}
+
+// UncompressedMediaType returns the media type of a layer once its contents
+// have been decompressed, media types of uncompressed layers are returned
+// unchanged
+func UncompressedMediaType(mt string) string {
+	if mt == "application/vnd.docker.image.rootfs.diff.tar.gzip" {
+		return "application/vnd.docker.image.rootfs.diff.tar"
+	}
+
+	return strings.TrimSuffix(mt, gzipSuffix)
+}
+
+// CompressedMediaType returns the Kapsule media type of a layer that has been
+// written uncompressed i.e. application/vnd.kapsule.image.model returns
+// KAPSULE_MEDIA_TYPE_MODEL, all other media types are returned unchanged
+func CompressedMediaType(mt string) string {
+	if strings.HasPrefix(mt, "application/vnd.kapsule.") && !strings.Contains(mt, "+") {
+		return mt + gzipSuffix
+	}
+
+	return mt
+}
+
+// Decompress returns a reader for the uncompressed contents of a layer, the
+// contents of layers that were written uncompressed are returned unchanged
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+
+	return br, nil
+}
@@ -2,7 +2,6 @@ package types
 
 import (
 	"bytes"
-	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -18,6 +17,50 @@ const OLLAMA_MEDIA_TYPE_MODEL = "application/vnd.ollama.image.model"
 const OLLAMA_MEDIA_TYPE_LICENCE = "application/vnd.ollama.image.licence"
 const OLLAMA_MEDIA_TYPE_TEMPLATE = "application/vnd.ollama.image.template"
 const OLLAMA_MEDIA_TYPE_PARAMETERS = "application/vnd.ollama.image.params"
+const OLLAMA_MEDIA_TYPE_SYSTEM = "application/vnd.ollama.image.system"
+const OLLAMA_MEDIA_TYPE_ADAPTER = "application/vnd.ollama.image.adapter"
+
+// ollama writes licences using the US spelling
+const OLLAMA_MEDIA_TYPE_LICENSE = "application/vnd.ollama.image.license"
+
+var kapsuleToOllamaMediaTypes = map[string]string{
+	KAPSULE_MEDIA_TYPE_MODEL:      OLLAMA_MEDIA_TYPE_MODEL,
+	KAPSULE_MEDIA_TYPE_LICENCE:    OLLAMA_MEDIA_TYPE_LICENCE,
+	KAPSULE_MEDIA_TYPE_TEMPLATE:   OLLAMA_MEDIA_TYPE_TEMPLATE,
+	KAPSULE_MEDIA_TYPE_PARAMETERS: OLLAMA_MEDIA_TYPE_PARAMETERS,
+	KAPSULE_MEDIA_TYPE_SYSTEM:     OLLAMA_MEDIA_TYPE_SYSTEM,
+	KAPSULE_MEDIA_TYPE_ADAPTER:    OLLAMA_MEDIA_TYPE_ADAPTER,
+}
+
+var ollamaToKapsuleMediaTypes = map[string]string{
+	OLLAMA_MEDIA_TYPE_MODEL:      KAPSULE_MEDIA_TYPE_MODEL,
+	OLLAMA_MEDIA_TYPE_LICENCE:    KAPSULE_MEDIA_TYPE_LICENCE,
+	OLLAMA_MEDIA_TYPE_LICENSE:    KAPSULE_MEDIA_TYPE_LICENCE,
+	OLLAMA_MEDIA_TYPE_TEMPLATE:   KAPSULE_MEDIA_TYPE_TEMPLATE,
+	OLLAMA_MEDIA_TYPE_PARAMETERS: KAPSULE_MEDIA_TYPE_PARAMETERS,
+	OLLAMA_MEDIA_TYPE_SYSTEM:     KAPSULE_MEDIA_TYPE_SYSTEM,
+	OLLAMA_MEDIA_TYPE_ADAPTER:    KAPSULE_MEDIA_TYPE_ADAPTER,
+}
+
+// KapsuleToOllamaMediaType returns the Ollama media type for a Kapsule layer,
+// media types that have no Ollama equivalent are returned unchanged
+func KapsuleToOllamaMediaType(mt string) string {
+	if o, ok := kapsuleToOllamaMediaTypes[mt]; ok {
+		return o
+	}
+
+	return mt
+}
+
+// OllamaToKapsuleMediaType returns the Kapsule media type for an Ollama layer,
+// media types that have no Kapsule equivalent are returned unchanged
+func OllamaToKapsuleMediaType(mt string) string {
+	if k, ok := ollamaToKapsuleMediaTypes[mt]; ok {
+		return k
+	}
+
+	return mt
+}
 
 // OllamaConfig is the docker manifest config for the image
 type OllamaConfig struct {
@@ -102,8 +145,8 @@ var ollamaParametersDict = map[string]string{
 // a Kapsule parameter collection into the json format that is expected by ollama
 // returns a writer that can be added to a new image later
 func ConvertKapsuleParamsToOllamaParams(r io.ReadCloser) io.ReadCloser {
-	// the layer is compressed, get a gzip reader to decompress as we write it
-	gzrc, err := gzip.NewReader(r)
+	// layers written to disk with unzip are not compressed
+	gzrc, err := Decompress(r)
 	if err != nil {
 		return nil
 	}
@@ -145,6 +188,54 @@ func ConvertKapsuleParamsToOllamaParams(r io.ReadCloser) io.ReadCloser {
 	return io.NopCloser(bytes.NewBuffer(d))
 }
 
+// ConvertOllamaParamsToKapsuleParams converts an uncompressed Ollama params
+// blob into the json format used by the Kapsule parameters layer, returns nil
+// if the params can not be converted
+func ConvertOllamaParamsToKapsuleParams(r io.ReadCloser) io.ReadCloser {
+	defer r.Close()
+
+	params := map[string]interface{}{}
+	err := json.NewDecoder(r).Decode(&params)
+	if err != nil {
+		return nil
+	}
+
+	ret := map[string][]string{}
+
+	for k, v := range params {
+		switch t := v.(type) {
+		case []interface{}:
+			for _, i := range t {
+				ret[k] = append(ret[k], convertToString(i))
+			}
+		default:
+			ret[k] = []string{convertToString(t)}
+		}
+	}
+
+	// serialize to json
+	d, err := json.Marshal(&ret)
+	if err != nil {
+		return nil
+	}
+
+	return io.NopCloser(bytes.NewBuffer(d))
+}
+
+// convertToString converts a json value to its string representation
+func convertToString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
 func convertToInt(value []string) (int, error) {
 	if len(value) == 0 {
 		return 0, fmt.Errorf("invalid value")
@@ -92,3 +92,24 @@ func TestConvertsParametersCorrectly(t *testing.T) {
 	require.Equal(t, 0.1, oParams["mirostat_eta"])
 	require.Equal(t, []interface{}{"[a]", "[b]"}, oParams["stop"])
 }
+
+func TestConvertsOllamaParametersCorrectly(t *testing.T) {
+	in := `{"mirostat":2,"mirostat_eta":0.1,"stop":["[a]","[b]"],"penalize_newline":true}`
+
+	out := ConvertOllamaParamsToKapsuleParams(io.NopCloser(bytes.NewReader([]byte(in))))
+	require.NotNil(t, out)
+
+	kp := map[string][]string{}
+	err := json.NewDecoder(out).Decode(&kp)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"2"}, kp["mirostat"])
+	require.Equal(t, []string{"0.1"}, kp["mirostat_eta"])
+	require.Equal(t, []string{"[a]", "[b]"}, kp["stop"])
+	require.Equal(t, []string{"true"}, kp["penalize_newline"])
+}
+
+func TestConvertsOllamaParametersReturnsNilForInvalidJSON(t *testing.T) {
+	out := ConvertOllamaParamsToKapsuleParams(io.NopCloser(bytes.NewReader([]byte("{"))))
+	require.Nil(t, out)
+}
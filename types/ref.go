@@ -3,6 +3,9 @@ package types
 import (
 	"fmt"
 	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 func CanonicalRef(imageRef string) string {
@@ -34,3 +37,48 @@ func CanonicalRef(imageRef string) string {
 
 	return fmt.Sprintf("%s/%s/%s:%s", registry, workspace, image, tag)
 }
+
+// FindManifest returns the descriptor in an OCI index matching ref, exact matches of the digest
+// or the ref name annotation take precedence over matches of the tag
+func FindManifest(manifests []v1.Descriptor, ref string) (*v1.Descriptor, error) {
+	if ref == "" {
+		if len(manifests) == 1 {
+			return &manifests[0], nil
+		}
+
+		return nil, errNoUniqueImage(len(manifests))
+	}
+
+	for i, m := range manifests {
+		name := m.Annotations[ocispec.AnnotationRefName]
+
+		if m.Digest.String() == ref || name == ref || name == CanonicalRef(ref) {
+			return &manifests[i], nil
+		}
+	}
+
+	// allow the image to be selected using only the tag as long as the
+	// tag is not used by more than one image
+	var found []*v1.Descriptor
+	if !strings.ContainsAny(ref, ":/") {
+		for i, m := range manifests {
+			if strings.HasSuffix(m.Annotations[ocispec.AnnotationRefName], ":"+ref) {
+				found = append(found, &manifests[i])
+			}
+		}
+	}
+
+	if len(found) != 1 {
+		return nil, errNoUniqueImage(len(found))
+	}
+
+	return found[0], nil
+}
+
+func errNoUniqueImage(count int) error {
+	if count == 0 {
+		return fmt.Errorf("no matching image")
+	}
+
+	return fmt.Errorf("%d images match, specify the full name or digest", count)
+}
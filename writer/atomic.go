@@ -0,0 +1,78 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempPrefix is the prefix of the temporary files created while writing,
+// files with this prefix that remain after a write were left by an
+// interrupted write and can be safely removed
+const tempPrefix = ".kapsule-tmp-"
+
+// isTempFile returns true if the file was created by createTemp
+func isTempFile(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), tempPrefix)
+}
+
+// createTemp creates a temporary file in dir, the file must be in the same
+// folder as the final file so that it can be renamed into place atomically
+func createTemp(dir string) (*os.File, error) {
+	return os.CreateTemp(dir, tempPrefix+"*")
+}
+
+// publishFile syncs the temporary file to disk, closes it and renames it to
+// name. The folder is synced so that the rename survives a crash, the
+// temporary file is removed when it can not be published.
+func publishFile(f *os.File, name string) error {
+	err := f.Sync()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), name)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return syncDir(filepath.Dir(name))
+}
+
+// writeFileAtomic writes data to name, readers either see the previous
+// contents of the file or the new contents but never a partial write
+func writeFileAtomic(name string, data []byte) error {
+	f, err := createTemp(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	return publishFile(f, name)
+}
+
+// syncDir flushes the entries of the folder to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,388 @@
+package writer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/nicholasjackson/kapsule/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Problem is an inconsistency found when checking a store
+type Problem struct {
+	// Path is the file or image that is inconsistent
+	Path string `json:"path"`
+	// Message describes the problem
+	Message string `json:"message"`
+	// Repaired is true when the problem has been fixed
+	Repaired bool `json:"repaired"`
+}
+
+var (
+	errBlobMissing = errors.New("blob is missing")
+	errBlobCorrupt = errors.New("blob does not match its digest")
+)
+
+// blobVerifier checks the digest of blobs, each blob is only read once
+type blobVerifier struct {
+	ctx     context.Context
+	results map[string]error
+}
+
+func newBlobVerifier(ctx context.Context) *blobVerifier {
+	return &blobVerifier{ctx: ctx, results: map[string]error{}}
+}
+
+// verify returns errBlobMissing or errBlobCorrupt if the file at path
+// does not contain data matching the digest d
+func (b *blobVerifier) verify(path string, d v1.Hash) error {
+	if err, ok := b.results[path]; ok {
+		return err
+	}
+
+	err := verifyBlob(b.ctx, path, d)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	b.results[path] = err
+	return err
+}
+
+// corrupt returns the paths of the blobs that did not match their digest
+func (b *blobVerifier) corrupt() []string {
+	paths := []string{}
+	for p, err := range b.results {
+		if errors.Is(err, errBlobCorrupt) {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
+}
+
+func verifyBlob(ctx context.Context, path string, d v1.Hash) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return errBlobMissing
+	}
+
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to open blob %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, newContextReader(ctx, f))
+	if err != nil {
+		return err
+	}
+
+	if d.Algorithm != "sha256" || d.Hex != hex.EncodeToString(h.Sum(nil)) {
+		return errBlobCorrupt
+	}
+
+	return nil
+}
+
+// checkTempFiles reports the files in the folder at root that were left by
+// interrupted writes, isTemp decides if a file was left by an interrupted
+// write. When repair is true the files are removed.
+func checkTempFiles(root string, repair bool, isTemp func(path string) bool) ([]Problem, error) {
+	problems := []Problem{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !isTemp(path) {
+			return nil
+		}
+
+		pr := Problem{Path: path, Message: "incomplete file left by an interrupted write"}
+		if repair {
+			pr.Repaired = os.Remove(path) == nil
+		}
+
+		problems = append(problems, pr)
+		return nil
+	})
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, types.Errorf(types.ErrorKindIO, "unable to read %s: %w", root, err)
+	}
+
+	return problems, nil
+}
+
+// Check verifies that every image in the OCI layout references blobs that
+// exist and match their digest. When repair is true, files left by
+// interrupted writes are removed, images with missing or corrupt blobs are
+// removed from the index and blobs that are no longer referenced are deleted.
+func (pw *PathWriter) Check(ctx context.Context, repair bool) ([]Problem, error) {
+	p, err := layout.FromPath(pw.filePath)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindNotFound, "unable to open OCI layout at %s: %w", pw.filePath, err)
+	}
+
+	problems, err := checkTempFiles(pw.filePath, repair, func(path string) bool {
+		if isTempFile(path) {
+			return true
+		}
+
+		// anything in the blobs folder that is not named after its digest
+		// was left by a write that did not complete
+		alg := filepath.Base(filepath.Dir(path))
+		_, err := v1.NewHash(alg + ":" + filepath.Base(path))
+		return filepath.Dir(filepath.Dir(path)) == filepath.Join(pw.filePath, "blobs") && err != nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindParse, "unable to read index for OCI layout at %s: %w", pw.filePath, err)
+	}
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindParse, "unable to read index for OCI layout at %s: %w", pw.filePath, err)
+	}
+
+	bv := newBlobVerifier(ctx)
+	broken := map[v1.Hash]bool{}
+
+	for _, desc := range im.Manifests {
+		err := checkLayoutImage(p, bv, desc)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+
+		if err != nil {
+			name := desc.Annotations[ocispec.AnnotationRefName]
+			if name == "" {
+				name = desc.Digest.String()
+			}
+
+			problems = append(problems, Problem{Path: name, Message: err.Error(), Repaired: repair})
+			broken[desc.Digest] = true
+		}
+	}
+
+	if repair && len(broken) > 0 {
+		_, err = updateIndex(p, func(m v1.Descriptor) bool { return !broken[m.Digest] })
+		if err != nil {
+			return nil, types.Errorf(types.ErrorKindIO, "unable to update index: %w", err)
+		}
+	}
+
+	for _, c := range bv.corrupt() {
+		pr := Problem{Path: c, Message: errBlobCorrupt.Error()}
+		if repair {
+			pr.Repaired = os.Remove(c) == nil
+		}
+
+		problems = append(problems, pr)
+	}
+
+	// the blobs of broken images can not be determined until the images
+	// have been removed from the index
+	if len(broken) > 0 && !repair {
+		return problems, nil
+	}
+
+	unused, err := unreferencedBlobs(p)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range unused {
+		pr := Problem{Path: blobPath(p, h), Message: "blob is not referenced by any image"}
+		if repair {
+			pr.Repaired = p.RemoveBlob(h) == nil
+		}
+
+		problems = append(problems, pr)
+	}
+
+	return problems, nil
+}
+
+// checkLayoutImage verifies the manifest, config and layers of an image
+func checkLayoutImage(p layout.Path, bv *blobVerifier, desc v1.Descriptor) error {
+	err := bv.verify(blobPath(p, desc.Digest), desc.Digest)
+	if err != nil {
+		return fmt.Errorf("manifest %s: %w", desc.Digest, err)
+	}
+
+	if !desc.MediaType.IsImage() {
+		return nil
+	}
+
+	f, err := os.Open(blobPath(p, desc.Digest))
+	if err != nil {
+		return fmt.Errorf("manifest %s: %w", desc.Digest, err)
+	}
+	defer f.Close()
+
+	mf, err := v1.ParseManifest(f)
+	if err != nil {
+		return fmt.Errorf("manifest %s: unable to parse: %w", desc.Digest, err)
+	}
+
+	err = bv.verify(blobPath(p, mf.Config.Digest), mf.Config.Digest)
+	if err != nil {
+		return fmt.Errorf("config %s: %w", mf.Config.Digest, err)
+	}
+
+	for _, l := range mf.Layers {
+		err = bv.verify(blobPath(p, l.Digest), l.Digest)
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", l.Digest, err)
+		}
+	}
+
+	return nil
+}
+
+// Check verifies that every manifest in the Ollama store references blobs
+// that exist and match their digest. When repair is true, files left by
+// interrupted writes are removed, manifests with missing or corrupt blobs are
+// deleted and blobs that are no longer referenced are deleted. Partial
+// downloads left by ollama pull are never removed.
+func (ol *OllamaWriter) Check(ctx context.Context, repair bool) ([]Problem, error) {
+	manifestsFolder := filepath.Join(ol.filePath, "manifests")
+	blobsFolder := filepath.Join(ol.filePath, "blobs")
+
+	if _, err := os.Stat(manifestsFolder); err != nil {
+		return nil, types.Errorf(types.ErrorKindNotFound, "unable to open Ollama store at %s: %w", ol.filePath, err)
+	}
+
+	// earlier versions used a single temp file for all blobs
+	isTemp := func(path string) bool {
+		return isTempFile(path) || filepath.Base(path) == "sha256-temp"
+	}
+
+	problems, err := checkTempFiles(ol.filePath, repair, isTemp)
+	if err != nil {
+		return nil, err
+	}
+
+	bv := newBlobVerifier(ctx)
+	referenced := map[string]bool{}
+
+	err = filepath.WalkDir(manifestsFolder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isTempFile(path) {
+			return err
+		}
+
+		name, _ := filepath.Rel(manifestsFolder, path)
+
+		err = checkOllamaManifest(path, blobsFolder, bv, referenced)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		if err != nil {
+			pr := Problem{Path: name, Message: err.Error()}
+			if repair {
+				pr.Repaired = os.Remove(path) == nil
+			}
+
+			problems = append(problems, pr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range bv.corrupt() {
+		pr := Problem{Path: c, Message: errBlobCorrupt.Error()}
+		if repair {
+			pr.Repaired = os.Remove(c) == nil
+		}
+
+		problems = append(problems, pr)
+	}
+
+	blobs, err := os.ReadDir(blobsFolder)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, types.Errorf(types.ErrorKindIO, "unable to read blobs: %w", err)
+	}
+
+	for _, b := range blobs {
+		// temporary and corrupt files have already been reported
+		path := filepath.Join(blobsFolder, b.Name())
+		if referenced[path] || b.IsDir() || isTemp(path) || errors.Is(bv.results[path], errBlobCorrupt) {
+			continue
+		}
+
+		// partial downloads are kept so that ollama pull can resume them
+		if isOllamaPartial(path) {
+			ol.logger.Info("Skipping partial download left by Ollama", "path", path)
+			continue
+		}
+
+		pr := Problem{Path: path, Message: "blob is not referenced by any manifest"}
+		if repair {
+			pr.Repaired = os.Remove(path) == nil
+		}
+
+		problems = append(problems, pr)
+	}
+
+	return problems, nil
+}
+
+// isOllamaPartial returns true for the files Ollama writes while a blob is
+// being downloaded i.e. sha256-<hex>-partial and sha256-<hex>-partial-0
+func isOllamaPartial(path string) bool {
+	return strings.Contains(filepath.Base(path), "-partial")
+}
+
+// checkOllamaManifest verifies the config and layers referenced by the
+// manifest, the blobs of valid manifests are added to referenced
+func checkOllamaManifest(path, blobsFolder string, bv *blobVerifier, referenced map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mf, err := v1.ParseManifest(f)
+	if err != nil {
+		return fmt.Errorf("unable to parse manifest: %w", err)
+	}
+
+	blobs := []string{}
+	for _, d := range append([]v1.Descriptor{mf.Config}, mf.Layers...) {
+		b := blobName(blobsFolder, d.Digest)
+
+		err = bv.verify(b, d.Digest)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", strings.TrimPrefix(string(d.MediaType), "application/"), d.Digest, err)
+		}
+
+		blobs = append(blobs, b)
+	}
+
+	for _, b := range blobs {
+		referenced[b] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package writer
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathCheckReturnsNoProblemsForConsistentLayout(t *testing.T) {
+	pw, _, _, i := setupPathFileKp(t, "test")
+
+	err := pw.Write(context.Background(), i, "test:v1", false, false)
+	require.NoError(t, err)
+
+	problems, err := pw.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
+
+func TestPathCheckRepairsBrokenImages(t *testing.T) {
+	pw, _, o, _ := setupPathFileKp(t, "test")
+
+	good, err := random.Image(1024, 1)
+	require.NoError(t, err)
+
+	bad, err := random.Image(1024, 1)
+	require.NoError(t, err)
+
+	require.NoError(t, pw.Write(context.Background(), good, "test:good", false, false))
+	require.NoError(t, pw.Write(context.Background(), bad, "test:bad", false, false))
+
+	// corrupt the layer of one image and leave a partial write behind
+	bl, _ := bad.Layers()
+	bd, _ := bl[0].Digest()
+	os.WriteFile(path.Join(o, "blobs", "sha256", bd.Hex), []byte("corrupt"), os.ModePerm)
+	os.WriteFile(path.Join(o, "blobs", "sha256", tempPrefix+"123"), []byte("partial"), os.ModePerm)
+
+	problems, err := pw.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Len(t, problems, 3)
+
+	for _, p := range problems {
+		require.False(t, p.Repaired)
+	}
+
+	problems, err = pw.Check(context.Background(), true)
+	require.NoError(t, err)
+	require.NotEmpty(t, problems)
+
+	for _, p := range problems {
+		require.True(t, p.Repaired)
+	}
+
+	problems, err = pw.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	// the good image is still in the index
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, _ := p.ImageIndex()
+	im, _ := idx.IndexManifest()
+	require.Len(t, im.Manifests, 1)
+
+	gd, _ := good.Digest()
+	require.Equal(t, gd, im.Manifests[0].Digest)
+}
+
+func TestPathCancelledWriteDoesNotPublishImage(t *testing.T) {
+	pw, _, o, i := setupPathFileKp(t, "test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := pw.Write(ctx, i, "test:v1", false, false)
+	require.ErrorIs(t, err, context.Canceled)
+
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, _ := p.ImageIndex()
+	im, _ := idx.IndexManifest()
+	require.Empty(t, im.Manifests)
+
+	problems, err := pw.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
+
+func TestOllamaCheckRepairsBrokenManifests(t *testing.T) {
+	w, _, o, i := setupOllama(t)
+
+	err := w.Write(context.Background(), i, "test:latest", false, true)
+	require.NoError(t, err)
+
+	problems, err := w.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	// remove a blob and leave a partial write behind
+	blobs, _ := os.ReadDir(path.Join(o, "blobs"))
+	os.Remove(path.Join(o, "blobs", blobs[0].Name()))
+	os.WriteFile(path.Join(o, "blobs", tempPrefix+"123"), []byte("partial"), os.ModePerm)
+
+	problems, err = w.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.NotEmpty(t, problems)
+	require.FileExists(t, path.Join(o, "manifests", "kapsule.io", "library", "test", "latest"))
+
+	problems, err = w.Check(context.Background(), true)
+	require.NoError(t, err)
+
+	for _, p := range problems {
+		require.True(t, p.Repaired)
+	}
+
+	require.NoFileExists(t, path.Join(o, "manifests", "kapsule.io", "library", "test", "latest"))
+
+	problems, err = w.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
+
+func TestOllamaCheckKeepsPartialDownloads(t *testing.T) {
+	w, _, o, i := setupOllama(t)
+
+	err := w.Write(context.Background(), i, "test:latest", false, true)
+	require.NoError(t, err)
+
+	partial := path.Join(o, "blobs", "sha256-0000000000000000000000000000000000000000000000000000000000000000-partial")
+	os.WriteFile(partial, []byte("partial"), os.ModePerm)
+	os.WriteFile(partial+"-0", []byte("{}"), os.ModePerm)
+
+	problems, err := w.Check(context.Background(), true)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	require.FileExists(t, partial)
+	require.FileExists(t, partial+"-0")
+}
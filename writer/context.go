@@ -0,0 +1,81 @@
+package writer
+
+import (
+	"context"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// contextImage wraps an image so that the readers returned by its layers
+// stop returning data once the context has been cancelled
+type contextImage struct {
+	v1.Image
+	ctx context.Context
+}
+
+func withContext(ctx context.Context, image v1.Image) v1.Image {
+	return &contextImage{Image: image, ctx: ctx}
+}
+
+func (i *contextImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := make([]v1.Layer, len(layers))
+	for n, l := range layers {
+		wrapped[n] = &contextLayer{Layer: l, ctx: i.ctx}
+	}
+
+	return wrapped, nil
+}
+
+// contextLayer wraps a layer so that the readers it returns honour
+// the cancellation of the context
+type contextLayer struct {
+	v1.Layer
+	ctx context.Context
+}
+
+func (l *contextLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	return newContextReader(l.ctx, rc), nil
+}
+
+func (l *contextLayer) Uncompressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+
+	return newContextReader(l.ctx, rc), nil
+}
+
+// contextReader is an io.ReadCloser that returns the context error
+// once the context has been cancelled
+type contextReader struct {
+	ctx context.Context
+	rc  io.ReadCloser
+}
+
+func newContextReader(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	return &contextReader{ctx: ctx, rc: rc}
+}
+
+// Read implements the io.Reader interface.
+func (r *contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.rc.Read(b)
+}
+
+// Close implements the io.Closer interface.
+func (r *contextReader) Close() error { return r.rc.Close() }
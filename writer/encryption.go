@@ -1,23 +1,50 @@
 package writer
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"strings"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/stream"
 	"github.com/nicholasjackson/kapsule/crypto"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/types"
 )
 
 const (
-	ENCRYPTION_KEY_ANNOTATION = "org.opencontainers.image.enc.keys.jwe"
+	ENCRYPTION_KEY_ANNOTATION = crypto.JWEAnnotation
 	ENCRYPTION_KEY_OPTIONS    = "org.opencontainers.image.enc.pubopts"
 )
 
-// wrapLayersWithEncryptedLayer wraps each layer in the image with an encrypted layer
-// and returns the new image
-func wrapLayersWithEncryptedLayer(i v1.Image, publicKey []byte) (v1.Image, error) {
+// baseImage returns an image without layers that has the config of the given
+// image so that the creation time and labels are kept when the layers are
+// replaced. The config of images containing streamed layers is only known
+// once the layers have been read, until then an empty image is returned.
+func baseImage(image v1.Image) (v1.Image, error) {
+	cf, err := image.ConfigFile()
+	if errors.Is(err, stream.ErrNotComputed) {
+		return empty.Image, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cf = cf.DeepCopy()
+	cf.RootFS.DiffIDs = nil
+	cf.History = nil
+
+	return mutate.ConfigFile(empty.Image, cf)
+}
+
+// wrapLayersWithEncryptedLayer wraps each selected layer in the image with an
+// encrypted layer that can be decrypted by any of the public keys and returns
+// the new image, layers that are not selected are added unchanged. When no
+// layers are selected every layer is encrypted.
+func wrapLayersWithEncryptedLayer(i v1.Image, publicKeys [][]byte, selected []string) (v1.Image, error) {
 	base := empty.Image
 
 	layers, err := i.Layers()
@@ -26,12 +53,19 @@ func wrapLayersWithEncryptedLayer(i v1.Image, publicKey []byte) (v1.Image, error
 	}
 
 	for _, l := range layers {
-		el, err := crypto.NewEncryptedLayer(l, publicKey)
+		mt, err := l.MediaType()
 		if err != nil {
-			return nil, fmt.Errorf("unable to create encrypted layer: %s", err)
+			return nil, fmt.Errorf("unable to get media type from layer: %s", err)
 		}
 
-		base, err = mutate.AppendLayers(base, el)
+		if encryptLayer(string(mt), selected) {
+			l, err = crypto.NewEncryptedLayer(l, publicKeys...)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create encrypted layer: %s", err)
+			}
+		}
+
+		base, err = mutate.AppendLayers(base, l)
 		if err != nil {
 			return nil, fmt.Errorf("unable to append encrypted layer: %s", err)
 		}
@@ -40,11 +74,32 @@ func wrapLayersWithEncryptedLayer(i v1.Image, publicKey []byte) (v1.Image, error
 	return base, nil
 }
 
+// encryptLayer returns true when the layer with the media type is selected
+// for encryption, layers are selected by Kapsule layer type i.e. model or by
+// media type. When no layers are selected every layer is encrypted.
+func encryptLayer(mt string, selected []string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+
+	for _, s := range selected {
+		if s == mt || s == types.LayerType(mt) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // after writing an encrypted layer the encryption details used to encrypt the layer
 // are stored in the annotations, this function reads the annotations and updates the
-// image mnaifest with the encryption details
-func appendEncyptedLayerAnnotations(image v1.Image) (v1.Image, error) {
-	new := empty.Image
+// image mnaifest with the encryption details. The config of the source image that
+// was encrypted is kept.
+func appendEncyptedLayerAnnotations(image, source v1.Image) (v1.Image, error) {
+	new, err := baseImage(source)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %s", err)
+	}
 
 	// get the layers from the image
 	layers, err := image.Layers()
@@ -52,12 +107,16 @@ func appendEncyptedLayerAnnotations(image v1.Image) (v1.Image, error) {
 		return nil, fmt.Errorf("unable to get layers from image: %s", err)
 	}
 
-	// iterate over the layers and update the annotations
+	// iterate over the layers and update the annotations, layers that were
+	// not selected for encryption have no annotations
 	for _, l := range layers {
-		// get the annotations
-		ann, err := l.(*crypto.EncryptedLayer).Annotations()
-		if err != nil {
-			return nil, fmt.Errorf("unable to get annotations from encrypted layer: %s", err)
+		var ann map[string]string
+
+		if el, ok := l.(*crypto.EncryptedLayer); ok {
+			ann, err = el.Annotations()
+			if err != nil {
+				return nil, fmt.Errorf("unable to get annotations from encrypted layer: %s", err)
+			}
 		}
 
 		new, err = mutate.Append(new, mutate.Addendum{Layer: l, Annotations: ann})
@@ -69,9 +128,46 @@ func appendEncyptedLayerAnnotations(image v1.Image) (v1.Image, error) {
 	return new, nil
 }
 
-// wrapLayersWithDecryptedLayer wraps each layer in the image with a decrypted layer
-func wrapLayersWithDecryptedLayer(image v1.Image, privateKey []byte) (v1.Image, error) {
-	new := empty.Image
+// DecryptImage returns the image with the encrypted layers wrapped so that
+// they are decrypted with the private keys of the provider as they are read
+func DecryptImage(ctx context.Context, kp keyproviders.Provider, image v1.Image) (v1.Image, error) {
+	pk, err := keyproviders.PrivateKeys(ctx, kp)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindCrypto, "unable to get private keys: %w", err)
+	}
+
+	di, err := wrapLayersWithDecryptedLayer(image, pk)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindCrypto, "unable to decrypt image: %w", err)
+	}
+
+	return di, nil
+}
+
+// IsEncrypted returns true if any of the layers of the image are encrypted
+func IsEncrypted(image v1.Image) (bool, error) {
+	mf, err := image.Manifest()
+	if err != nil {
+		return false, fmt.Errorf("unable to read manifest: %w", err)
+	}
+
+	for _, l := range mf.Layers {
+		if types.IsEncryptedMediaType(string(l.MediaType)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// wrapLayersWithDecryptedLayer wraps each layer in the image with a decrypted
+// layer, each layer is decrypted with whichever of the private keys it was
+// encrypted for
+func wrapLayersWithDecryptedLayer(image v1.Image, privateKeys [][]byte) (v1.Image, error) {
+	new, err := baseImage(image)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %s", err)
+	}
 
 	layers, err := image.Layers()
 	if err != nil {
@@ -86,14 +182,14 @@ func wrapLayersWithDecryptedLayer(image v1.Image, privateKey []byte) (v1.Image,
 		}
 
 		// if the layer is encrypted we need to decrypt it
-		if strings.HasSuffix(string(mt), "+enc") {
+		if types.IsEncryptedMediaType(string(mt)) {
 			// get the annotation, this is needed to decrypt the image
 			ann := getLayerAnnotationsFromImage(image, l)
 			if ann[ENCRYPTION_KEY_ANNOTATION] == "" || ann[ENCRYPTION_KEY_OPTIONS] == "" {
 				return nil, fmt.Errorf("layer is encrypted but missing encryption annotations")
 			}
 
-			dl, err := crypto.NewDecryptedLayer(l, privateKey, ann)
+			dl, err := crypto.NewDecryptedLayer(l, privateKeys, ann)
 			if err != nil {
 				return nil, fmt.Errorf("unable to create decrypted layer: %s", err)
 			}
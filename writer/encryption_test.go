@@ -0,0 +1,73 @@
+package writer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/stream"
+
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptLayerEncryptsAllLayersWhenNoneSelected(t *testing.T) {
+	require.True(t, encryptLayer(types.KAPSULE_MEDIA_TYPE_TEMPLATE, nil))
+}
+
+func TestEncryptLayerSelectsByTypeOrMediaType(t *testing.T) {
+	selected := []string{"model", types.KAPSULE_MEDIA_TYPE_ADAPTER}
+
+	require.True(t, encryptLayer(types.KAPSULE_MEDIA_TYPE_MODEL, selected))
+	require.True(t, encryptLayer("application/vnd.kapsule.image.model", selected))
+	require.True(t, encryptLayer(types.KAPSULE_MEDIA_TYPE_ADAPTER, selected))
+	require.False(t, encryptLayer(types.KAPSULE_MEDIA_TYPE_TEMPLATE, selected))
+	require.False(t, encryptLayer(types.KAPSULE_MEDIA_TYPE_PARAMETERS, selected))
+}
+
+// brokenConfigImage is an image whose config can not be read
+type brokenConfigImage struct {
+	v1.Image
+}
+
+func (i *brokenConfigImage) ConfigFile() (*v1.ConfigFile, error) {
+	return nil, fmt.Errorf("unable to fetch config")
+}
+
+func TestBaseImageReturnsErrorWhenConfigCanNotBeRead(t *testing.T) {
+	i, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	_, err = baseImage(&brokenConfigImage{Image: i})
+	require.Error(t, err)
+}
+
+func TestBaseImageReturnsEmptyImageForStreamedLayers(t *testing.T) {
+	i, err := mutate.AppendLayers(empty.Image, stream.NewLayer(io.NopCloser(strings.NewReader("hello world"))))
+	require.NoError(t, err)
+
+	b, err := baseImage(i)
+	require.NoError(t, err)
+	require.Equal(t, empty.Image, b)
+}
+
+func TestBaseImageKeepsConfig(t *testing.T) {
+	i, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	i, err = mutate.Config(i, v1.Config{Labels: map[string]string{"org.kapsule.owner": "ml-team"}})
+	require.NoError(t, err)
+
+	b, err := baseImage(i)
+	require.NoError(t, err)
+
+	cf, err := b.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, "ml-team", cf.Config.Labels["org.kapsule.owner"])
+	require.Empty(t, cf.RootFS.DiffIDs)
+}
@@ -1,8 +1,12 @@
 package writer
 
-import v1 "github.com/google/go-containerregistry/pkg/v1"
+import (
+	"context"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
 
 type Writer interface {
-	Write(image v1.Image, imageRef string, decrypt, unzip bool) error
-	WriteEncrypted(image v1.Image, imageRef string) error
+	Write(ctx context.Context, image v1.Image, imageRef string, decrypt, unzip bool) error
+	WriteEncrypted(ctx context.Context, image v1.Image, imageRef string) error
 }
@@ -2,11 +2,15 @@ package writer
 
 import (
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/charmbracelet/log"
 	"github.com/containers/image/v5/manifest"
@@ -14,6 +18,7 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/stream"
 	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/progress"
 	"github.com/nicholasjackson/kapsule/types"
 	"github.com/opencontainers/go-digest"
 )
@@ -22,6 +27,8 @@ type OllamaWriter struct {
 	logger      *log.Logger
 	keyProvider keyproviders.Provider
 	filePath    string
+	jobs        int
+	progress    progress.Reporter
 }
 
 func NewOllamaWriter(logger *log.Logger, kp keyproviders.Provider, filePath string) *OllamaWriter {
@@ -29,14 +36,26 @@ func NewOllamaWriter(logger *log.Logger, kp keyproviders.Provider, filePath stri
 		logger:      logger,
 		keyProvider: kp,
 		filePath:    filePath,
+		jobs:        DefaultJobs,
+		progress:    progress.NewLogReporter(logger, 0),
 	}
 }
 
-func (ol *OllamaWriter) Write(image v1.Image, imageRef string, decrypt, unzip bool) error {
+// SetJobs sets the number of layers that are written concurrently
+func (ol *OllamaWriter) SetJobs(jobs int) {
+	ol.jobs = jobs
+}
+
+// SetProgress sets the reporter that receives the progress of each layer
+func (ol *OllamaWriter) SetProgress(p progress.Reporter) {
+	ol.progress = p
+}
+
+func (ol *OllamaWriter) Write(ctx context.Context, image v1.Image, imageRef string, decrypt, unzip bool) error {
 	cn := types.CanonicalRef(imageRef)
 	ref, err := name.ParseReference(cn)
 	if err != nil {
-		panic(err)
+		return types.Errorf(types.ErrorKindParse, "invalid image reference %s: %w", imageRef, err)
 	}
 
 	manifestFolder := path.Join(ol.filePath, "manifests", ref.Context().RegistryStr(), ref.Context().RepositoryStr())
@@ -45,86 +64,33 @@ func (ol *OllamaWriter) Write(image v1.Image, imageRef string, decrypt, unzip bo
 	// create the folders
 	err = os.MkdirAll(manifestFolder, os.ModePerm)
 	if err != nil {
-		return fmt.Errorf("unable to create manifests folder: %s", err)
+		return types.Errorf(types.ErrorKindIO, "unable to create manifests folder: %w", err)
 	}
 
-	os.MkdirAll(blobsFolder, os.ModePerm)
+	err = os.MkdirAll(blobsFolder, os.ModePerm)
 	if err != nil {
-		return fmt.Errorf("unable to create blobs folder: %s", err)
+		return types.Errorf(types.ErrorKindIO, "unable to create blobs folder: %w", err)
 	}
 
-	var layers []v1.Layer
-	// if the layers are encrypted we need to wrap them in a decrypting layer
-	if decrypt {
-		pk, err := ol.keyProvider.PrivateKey()
-		if err != nil {
-			return fmt.Errorf("unable to get private key: %s", err)
-		}
-
-		ol.logger.Info("Decrypting layers using private key")
-
-		// wrap the layers in a decrypted layer
-		ei, err := wrapLayersWithDecryptedLayer(image, pk)
-		if err != nil {
-			return fmt.Errorf("unable to encrypt image: %s", err)
-		}
-
-		layers, err = ei.Layers()
-		if err != nil {
-			return fmt.Errorf("unable to read layers: %s", err)
-		}
-	} else {
-		// get the unencrypted layers from the image
-		layers, err = image.Layers()
-		if err != nil {
-			return fmt.Errorf("unable to read layers: %s", err)
-		}
+	layers, err := readLayers(ctx, ol.logger, ol.keyProvider, progress.WrapImage(image, ol.progress), decrypt)
+	if err != nil {
+		return err
 	}
 
-	// add the layers
-	schemaLayers := []manifest.Schema2Descriptor{}
-	for i, l := range layers {
-		mt, err := l.MediaType()
+	// add the layers, the descriptors are stored by index so that the
+	// manifest lists the layers in the same order as the image
+	schemaLayers := make([]manifest.Schema2Descriptor, len(layers))
+	err = forEachLayer(ctx, ol.jobs, layers, func(ctx context.Context, i int, l v1.Layer) error {
+		sd, err := ol.writeLayer(ctx, blobsFolder, l)
 		if err != nil {
-			return fmt.Errorf("unable to get media type from layer: %s", err)
+			return err
 		}
 
-		switch mt {
-		case types.KAPSULE_MEDIA_TYPE_PARAMETERS:
-			ol.logger.Info("Converting Kapsule parameters to Ollama parameters")
-
-			// handle params differently as we need to convert
-			in, err := l.Compressed()
-			if err != nil {
-				return fmt.Errorf("unable to read layer: %w", err)
-			}
-
-			out := types.ConvertKapsuleParamsToOllamaParams(in)
-			if out == nil {
-				return fmt.Errorf("unable to convert parameters layer to ollama")
-			}
-
-			paramLayer := stream.NewLayer(
-				out,
-				stream.WithCompressionLevel(gzip.DefaultCompression),
-				stream.WithMediaType(types.OLLAMA_MEDIA_TYPE_PARAMETERS),
-			)
-
-			layers[i] = paramLayer
-			fallthrough
-		default:
-			lay := layers[i]
-
-			mt, _ := lay.MediaType()
-
-			sd, err := writeLayerBlob(blobsFolder, lay, string(mt))
-			if err != nil {
-				return fmt.Errorf("unable to write layer blob: %w", err)
-			}
-
-			ol.logger.Info("Written layer blob", "size", sd.Size, "digest", sd.Digest, "originalMediaType", mt, "newMediaType", sd.MediaType)
-			schemaLayers = append(schemaLayers, *sd)
-		}
+		schemaLayers[i] = *sd
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	ol.logger.Info("Creating Ollama config")
@@ -143,16 +109,9 @@ func (ol *OllamaWriter) Write(image v1.Image, imageRef string, decrypt, unzip bo
 		},
 	}
 
-	for _, l := range layers {
-		mt, _ := l.MediaType()
-
-		ol.logger.Debug("Getting diff id from layer", "mediaType", mt)
-		diff, err := l.DiffID()
-		if err != nil {
-			return fmt.Errorf("unable to get diff id from layer: %s %s", mt, err)
-		}
-
-		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diff.String())
+	// the blobs are uncompressed so the digest of each blob is the diff id
+	for _, sl := range schemaLayers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, sl.Digest.String())
 	}
 
 	size, err := config.Size()
@@ -165,10 +124,16 @@ func (ol *OllamaWriter) Write(image v1.Image, imageRef string, decrypt, unzip bo
 		return fmt.Errorf("unable to generate digest from config: %s", err)
 	}
 
-	// write the config
-	err = config.WriteToDisk(blobsFolder)
+	cfg, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("unable to marshal config: %s", err)
+	}
+
+	// write the config, unlike config.WriteToDisk the file is replaced
+	// atomically so a concurrent write never sees a partial config
+	err = writeFileAtomic(path.Join(blobsFolder, strings.Replace(dgst, ":", "-", 1)), cfg)
 	if err != nil {
-		return fmt.Errorf("unable to write config: %s", err)
+		return types.Errorf(types.ErrorKindIO, "unable to write config: %w", err)
 	}
 
 	ol.logger.Info("Creating Ollama image manifest")
@@ -186,88 +151,171 @@ func (ol *OllamaWriter) Write(image v1.Image, imageRef string, decrypt, unzip bo
 		LayersDescriptors: schemaLayers,
 	}
 
-	tag := ref.Identifier()
-	f, err := os.Create(path.Join(manifestFolder, tag))
-	if err != nil {
-		return fmt.Errorf("unable to open manifest file for writing: %s", err)
+	// do not write the manifest if the context was cancelled while
+	// writing the blobs
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	d := json.NewEncoder(f)
-
-	err = d.Encode(schema)
+	data, err := json.Marshal(schema)
 	if err != nil {
 		return fmt.Errorf("unable to encode manifest: %s", err)
 	}
 
+	// the manifest is written last so that it never references missing blobs
+	err = writeFileAtomic(path.Join(manifestFolder, ref.Identifier()), data)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to write manifest: %w", err)
+	}
+
 	return nil
 }
 
-// writes a layer as a blob and returns the schema descriptor
-func writeLayerBlob(blobPath string, layer v1.Layer, layerType string) (*manifest.Schema2Descriptor, error) {
-	// write the layer blob we need to do this first so that the digest and
-	// can be computed, first we write to a temp file and then rename
-	rc, err := layer.Compressed()
+// writeLayer writes the layer to the blobs folder converting the Kapsule
+// parameters to Ollama parameters
+func (ol *OllamaWriter) writeLayer(ctx context.Context, blobsFolder string, l v1.Layer) (*manifest.Schema2Descriptor, error) {
+	mt, err := l.MediaType()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get media type from layer: %s", err)
+	}
+
+	// layers read from an OCI layout written with unzip have the
+	// uncompressed media type
+	if types.CompressedMediaType(string(mt)) == types.KAPSULE_MEDIA_TYPE_PARAMETERS {
+		ol.logger.Info("Converting Kapsule parameters to Ollama parameters")
+
+		// handle params differently as we need to convert
+		in, err := l.Compressed()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read layer: %w", err)
+		}
+
+		out := types.ConvertKapsuleParamsToOllamaParams(in)
+		if out == nil {
+			return nil, fmt.Errorf("unable to convert parameters layer to ollama")
+		}
+
+		l = stream.NewLayer(
+			out,
+			stream.WithCompressionLevel(gzip.DefaultCompression),
+			stream.WithMediaType(types.OLLAMA_MEDIA_TYPE_PARAMETERS),
+		)
+	}
+
+	lmt, _ := l.MediaType()
+
+	sd, err := writeLayerBlob(ctx, blobsFolder, l, types.CompressedMediaType(string(lmt)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to write layer blob: %w", err)
+	}
+
+	ol.logger.Info("Written layer blob", "size", sd.Size, "digest", sd.Digest, "originalMediaType", mt, "newMediaType", sd.MediaType)
+
+	return sd, nil
+}
+
+// writes a layer as a blob and returns the schema descriptor, blobs that
+// already exist in the store are not written again
+func writeLayerBlob(ctx context.Context, blobPath string, layer v1.Layer, layerType string) (*manifest.Schema2Descriptor, error) {
+	// convert the Kapsule media type to the Ollama media type
+	sd := &manifest.Schema2Descriptor{
+		MediaType: types.KapsuleToOllamaMediaType(layerType),
+	}
+
+	// Ollama stores the uncompressed blob so the blob name is the diff id,
+	// this is only known before reading for layers that have not been streamed
+	// or decrypted, when known it is used to skip existing blobs and verify
+	// the written data
+	expected, err := layer.DiffID()
+	if err == nil {
+		if fi, err := os.Stat(blobName(blobPath, expected)); err == nil {
+			sd.Digest = digest.Digest(expected.String())
+			sd.Size = fi.Size()
+
+			return sd, nil
+		}
+	}
+	known := err == nil
+
+	lrc, err := layer.Compressed()
 	if err != nil {
 		return nil, fmt.Errorf("unable to get reader from layer: %w", err)
 	}
 
-	// the layer is compressed, get a gzip reader to decompress as we write it
-	gzrc, err := gzip.NewReader(rc)
+	// stop reading the layer if the context is cancelled
+	rc := newContextReader(ctx, lrc)
+	defer rc.Close()
+
+	// decompress the layer as it is written, layers from an OCI layout
+	// written with unzip are already uncompressed
+	gzrc, err := types.Decompress(rc)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create gzipped reader: %w", err)
 	}
 
-	// write to a temporary file as the digest is not available until the layer
-	// has been read
-	tempPath := path.Join(blobPath, "sha256-temp")
-	os.Remove(tempPath)
+	// write to a uniquely named temporary file as the digest is not available
+	// until the layer has been read, this allows concurrent writes to the store
+	f, err := createTemp(blobPath)
+	if err != nil {
+		return nil, types.Errorf(types.ErrorKindIO, "unable to open layer blob for writing: %w", err)
+	}
 
-	f, err := os.Create(tempPath)
+	// write the blob computing the digest of the data that is written,
+	// removing the partial blob if the copy fails
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), gzrc)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open layer blob for writing: %s", err)
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("unable to write layer blob: %w", err)
 	}
 
-	// write the blob
-	io.Copy(f, gzrc)
-	f.Close()
-	rc.Close()
-
-	// create the manifest
-	sd := manifest.Schema2Descriptor{}
-
-	// if the layer type is encrypted it will have a +enc suffix
-	// remove this before setting the media type
-	switch layerType {
-	case types.KAPSULE_MEDIA_TYPE_PARAMETERS:
-		sd.MediaType = types.OLLAMA_MEDIA_TYPE_PARAMETERS
-	case types.KAPSULE_MEDIA_TYPE_MODEL:
-		sd.MediaType = types.OLLAMA_MEDIA_TYPE_MODEL
-	case types.KAPSULE_MEDIA_TYPE_LICENCE:
-		sd.MediaType = types.OLLAMA_MEDIA_TYPE_LICENCE
-	case types.KAPSULE_MEDIA_TYPE_TEMPLATE:
-		sd.MediaType = types.OLLAMA_MEDIA_TYPE_TEMPLATE
-	default:
-		sd.MediaType = layerType
+	written := v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h.Sum(nil))}
+
+	// ensure the data written matches the diff id of the layer
+	if known && expected != written {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("digest of written blob %s does not match layer diff id %s", written, expected)
 	}
 
-	d, err := layer.DiffID()
+	sd.Digest = digest.Digest(written.String())
+	sd.Size = size
+
+	// sync and rename the blob now the digest is available, if the blob was
+	// written by another process in the meantime it has the same content.
+	// publishFile removes the temporary file when it fails.
+	err = publishFile(f, blobName(blobPath, written))
 	if err != nil {
-		return nil, fmt.Errorf("unable to get digest from layer: %w", err)
+		return nil, types.Errorf(types.ErrorKindIO, "unable to rename blob: %w", err)
 	}
-	sd.Digest = digest.Digest(d.String())
 
-	s, err := layer.Size()
-	if err != nil {
-		return nil, fmt.Errorf("unable to get size from layer: %w", err)
+	return sd, nil
+}
+
+// blobName returns the path of the blob with the given digest
+func blobName(blobPath string, d v1.Hash) string {
+	return path.Join(blobPath, fmt.Sprintf("%s-%s", d.Algorithm, d.Hex))
+}
+
+// readLayers returns the layers of the image, if decrypt is set the
+// layers are wrapped so that they are decrypted as they are read
+func readLayers(ctx context.Context, logger *log.Logger, kp keyproviders.Provider, image v1.Image, decrypt bool) ([]v1.Layer, error) {
+	if decrypt {
+		logger.Info("Decrypting layers using private key")
+
+		// wrap the layers in a decrypted layer
+		var err error
+		image, err = DecryptImage(ctx, kp, image)
+		if err != nil {
+			return nil, err
+		}
 	}
-	sd.Size = int64(s)
 
-	// rename the blob now the digest is available
-	lName := path.Join(blobPath, fmt.Sprintf("sha256-%s", sd.Digest.Encoded()))
-	err = os.Rename(tempPath, lName)
+	layers, err := image.Layers()
 	if err != nil {
-		return nil, fmt.Errorf("unable to rename blob: %s", err)
+		return nil, fmt.Errorf("unable to read layers: %s", err)
 	}
 
-	return &sd, nil
+	return layers, nil
 }
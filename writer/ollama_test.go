@@ -0,0 +1,242 @@
+package writer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/stream"
+	"github.com/nicholasjackson/kapsule/builder"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func setupOllama(t *testing.T) (*OllamaWriter, *log.Logger, string, v1.Image) {
+	l := testutils.CreateTestLogger(t)
+
+	kp := keyproviders.NewFile("../test_fixtures/keys/public.key", "../test_fixtures/keys/private.key")
+	b := builder.NewBuilder()
+
+	// build the image
+	i, err := b.Build(context.Background(), "../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
+	require.NoError(t, err)
+
+	td := t.TempDir()
+
+	return NewOllamaWriter(l, kp, td), l, td, i
+}
+
+func TestOllamaWritesManifestAndBlobs(t *testing.T) {
+	w, _, o, i := setupOllama(t)
+
+	err := w.Write(context.Background(), i, "test:latest", false, true)
+	require.NoError(t, err)
+
+	require.FileExists(t, path.Join(o, "manifests", "kapsule.io", "library", "test", "latest"))
+
+	blobs, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+	require.Len(t, blobs, 5)
+}
+
+func TestOllamaCancelledWriteRemovesPartialOutput(t *testing.T) {
+	w, _, o, i := setupOllama(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := w.Write(ctx, i, "test:latest", false, true)
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.NoFileExists(t, path.Join(o, "manifests", "kapsule.io", "library", "test", "latest"))
+
+	blobs, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+	require.Empty(t, blobs)
+}
+
+// countingLayer records the number of times the layer data is read
+type countingLayer struct {
+	v1.Layer
+	reads int
+}
+
+func (l *countingLayer) Compressed() (io.ReadCloser, error) {
+	l.reads++
+	return l.Layer.Compressed()
+}
+
+// badDiffIDLayer reports a diff id that does not match its data
+type badDiffIDLayer struct {
+	v1.Layer
+}
+
+func (l *badDiffIDLayer) DiffID() (v1.Hash, error) {
+	return v1.NewHash("sha256:0000000000000000000000000000000000000000000000000000000000000000")
+}
+
+func TestOllamaSkipsExistingBlobs(t *testing.T) {
+	w, _, o, _ := setupOllama(t)
+
+	rl, err := random.Layer(1024, "")
+	require.NoError(t, err)
+
+	l := &countingLayer{Layer: rl}
+	i, err := mutate.AppendLayers(empty.Image, l)
+	require.NoError(t, err)
+
+	err = w.Write(context.Background(), i, "test:v1", false, true)
+	require.NoError(t, err)
+
+	err = w.Write(context.Background(), i, "test:v2", false, true)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, l.reads)
+	require.FileExists(t, path.Join(o, "manifests", "kapsule.io", "library", "test", "v2"))
+}
+
+func TestOllamaWritesUncompressedSize(t *testing.T) {
+	w, _, o, _ := setupOllama(t)
+
+	rl, err := random.Layer(1024, "")
+	require.NoError(t, err)
+
+	i, err := mutate.AppendLayers(empty.Image, rl)
+	require.NoError(t, err)
+
+	err = w.Write(context.Background(), i, "test:v1", false, true)
+	require.NoError(t, err)
+
+	d, _ := rl.DiffID()
+	fi, err := os.Stat(path.Join(o, "blobs", "sha256-"+d.Hex))
+	require.NoError(t, err)
+
+	m, err := os.ReadFile(path.Join(o, "manifests", "kapsule.io", "library", "test", "v1"))
+	require.NoError(t, err)
+	require.Contains(t, string(m), fmt.Sprintf(`"size":%d`, fi.Size()))
+}
+
+func TestOllamaReturnsErrorWhenDigestDoesNotMatch(t *testing.T) {
+	w, _, o, _ := setupOllama(t)
+
+	rl, err := random.Layer(1024, "")
+	require.NoError(t, err)
+
+	i, err := mutate.AppendLayers(empty.Image, &badDiffIDLayer{rl})
+	require.NoError(t, err)
+
+	err = w.Write(context.Background(), i, "test:v1", false, true)
+	require.ErrorContains(t, err, "does not match")
+
+	blobs, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+	require.Empty(t, blobs)
+}
+
+// unknownDiffIDLayer behaves like a streamed layer, the diff id is only
+// known once the layer has been read
+type unknownDiffIDLayer struct {
+	v1.Layer
+}
+
+func (l *unknownDiffIDLayer) DiffID() (v1.Hash, error) {
+	return v1.Hash{}, stream.ErrNotComputed
+}
+
+func TestOllamaRemovesTemporaryBlobWhenPublishFails(t *testing.T) {
+	w, _, o, _ := setupOllama(t)
+
+	rl, err := random.Layer(1024, "")
+	require.NoError(t, err)
+
+	i, err := mutate.AppendLayers(empty.Image, &unknownDiffIDLayer{rl})
+	require.NoError(t, err)
+
+	// a folder with the name of the blob stops the blob being renamed
+	d, _ := rl.DiffID()
+	require.NoError(t, os.MkdirAll(path.Join(o, "blobs", "sha256-"+d.Hex, "taken"), os.ModePerm))
+
+	err = w.Write(context.Background(), i, "test:v1", false, true)
+	require.ErrorContains(t, err, "unable to rename blob")
+
+	blobs, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	require.True(t, blobs[0].IsDir())
+}
+
+func TestOllamaConcurrentWritesToSameStore(t *testing.T) {
+	w, _, o, _ := setupOllama(t)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+
+	for n := range errs {
+		i, err := random.Image(1024, 2)
+		require.NoError(t, err)
+
+		wg.Add(1)
+		go func(n int, i v1.Image) {
+			defer wg.Done()
+			errs[n] = w.Write(context.Background(), i, fmt.Sprintf("test:v%d", n), false, true)
+		}(n, i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	// each image has two layers and a config
+	blobs, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+	require.Len(t, blobs, 12)
+}
+
+func TestOllamaDecryptedBlobsAreNamedByDigest(t *testing.T) {
+	w, _, o, _ := setupOllama(t)
+	pw, _, lo, i := setupPathFileKp(t, "test")
+
+	err := pw.WriteEncrypted(context.Background(), i, "test:enc")
+	require.NoError(t, err)
+
+	p, err := layout.FromPath(lo)
+	require.NoError(t, err)
+
+	idx, err := p.ImageIndex()
+	require.NoError(t, err)
+
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	ei, err := idx.Image(im.Manifests[0].Digest)
+	require.NoError(t, err)
+
+	err = w.Write(context.Background(), ei, "test:latest", true, true)
+	require.NoError(t, err)
+
+	blobs, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+
+	for _, b := range blobs {
+		d, err := os.ReadFile(path.Join(o, "blobs", b.Name()))
+		require.NoError(t, err)
+
+		h := sha256.Sum256(d)
+		require.Equal(t, "sha256-"+hex.EncodeToString(h[:]), b.Name())
+	}
+}
@@ -0,0 +1,450 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/nicholasjackson/kapsule/types"
+)
+
+// DefaultOllamaAddress is the address of the Ollama API when OLLAMA_HOST is not set
+const DefaultOllamaAddress = "http://127.0.0.1:11434"
+
+// OllamaAddress returns the address of the Ollama API, this is the value of
+// OLLAMA_HOST or DefaultOllamaAddress
+func OllamaAddress() string {
+	if h := os.Getenv("OLLAMA_HOST"); h != "" {
+		return h
+	}
+
+	return DefaultOllamaAddress
+}
+
+// OllamaAPIWriter registers images with a running Ollama server using the
+// Ollama HTTP API, unlike OllamaWriter it does not need access to the
+// filesystem of the server. Blobs are uploaded using /api/blobs and the
+// model is then created using /api/create.
+type OllamaAPIWriter struct {
+	logger      *log.Logger
+	keyProvider keyproviders.Provider
+	address     string
+	client      *http.Client
+	jobs        int
+	progress    progress.Reporter
+	tempDir     string
+}
+
+func NewOllamaAPIWriter(logger *log.Logger, kp keyproviders.Provider, address string) *OllamaAPIWriter {
+	// OLLAMA_HOST is commonly set without a scheme i.e. 0.0.0.0:11434
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	return &OllamaAPIWriter{
+		logger:      logger,
+		keyProvider: kp,
+		address:     strings.TrimSuffix(address, "/"),
+		client:      &http.Client{},
+		jobs:        DefaultJobs,
+		progress:    progress.NewLogReporter(logger, 0),
+	}
+}
+
+// SetJobs sets the number of layers that are uploaded concurrently
+func (ol *OllamaAPIWriter) SetJobs(jobs int) {
+	ol.jobs = jobs
+}
+
+// SetProgress sets the reporter that receives the progress of each layer
+func (ol *OllamaAPIWriter) SetProgress(p progress.Reporter) {
+	ol.progress = p
+}
+
+// SetTempDir sets the folder used to store layers whose digest is not known
+// until they have been read, by default the system temporary folder is used
+func (ol *OllamaAPIWriter) SetTempDir(dir string) {
+	ol.tempDir = dir
+}
+
+// ollamaCreateRequest is the body sent to /api/create
+type ollamaCreateRequest struct {
+	Model      string                 `json:"model"`
+	Files      map[string]string      `json:"files,omitempty"`
+	Adapters   map[string]string      `json:"adapters,omitempty"`
+	Template   string                 `json:"template,omitempty"`
+	System     string                 `json:"system,omitempty"`
+	License    string                 `json:"license,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ollamaStatus is a single line of the streamed response from the Ollama API
+type ollamaStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// Write uploads the model and adapter layers as blobs and creates a model
+// named imageRef using the template, system message, licence and parameters
+// from the image. Ollama stores blobs uncompressed so unzip is ignored.
+func (ol *OllamaAPIWriter) Write(ctx context.Context, image v1.Image, imageRef string, decrypt, unzip bool) error {
+	layers, err := readLayers(ctx, ol.logger, ol.keyProvider, progress.WrapImage(image, ol.progress), decrypt)
+	if err != nil {
+		return err
+	}
+
+	req := &ollamaCreateRequest{
+		Model: types.CanonicalRef(imageRef),
+	}
+
+	// layers are read concurrently, the results are applied to the request
+	// in the order of the layers so that the request is deterministic
+	results := make([]func(*ollamaCreateRequest), len(layers))
+	err = forEachLayer(ctx, ol.jobs, layers, func(ctx context.Context, i int, l v1.Layer) error {
+		apply, err := ol.readLayer(ctx, l)
+		if err != nil {
+			return err
+		}
+
+		results[i] = apply
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, apply := range results {
+		apply(req)
+	}
+
+	if req.Files == nil {
+		return types.Errorf(types.ErrorKindNotFound, "image %s does not contain a model layer", imageRef)
+	}
+
+	ol.logger.Info("Creating Ollama model", "model", req.Model, "address", ol.address)
+
+	return ol.create(ctx, req)
+}
+
+// readLayer uploads or reads the layer and returns a function that sets the
+// result in the create request
+func (ol *OllamaAPIWriter) readLayer(ctx context.Context, l v1.Layer) (func(*ollamaCreateRequest), error) {
+	mt, err := l.MediaType()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get media type from layer: %s", err)
+	}
+
+	var apply func(*ollamaCreateRequest)
+
+	switch types.CompressedMediaType(string(mt)) {
+	case types.KAPSULE_MEDIA_TYPE_MODEL, types.KAPSULE_MEDIA_TYPE_ADAPTER:
+		var d string
+		d, err = ol.uploadBlob(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+
+		if types.CompressedMediaType(string(mt)) == types.KAPSULE_MEDIA_TYPE_MODEL {
+			apply = func(req *ollamaCreateRequest) { req.Files = map[string]string{"model.gguf": d} }
+		} else {
+			apply = func(req *ollamaCreateRequest) { req.Adapters = map[string]string{"adapter.gguf": d} }
+		}
+	case types.KAPSULE_MEDIA_TYPE_TEMPLATE:
+		var s string
+		s, err = readLayerString(ctx, l)
+		apply = func(req *ollamaCreateRequest) { req.Template = s }
+	case types.KAPSULE_MEDIA_TYPE_SYSTEM:
+		var s string
+		s, err = readLayerString(ctx, l)
+		apply = func(req *ollamaCreateRequest) { req.System = s }
+	case types.KAPSULE_MEDIA_TYPE_LICENCE:
+		var s string
+		s, err = readLayerString(ctx, l)
+		apply = func(req *ollamaCreateRequest) { req.License = s }
+	case types.KAPSULE_MEDIA_TYPE_PARAMETERS:
+		ol.logger.Info("Converting Kapsule parameters to Ollama parameters")
+
+		var p map[string]interface{}
+		p, err = readLayerParameters(l)
+		apply = func(req *ollamaCreateRequest) { req.Parameters = p }
+	default:
+		ol.logger.Warn("Skipping layer not supported by the Ollama API", "mediaType", mt)
+		apply = func(*ollamaCreateRequest) {}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read layer %s: %w", mt, err)
+	}
+
+	return apply, nil
+}
+
+// uploadBlob uploads the uncompressed layer to the server and returns the
+// digest of the blob, blobs that already exist on the server are not
+// uploaded. When the diff id of the layer is known the server is checked
+// before the layer is read, streamed and decrypted layers are written to a
+// temporary file so that the digest can be computed.
+func (ol *OllamaAPIWriter) uploadBlob(ctx context.Context, layer v1.Layer) (string, error) {
+	if h, err := layer.DiffID(); err == nil {
+		d := h.String()
+
+		exists, err := ol.blobExists(ctx, d)
+		if err != nil || exists {
+			return d, err
+		}
+
+		rc, err := uncompressedReader(ctx, layer)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+
+		// the size of the uncompressed layer is not known so the blob
+		// is sent using chunked encoding
+		return d, ol.postBlob(ctx, d, rc, -1)
+	}
+
+	rc, err := uncompressedReader(ctx, layer)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	if ol.tempDir != "" {
+		err = os.MkdirAll(ol.tempDir, os.ModePerm)
+		if err != nil {
+			return "", types.Errorf(types.ErrorKindIO, "unable to create temporary folder: %w", err)
+		}
+	}
+
+	f, err := os.CreateTemp(ol.tempDir, "kapsule-ollama-blob-*")
+	if err != nil {
+		return "", types.Errorf(types.ErrorKindIO, "unable to create temporary blob: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), rc)
+	if err != nil {
+		return "", fmt.Errorf("unable to write temporary blob: %w", err)
+	}
+
+	d := "sha256:" + hex.EncodeToString(h.Sum(nil))
+
+	exists, err := ol.blobExists(ctx, d)
+	if err != nil || exists {
+		return d, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", types.Errorf(types.ErrorKindIO, "unable to read temporary blob: %w", err)
+	}
+
+	return d, ol.postBlob(ctx, d, f, size)
+}
+
+// blobExists returns true when the server already has the blob with the
+// digest d
+func (ol *OllamaAPIWriter) blobExists(ctx context.Context, d string) (bool, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodHead, ol.blobURL(d), nil)
+	if err != nil {
+		return false, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	resp, err := ol.do(r)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+
+	ol.logger.Info("Blob already exists on Ollama server", "digest", d)
+	return true, nil
+}
+
+// postBlob uploads the blob with the digest d, when size is negative the
+// length of the blob is unknown
+func (ol *OllamaAPIWriter) postBlob(ctx context.Context, d string, body io.Reader, size int64) error {
+	ol.logger.Info("Uploading blob to Ollama server", "digest", d, "size", size)
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, ol.blobURL(d), body)
+	if err != nil {
+		return fmt.Errorf("unable to create request: %w", err)
+	}
+	r.ContentLength = size
+
+	resp, err := ol.do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return ollamaError(resp, "unable to upload blob "+d)
+	}
+
+	return nil
+}
+
+func (ol *OllamaAPIWriter) blobURL(d string) string {
+	return fmt.Sprintf("%s/api/blobs/%s", ol.address, d)
+}
+
+// create calls /api/create and logs the status messages streamed by the server
+func (ol *OllamaAPIWriter) create(ctx context.Context, req *ollamaCreateRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("unable to encode create request: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, ol.address+"/api/create", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to create request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := ol.do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ollamaError(resp, "unable to create model "+req.Model)
+	}
+
+	// errors that happen after the request has been accepted are
+	// returned in the stream of status messages
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		st := ollamaStatus{}
+		if err := json.Unmarshal(s.Bytes(), &st); err != nil {
+			return fmt.Errorf("unable to decode response from Ollama: %w", err)
+		}
+
+		if st.Error != "" {
+			return fmt.Errorf("unable to create model %s: %s", req.Model, st.Error)
+		}
+
+		ol.logger.Debug("Ollama create status", "status", st.Status)
+	}
+
+	if err := s.Err(); err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to read response from Ollama: %w", err)
+	}
+
+	return nil
+}
+
+func (ol *OllamaAPIWriter) do(r *http.Request) (*http.Response, error) {
+	resp, err := ol.client.Do(r)
+	if err != nil {
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+
+		return nil, types.Errorf(types.ErrorKindIO, "unable to connect to Ollama at %s: %w", ol.address, err)
+	}
+
+	return resp, nil
+}
+
+// ollamaError creates an error from an unsuccessful response, the Ollama
+// API returns the reason as json i.e. {"error": "message"}
+func ollamaError(resp *http.Response, message string) error {
+	st := ollamaStatus{}
+	json.NewDecoder(resp.Body).Decode(&st)
+
+	if st.Error == "" {
+		st.Error = resp.Status
+	}
+
+	kind := types.ErrorKindUnknown
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		kind = types.ErrorKindAuth
+	case http.StatusNotFound:
+		kind = types.ErrorKindNotFound
+	}
+
+	return types.Errorf(kind, "%s: %s", message, st.Error)
+}
+
+// uncompressedReader returns a reader that decompresses the layer, streamed
+// layers do not implement Uncompressed so the compressed reader is used.
+// Layers read from an OCI layout written with unzip are returned unchanged.
+func uncompressedReader(ctx context.Context, layer v1.Layer) (io.ReadCloser, error) {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get reader from layer: %w", err)
+	}
+
+	gzrc, err := types.Decompress(newContextReader(ctx, rc))
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("unable to create gzipped reader: %w", err)
+	}
+
+	return &readCloser{Reader: gzrc, close: rc.Close}, nil
+}
+
+// readCloser closes the underlying layer reader when a wrapping reader is closed
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error { return r.close() }
+
+// readLayerString returns the uncompressed contents of a layer as a string
+func readLayerString(ctx context.Context, layer v1.Layer) (string, error) {
+	rc, err := uncompressedReader(ctx, layer)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	d, err := io.ReadAll(rc)
+	if err != nil {
+		return "", err
+	}
+
+	return string(d), nil
+}
+
+// readLayerParameters converts a Kapsule parameters layer into the
+// parameters expected by the Ollama API
+func readLayerParameters(layer v1.Layer) (map[string]interface{}, error) {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	out := types.ConvertKapsuleParamsToOllamaParams(rc)
+	if out == nil {
+		return nil, types.Errorf(types.ErrorKindParse, "unable to convert parameters layer to ollama")
+	}
+
+	params := map[string]interface{}{}
+	if err := json.NewDecoder(out).Decode(&params); err != nil {
+		return nil, types.Errorf(types.ErrorKindParse, "unable to decode parameters: %w", err)
+	}
+
+	return params, nil
+}
@@ -0,0 +1,208 @@
+package writer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/testutils"
+	"github.com/nicholasjackson/kapsule/types"
+	"github.com/stretchr/testify/require"
+)
+
+// ollamaAPI is a minimal stand-in for the Ollama blobs and create API
+type ollamaAPI struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	uploads   int
+	requests  []ollamaCreateRequest
+	createErr string
+}
+
+func (o *ollamaAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/blobs/"):
+		d := strings.TrimPrefix(r.URL.Path, "/api/blobs/")
+
+		if r.Method == http.MethodHead {
+			if _, ok := o.blobs[d]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		h := sha256.Sum256(data)
+		if "sha256:"+hex.EncodeToString(h[:]) != d {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "digest mismatch"}`))
+			return
+		}
+
+		o.uploads++
+		o.blobs[d] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.URL.Path == "/api/create":
+		req := ollamaCreateRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		o.requests = append(o.requests, req)
+
+		w.Write([]byte(`{"status": "using existing layer"}` + "\n"))
+		if o.createErr != "" {
+			w.Write([]byte(`{"error": "` + o.createErr + `"}` + "\n"))
+			return
+		}
+
+		w.Write([]byte(`{"status": "success"}` + "\n"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func setupOllamaAPI(t *testing.T) (*OllamaAPIWriter, *ollamaAPI) {
+	api := &ollamaAPI{blobs: map[string][]byte{}}
+
+	ts := httptest.NewServer(api)
+	t.Cleanup(ts.Close)
+
+	kp := keyproviders.NewFile("../test_fixtures/keys/public.key", "../test_fixtures/keys/private.key")
+
+	return NewOllamaAPIWriter(testutils.CreateTestLogger(t), kp, ts.URL), api
+}
+
+func TestOllamaAPIUploadsBlobsAndCreatesModel(t *testing.T) {
+	w, api := setupOllamaAPI(t)
+	_, _, _, i := setupOllama(t)
+
+	err := w.Write(context.Background(), i, "test:latest", false, false)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, api.uploads)
+	require.Len(t, api.requests, 1)
+
+	req := api.requests[0]
+	require.Equal(t, "kapsule.io/library/test:latest", req.Model)
+	require.Contains(t, api.blobs, req.Files["model.gguf"])
+	require.Equal(t, `[INST] {{ .System }} {{ .Prompt }} [/INST]`, req.Template)
+	require.Equal(t, `You are brain from Pinky and the Brain, acting as an assitant.`, req.System)
+	require.Equal(t, []interface{}{"[/INST]", "[INST]"}, req.Parameters["stop"])
+	require.Equal(t, 0.8, req.Parameters["temperature"])
+}
+
+func TestOllamaAPISkipsExistingBlobs(t *testing.T) {
+	w, api := setupOllamaAPI(t)
+
+	_, _, _, i := setupOllama(t)
+	err := w.Write(context.Background(), i, "test:latest", false, false)
+	require.NoError(t, err)
+
+	_, _, _, i = setupOllama(t)
+	err = w.Write(context.Background(), i, "test:v2", false, false)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, api.uploads)
+	require.Len(t, api.requests, 2)
+}
+
+func TestOllamaAPIReturnsCreateError(t *testing.T) {
+	w, api := setupOllamaAPI(t)
+	api.createErr = "invalid model name"
+
+	_, _, _, i := setupOllama(t)
+	err := w.Write(context.Background(), i, "test:latest", false, false)
+	require.ErrorContains(t, err, "invalid model name")
+}
+
+func TestOllamaAPIReturnsIOErrorWhenServerUnavailable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+
+	w := NewOllamaAPIWriter(testutils.CreateTestLogger(t), nil, ts.URL)
+
+	_, _, _, i := setupOllama(t)
+	err := w.Write(context.Background(), i, "test:latest", false, false)
+	require.Equal(t, types.ErrorKindIO, types.ErrorKindOf(err))
+}
+
+// unreadableLayer fails when its contents are read
+type unreadableLayer struct {
+	v1.Layer
+}
+
+func (l *unreadableLayer) Compressed() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("layer should not be read")
+}
+
+func modelImage(t *testing.T, l v1.Layer) v1.Image {
+	i, err := mutate.Append(empty.Image, mutate.Addendum{Layer: l, MediaType: types.KAPSULE_MEDIA_TYPE_MODEL})
+	require.NoError(t, err)
+
+	return i
+}
+
+func TestOllamaAPIChecksBlobExistsBeforeReadingLayer(t *testing.T) {
+	w, api := setupOllamaAPI(t)
+
+	l := static.NewLayer([]byte("model"), types.KAPSULE_MEDIA_TYPE_MODEL)
+	d, err := l.DiffID()
+	require.NoError(t, err)
+
+	api.blobs[d.String()] = []byte("model")
+
+	err = w.Write(context.Background(), modelImage(t, &unreadableLayer{l}), "test:latest", false, false)
+	require.NoError(t, err)
+
+	require.Equal(t, 0, api.uploads)
+	require.Equal(t, d.String(), api.requests[0].Files["model.gguf"])
+}
+
+func TestOllamaAPIUploadsLayerWithKnownDigest(t *testing.T) {
+	w, api := setupOllamaAPI(t)
+
+	l := static.NewLayer([]byte("model"), types.KAPSULE_MEDIA_TYPE_MODEL)
+	d, err := l.DiffID()
+	require.NoError(t, err)
+
+	err = w.Write(context.Background(), modelImage(t, l), "test:latest", false, false)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, api.uploads)
+	require.Equal(t, []byte("model"), api.blobs[d.String()])
+}
+
+func TestOllamaAPIWritesStreamedLayersToTempDir(t *testing.T) {
+	w, api := setupOllamaAPI(t)
+
+	td := path.Join(t.TempDir(), "cache")
+	w.SetTempDir(td)
+
+	_, _, _, i := setupOllama(t)
+	err := w.Write(context.Background(), i, "test:latest", false, false)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, api.uploads)
+
+	// the folder is created and the temporary files are removed
+	files, err := os.ReadDir(td)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
@@ -1,17 +1,29 @@
 package writer
 
 import (
-	"compress/gzip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 
 	"github.com/charmbracelet/log"
 	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/nicholasjackson/kapsule/types"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/stream"
+	gtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // WriterImpl is a concrete implementation of the Writer interface
@@ -19,6 +31,11 @@ type PathWriter struct {
 	logger      *log.Logger
 	keyProvider keyproviders.Provider
 	filePath    string
+	jobs        int
+	progress    progress.Reporter
+	// encryptLayers are the layer types or media types that are encrypted by
+	// WriteEncrypted, when empty every layer is encrypted
+	encryptLayers []string
 }
 
 func NewPathWriter(logger *log.Logger, keyProvider keyproviders.Provider, path string) *PathWriter {
@@ -26,89 +43,124 @@ func NewPathWriter(logger *log.Logger, keyProvider keyproviders.Provider, path s
 		logger:      logger,
 		keyProvider: keyProvider,
 		filePath:    path,
+		jobs:        DefaultJobs,
+		progress:    progress.NewLogReporter(logger, 0),
 	}
 }
 
+// SetJobs sets the number of layers that are written concurrently
+func (pw *PathWriter) SetJobs(jobs int) {
+	pw.jobs = jobs
+}
+
+// SetProgress sets the reporter that receives the progress of each layer
+func (pw *PathWriter) SetProgress(p progress.Reporter) {
+	pw.progress = p
+}
+
+// SetEncryptLayers sets the layers that are encrypted by WriteEncrypted, layers
+// are selected by Kapsule layer type i.e. model or by media type. Layers that
+// are not selected are written unencrypted, by default every layer is encrypted.
+func (pw *PathWriter) SetEncryptLayers(layers []string) {
+	pw.encryptLayers = layers
+}
+
 // WriteToPath writes the image to a local OCI image registry defined by output
-func (pw *PathWriter) Write(image v1.Image, imageRef string, decypt, unzip bool) error {
+func (pw *PathWriter) Write(ctx context.Context, image v1.Image, imageRef string, decypt, unzip bool) error {
 	pw.logger.Info("Attempting to opening existing local path", "path", pw.filePath)
 	p, err := pw.createOrOpenPath()
 	if err != nil {
 		return err
 	}
 
+	// the progress of reading the source layers is reported, layers that
+	// already exist in the layout are not read
+	image = progress.WrapImage(image, pw.progress)
+
 	if decypt {
 		pw.logger.Info("Decrypting layers with private key")
 
-		pk, err := pw.keyProvider.PrivateKey()
+		image, err = DecryptImage(ctx, pw.keyProvider, image)
 		if err != nil {
-			return fmt.Errorf("unable to get private key: %s", err)
+			return err
 		}
-
-		// wrap the layers in a decrypted layer
-		ei, err := wrapLayersWithDecryptedLayer(image, pk)
-		if err != nil {
-			return fmt.Errorf("unable to encrypt image: %s", err)
-		}
-
-		// replate the image with the encrypted image
-		image = ei
 	}
 
-	// save the image
-	err = p.AppendImage(image)
-	if err != nil {
-		return fmt.Errorf("unable to save image: %s", err)
-	}
+	// layer reads stop when the context is cancelled and any partially
+	// written blobs are removed
+	image = withContext(ctx, image)
 
 	if unzip {
 		pw.logger.Info("Unzipping layers")
-		err = unzipLayers(p, image)
+		image, err = unzipImage(ctx, p, image, pw.jobs)
 		if err != nil {
-			return fmt.Errorf("unable to unzip layers: %s", err)
+			return fmt.Errorf("unable to unzip layers: %w", err)
 		}
 	}
 
+	err = pw.replaceImage(ctx, p, image, imageRef)
+	if err != nil {
+		return fmt.Errorf("unable to save image: %w", err)
+	}
+
 	return nil
 }
 
-func (pw *PathWriter) WriteEncrypted(image v1.Image, imageRef string) error {
+func (pw *PathWriter) WriteEncrypted(ctx context.Context, image v1.Image, imageRef string) error {
 	pw.logger.Info("Attempting to opening existing local path", "path", pw.filePath)
 	p, err := pw.createOrOpenPath()
 	if err != nil {
 		return err
 	}
 
-	pw.logger.Info("Encrypting layers with public key")
-	pk, err := pw.keyProvider.PublicKey()
+	pk, err := keyproviders.PublicKeys(ctx, pw.keyProvider)
 	if err != nil {
-		return fmt.Errorf("unable to get public key: %s", err)
+		return types.Errorf(types.ErrorKindCrypto, "unable to get public keys: %w", err)
 	}
 
-	ei, err := wrapLayersWithEncryptedLayer(image, pk)
+	pw.logger.Info("Encrypting layers with public keys", "recipients", len(pk))
+
+	ei, err := wrapLayersWithEncryptedLayer(withContext(ctx, progress.WrapImage(image, pw.progress)), pk, pw.encryptLayers)
 	if err != nil {
-		return fmt.Errorf("unable to encrypt image: %s", err)
+		return types.Errorf(types.ErrorKindCrypto, "unable to encrypt image: %w", err)
 	}
 
-	// replace the image with the encrypted image
+	// replace the image with the encrypted image, the config of the
+	// source image is added once the layers have been written
+	source := image
 	image = ei
 
 	// we must save the image befoe we can update the annotations
 	// the annotations contain the encrypted key that is used
-	// to decrypt the image
-	err = p.AppendImage(image)
+	// to decrypt the image, only the blobs are written so the image
+	// is not visible in the index until the annotations are added
+	err = writeImage(ctx, p, image, pw.jobs)
 	if err != nil {
 		return err
 	}
 
+	// the manifest and config of the first write are not referenced by
+	// the annotated image and are removed once it has been written
+	firstManifest, err := image.Digest()
+	if err != nil {
+		return fmt.Errorf("unable to get image digest: %w", err)
+	}
+
+	firstConfig, err := image.ConfigName()
+	if err != nil {
+		return fmt.Errorf("unable to get image config: %w", err)
+	}
+
 	pw.logger.Info("Adding annotations from encryption process to manifest")
-	newImage, err := appendEncyptedLayerAnnotations(image)
+	newImage, err := appendEncyptedLayerAnnotations(image, source)
 	if err != nil {
 		return fmt.Errorf("unable to update annotations: %s", err)
 	}
 
+	// only the image containing the encryption annotations is added to
+	// the index, the layers of the first write are shared with this image
 	pw.logger.Info("Updating image")
-	err = p.WriteImage(newImage)
+	err = pw.replaceImage(ctx, p, newImage, imageRef, firstManifest, firstConfig)
 	if err != nil {
 		return err
 	}
@@ -116,73 +168,559 @@ func (pw *PathWriter) WriteEncrypted(image v1.Image, imageRef string) error {
 	return nil
 }
 
+// replaceImage writes the image to the layout, annotating the index entry
+// with the name of the image. Any existing image with the same name is
+// removed from the index and its blobs, along with any stale blobs, are
+// deleted when no longer referenced. The index is only updated once all
+// the blobs have been written.
+func (pw *PathWriter) replaceImage(ctx context.Context, p layout.Path, image v1.Image, imageRef string, stale ...v1.Hash) error {
+	name := types.CanonicalRef(imageRef)
+
+	err := writeImage(ctx, p, image, pw.jobs)
+	if err != nil {
+		return err
+	}
+
+	// do not publish the image if the context was cancelled
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	desc, err := partial.Descriptor(image)
+	if err != nil {
+		return fmt.Errorf("unable to get image descriptor: %w", err)
+	}
+	desc.Annotations = map[string]string{ocispec.AnnotationRefName: name}
+
+	removed, err := updateIndex(p, func(m v1.Descriptor) bool {
+		return m.Annotations[ocispec.AnnotationRefName] != name
+	}, *desc)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to update index: %w", err)
+	}
+
+	return pw.removeUnreferenced(p, removed, stale...)
+}
+
 func (pw *PathWriter) createOrOpenPath() (layout.Path, error) {
 	p, err := layout.FromPath(pw.filePath)
 	if err != nil {
 		// no index exists at the path, create a new index
 		pw.logger.Info("Path does not exist, creating new path", "path", pw.filePath)
-		p, err = layout.Write(pw.filePath, empty.Index)
+		p, err = initLayout(pw.filePath)
 		if err != nil {
-			return layout.Path(""), fmt.Errorf("unable to create new path: %s", err)
+			return layout.Path(""), types.Errorf(types.ErrorKindIO, "unable to create new path: %w", err)
 		}
 	}
 
 	return p, nil
 }
 
-// unzip the blob content of each layer
-func unzipLayers(p layout.Path, image v1.Image) error {
+// initLayout creates an empty OCI layout at path, the index is written
+// last so that the layout is only valid once it has been created
+func initLayout(path string) (layout.Path, error) {
+	// the blobs folder is required by the layout spec even when empty
+	err := os.MkdirAll(filepath.Join(path, "blobs", "sha256"), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeFileAtomic(filepath.Join(path, ocispec.ImageLayoutFile), []byte(`{"imageLayoutVersion": "1.0.0"}`))
+	if err != nil {
+		return "", err
+	}
+
+	err = writeIndex(layout.Path(path), &v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     gtypes.OCIImageIndex,
+		Manifests:     []v1.Descriptor{},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return layout.Path(path), nil
+}
+
+// updateIndex keeps the descriptors in the index for which keep returns
+// true and appends the given descriptors, the descriptors that were
+// removed from the index are returned
+func updateIndex(p layout.Path, keep func(v1.Descriptor) bool, add ...v1.Descriptor) ([]v1.Descriptor, error) {
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []v1.Descriptor{}
+	removed := []v1.Descriptor{}
+	for _, m := range im.Manifests {
+		if keep(m) {
+			manifests = append(manifests, m)
+			continue
+		}
+
+		removed = append(removed, m)
+	}
+
+	im.Manifests = append(manifests, add...)
+
+	return removed, writeIndex(p, im)
+}
+
+// writeIndex atomically replaces the index.json of the layout
+func writeIndex(p layout.Path, im *v1.IndexManifest) error {
+	data, err := json.Marshal(im)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(string(p), "index.json"), data)
+}
+
+// writeImage writes the layers, config and manifest of the image to the
+// blobs folder of the layout. Unlike layout.WriteImage every blob is written
+// to a temporary file that is synced and renamed into place once complete,
+// so an interrupted write never leaves a partial blob. At most jobs layers
+// are written concurrently, the config and manifest are written last.
+func writeImage(ctx context.Context, p layout.Path, image v1.Image, jobs int) error {
 	layers, err := image.Layers()
 	if err != nil {
-		return fmt.Errorf("unable to get layers from image: %s", err)
+		return fmt.Errorf("unable to get layers from image: %w", err)
 	}
 
-	for _, l := range layers {
-		d, err := l.Digest()
-		if err != nil {
-			return fmt.Errorf("unable to get digest: %s", err)
+	err = forEachLayer(ctx, jobs, layers, func(ctx context.Context, _ int, l v1.Layer) error {
+		return writeLayer(ctx, p, l)
+	})
+	if err != nil {
+		return err
+	}
+
+	cn, err := image.ConfigName()
+	if err != nil {
+		return fmt.Errorf("unable to get config digest: %w", err)
+	}
+
+	cfg, err := image.RawConfigFile()
+	if err != nil {
+		return fmt.Errorf("unable to get config: %w", err)
+	}
+
+	_, _, err = writeBlob(p, io.NopCloser(bytes.NewReader(cfg)), func() (v1.Hash, error) { return cn, nil })
+	if err != nil {
+		return fmt.Errorf("unable to write config: %w", err)
+	}
+
+	d, err := image.Digest()
+	if err != nil {
+		return fmt.Errorf("unable to get manifest digest: %w", err)
+	}
+
+	mf, err := image.RawManifest()
+	if err != nil {
+		return fmt.Errorf("unable to get manifest: %w", err)
+	}
+
+	_, _, err = writeBlob(p, io.NopCloser(bytes.NewReader(mf)), func() (v1.Hash, error) { return d, nil })
+	if err != nil {
+		return fmt.Errorf("unable to write manifest: %w", err)
+	}
+
+	return nil
+}
+
+// writeLayer writes the compressed layer to the layout, layers that
+// already exist in the layout are not written again
+func writeLayer(ctx context.Context, p layout.Path, layer v1.Layer) error {
+	d, err := layer.Digest()
+	if err == nil {
+		if _, err := os.Stat(blobPath(p, d)); err == nil {
+			return nil
 		}
+	} else if !errors.Is(err, stream.ErrNotComputed) {
+		return fmt.Errorf("unable to get layer digest: %w", err)
+	}
+
+	rc, err := layer.Compressed()
+	if err != nil {
+		return fmt.Errorf("unable to get reader from layer: %w", err)
+	}
+
+	_, _, err = writeBlob(p, newContextReader(ctx, rc), layer.Digest)
+	if err != nil {
+		return fmt.Errorf("unable to write layer: %w", err)
+	}
+
+	return nil
+}
+
+// writeBlob writes the contents of rc to a temporary file in the layout,
+// the reader is closed before calling digest as streamed layers compute
+// their digest on close. The blob is only renamed into place if digest
+// matches the digest of the data that was written, when digest is nil the
+// blob is named after the data that was written. Returns the digest and
+// size of the blob.
+func writeBlob(p layout.Path, rc io.ReadCloser, digest func() (v1.Hash, error)) (v1.Hash, int64, error) {
+	defer rc.Close()
+
+	dir := filepath.Join(string(p), "blobs", "sha256")
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return v1.Hash{}, 0, types.Errorf(types.ErrorKindIO, "unable to create blobs folder: %w", err)
+	}
+
+	f, err := createTemp(dir)
+	if err != nil {
+		return v1.Hash{}, 0, types.Errorf(types.ErrorKindIO, "unable to create blob: %w", err)
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), rc)
+	if err == nil {
+		err = rc.Close()
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return v1.Hash{}, 0, err
+	}
+
+	written := v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h.Sum(nil))}
 
-		rc, err := p.Blob(d)
+	if digest != nil {
+		d, err := digest()
 		if err != nil {
-			return fmt.Errorf("unable to get blob: %s", err)
+			f.Close()
+			os.Remove(f.Name())
+			return v1.Hash{}, 0, fmt.Errorf("unable to get digest: %w", err)
 		}
 
-		// create a temp file to write the uncompressed layer to
-		tempFile, err := os.CreateTemp("", "uncompressed_layer_")
-		if err != nil {
-			return fmt.Errorf("unable to create temporary file: %s", err)
+		if d != written {
+			f.Close()
+			os.Remove(f.Name())
+			return v1.Hash{}, 0, fmt.Errorf("digest of written blob %s does not match %s", written, d)
 		}
-		defer tempFile.Close()
-		defer os.Remove(tempFile.Name())
+	}
+
+	err = publishFile(f, blobPath(p, written))
+	if err != nil {
+		return v1.Hash{}, 0, types.Errorf(types.ErrorKindIO, "unable to write blob: %w", err)
+	}
+
+	return written, size, nil
+}
 
-		gzr, err := gzip.NewReader(rc)
+// blobPath returns the location of the blob with the given digest
+func blobPath(p layout.Path, d v1.Hash) string {
+	return filepath.Join(string(p), "blobs", d.Algorithm, d.Hex)
+}
+
+// unzipImage writes the uncompressed contents of each layer to the layout
+// and returns an image that references the uncompressed blobs. The digest of
+// an uncompressed layer is its diff id and the +gzip suffix is removed from
+// the media type so that every blob still matches its digest. The compressed
+// layers are never written to the layout. At most jobs layers are unzipped
+// concurrently.
+func unzipImage(ctx context.Context, p layout.Path, image v1.Image, jobs int) (v1.Image, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get layers from image: %w", err)
+	}
+
+	descs := make([]v1.Descriptor, len(layers))
+
+	err = forEachLayer(ctx, jobs, layers, func(ctx context.Context, i int, l v1.Layer) error {
+		d, err := unzipLayer(ctx, p, l)
 		if err != nil {
-			return fmt.Errorf("unable to create gzip reader: %s", err)
+			return err
+		}
+
+		descs[i] = *d
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the manifest of streamed layers is only available once the layers
+	// have been read
+	mf, err := image.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get manifest: %w", err)
+	}
+
+	cfg, err := image.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get config: %w", err)
+	}
+
+	// decrypted layers do not know their diff id until they have been read,
+	// the digests of the uncompressed blobs are the diff ids
+	cfg = cfg.DeepCopy()
+	cfg.RootFS.DiffIDs = []v1.Hash{}
+
+	mf = mf.DeepCopy()
+	for i := range mf.Layers {
+		descs[i].Annotations = mf.Layers[i].Annotations
+		cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs, descs[i].Digest)
+	}
+	mf.Layers = descs
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode config: %w", err)
+	}
+
+	mf.Config.Digest, mf.Config.Size, err = writeBlob(p, io.NopCloser(bytes.NewReader(data)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write config: %w", err)
+	}
+
+	data, err = json.Marshal(mf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode manifest: %w", err)
+	}
+
+	_, _, err = writeBlob(p, io.NopCloser(bytes.NewReader(data)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write manifest: %w", err)
+	}
+
+	return partial.CompressedToImage(&blobImage{path: p, manifest: mf, rawManifest: data})
+}
+
+// blobImage is an image whose blobs have been written to the layout but
+// that has not yet been added to the index
+type blobImage struct {
+	path        layout.Path
+	manifest    *v1.Manifest
+	rawManifest []byte
+}
+
+func (b *blobImage) MediaType() (gtypes.MediaType, error) {
+	return b.manifest.MediaType, nil
+}
+
+func (b *blobImage) RawManifest() ([]byte, error) {
+	return b.rawManifest, nil
+}
+
+func (b *blobImage) RawConfigFile() ([]byte, error) {
+	return b.path.Bytes(b.manifest.Config.Digest)
+}
+
+func (b *blobImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	for _, l := range b.manifest.Layers {
+		if l.Digest == h {
+			return &blobLayer{path: b.path, desc: l}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("layer %s not found in manifest", h)
+}
+
+// blobLayer is a layer stored as a blob in the layout
+type blobLayer struct {
+	path layout.Path
+	desc v1.Descriptor
+}
+
+func (b *blobLayer) Digest() (v1.Hash, error) {
+	return b.desc.Digest, nil
+}
+
+func (b *blobLayer) Compressed() (io.ReadCloser, error) {
+	return b.path.Blob(b.desc.Digest)
+}
+
+func (b *blobLayer) Size() (int64, error) {
+	return b.desc.Size, nil
+}
+
+func (b *blobLayer) MediaType() (gtypes.MediaType, error) {
+	return b.desc.MediaType, nil
+}
+
+// unzipLayer writes the uncompressed contents of the layer to the layout and
+// returns the descriptor for the uncompressed blob. When the diff id of the
+// layer is known before the layer is read, existing blobs are not written
+// again and the written data is verified against the diff id.
+func unzipLayer(ctx context.Context, p layout.Path, layer v1.Layer) (*v1.Descriptor, error) {
+	mt, err := layer.MediaType()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get media type from layer: %w", err)
+	}
+
+	if types.IsEncryptedMediaType(string(mt)) {
+		return nil, types.Errorf(types.ErrorKindCrypto, "encrypted layers can not be unzipped, decrypt the image or disable unzip")
+	}
+
+	desc := &v1.Descriptor{MediaType: gtypes.MediaType(types.UncompressedMediaType(string(mt)))}
+
+	diffID, err := layer.DiffID()
+	if err == nil {
+		if fi, err := os.Stat(blobPath(p, diffID)); err == nil {
+			desc.Digest = diffID
+			desc.Size = fi.Size()
+
+			return desc, nil
 		}
+	}
+
+	var digest func() (v1.Hash, error)
+	if err == nil {
+		digest = layer.DiffID
+	}
+
+	rc, err := uncompressedReader(ctx, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	desc.Digest, desc.Size, err = writeBlob(p, rc, digest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write uncompressed layer: %w", err)
+	}
+
+	return desc, nil
+}
+
+// descriptorBlobs returns the digests of the manifest described by desc and
+// of the configs, layers and manifests it references. The blobs are read
+// from the layout so the descriptor does not need to be in the index,
+// blobs that no longer exist are ignored.
+func descriptorBlobs(p layout.Path, desc v1.Descriptor) ([]v1.Hash, error) {
+	blobs := []v1.Hash{desc.Digest}
+
+	data, err := p.Bytes(desc.Digest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return blobs, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest %s: %w", desc.Digest, err)
+	}
 
-		_, err = io.Copy(tempFile, gzr)
+	switch {
+	case desc.MediaType.IsIndex():
+		im, err := v1.ParseIndexManifest(bytes.NewReader(data))
 		if err != nil {
-			return fmt.Errorf("unable to copy contents to temporary file: %s", err)
+			return nil, fmt.Errorf("unable to read index %s: %w", desc.Digest, err)
 		}
 
-		// close the gzip reader
-		gzr.Close()
+		for _, m := range im.Manifests {
+			child, err := descriptorBlobs(p, m)
+			if err != nil {
+				return nil, err
+			}
 
-		// remove the old blob
-		err = p.RemoveBlob(d)
+			blobs = append(blobs, child...)
+		}
+	case desc.MediaType.IsImage():
+		mf, err := v1.ParseManifest(bytes.NewReader(data))
 		if err != nil {
-			return fmt.Errorf("unable to remove compressed layer: %s", err)
+			return nil, fmt.Errorf("unable to read manifest %s: %w", desc.Digest, err)
+		}
+
+		blobs = append(blobs, mf.Config.Digest)
+		for _, l := range mf.Layers {
+			blobs = append(blobs, l.Digest)
 		}
+	}
 
-		tempFile.Seek(0, 0)
+	return blobs, nil
+}
 
-		// write the new blob
-		err = p.WriteBlob(d, tempFile)
+// unreferencedBlobs returns the blobs that are not referenced by the images
+// in the index of the layout, files in the blobs folder that are not named
+// after a digest are ignored
+func unreferencedBlobs(p layout.Path) ([]v1.Hash, error) {
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read index: %w", err)
+	}
+
+	keep := map[v1.Hash]bool{}
+	err = referencedBlobs(idx, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	unused := []v1.Hash{}
+	blobsDir := filepath.Join(string(p), "blobs")
+
+	algs, err := os.ReadDir(blobsDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read blobs: %w", err)
+	}
+
+	for _, alg := range algs {
+		if !alg.IsDir() {
+			continue
+		}
+
+		blobs, err := os.ReadDir(filepath.Join(blobsDir, alg.Name()))
 		if err != nil {
-			return fmt.Errorf("unable to write uncompressed layer: %s", err)
+			return nil, fmt.Errorf("unable to read blobs: %w", err)
+		}
+
+		for _, b := range blobs {
+			// temporary files may belong to a write that is in progress
+			h, err := v1.NewHash(alg.Name() + ":" + b.Name())
+			if err != nil || keep[h] {
+				continue
+			}
+
+			unused = append(unused, h)
 		}
+	}
+
+	return unused, nil
+}
 
+// referencedBlobs adds the digests of all manifests, configs and layers
+// referenced by the index to keep
+func referencedBlobs(idx v1.ImageIndex, keep map[v1.Hash]bool) error {
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("unable to read index: %w", err)
+	}
+
+	for _, desc := range im.Manifests {
+		keep[desc.Digest] = true
+
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return fmt.Errorf("unable to read index %s: %w", desc.Digest, err)
+			}
+
+			err = referencedBlobs(child, keep)
+			if err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return fmt.Errorf("unable to read image %s: %w", desc.Digest, err)
+			}
+
+			mf, err := img.Manifest()
+			if err != nil {
+				return fmt.Errorf("unable to read manifest %s: %w", desc.Digest, err)
+			}
+
+			keep[mf.Config.Digest] = true
+			for _, l := range mf.Layers {
+				keep[l.Digest] = true
+			}
+		}
 	}
 
 	return nil
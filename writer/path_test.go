@@ -1,14 +1,21 @@
 package writer
 
 import (
+	"context"
 	"os"
+	"path"
 	"testing"
 
 	"github.com/charmbracelet/log"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	gtypes "github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/nicholasjackson/kapsule/builder"
 	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
 	"github.com/nicholasjackson/kapsule/testutils"
+	"github.com/nicholasjackson/kapsule/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,7 +27,7 @@ func setupPathFileKp(t *testing.T, ref string) (*PathWriter, *log.Logger, string
 	b := builder.NewBuilder()
 
 	// build the image
-	i, err := b.Build("../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
+	i, err := b.Build(context.Background(), "../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
 	require.NoError(t, err)
 
 	td := t.TempDir()
@@ -36,7 +43,7 @@ func setupPathVaultKp(t *testing.T, ref string) (*PathWriter, *log.Logger, strin
 	b := builder.NewBuilder()
 
 	// build the image
-	i, err := b.Build("../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
+	i, err := b.Build(context.Background(), "../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
 	require.NoError(t, err)
 
 	td := t.TempDir()
@@ -51,7 +58,7 @@ func TestACCPathWritesPlainToPath(t *testing.T) {
 
 	pw, _, o, i := setupPathFileKp(t, "test")
 
-	err := pw.Write(i, o, false, true)
+	err := pw.Write(context.Background(), i, o, false, true)
 	require.NoError(t, err)
 
 	// check writen file exists
@@ -65,7 +72,7 @@ func TestACCPathWritesEncryptedWithFileKeyToPath(t *testing.T) {
 
 	pw, _, o, i := setupPathFileKp(t, "test")
 
-	err := pw.WriteEncrypted(i, o)
+	err := pw.WriteEncrypted(context.Background(), i, o)
 	require.NoError(t, err)
 
 	// check writen file exists
@@ -79,9 +86,252 @@ func TestACCPathWritesEncryptedWithVaultKeyToPath(t *testing.T) {
 
 	pw, _, o, i := setupPathVaultKp(t, "test")
 
-	err := pw.WriteEncrypted(i, o)
+	err := pw.WriteEncrypted(context.Background(), i, o)
 	require.NoError(t, err)
 
 	// check writen file exists
 
 }
+
+func TestPathWriteRecordsTagAndReplacesExistingImage(t *testing.T) {
+	pw, _, o, _ := setupPathFileKp(t, "test")
+
+	first, err := random.Image(100, 2)
+	require.NoError(t, err)
+
+	second, err := random.Image(100, 2)
+	require.NoError(t, err)
+
+	other, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	err = pw.Write(context.Background(), first, "nicholasjackson/test:v1", false, false)
+	require.NoError(t, err)
+
+	err = pw.Write(context.Background(), other, "nicholasjackson/test:v2", false, false)
+	require.NoError(t, err)
+
+	err = pw.Write(context.Background(), second, "nicholasjackson/test:v1", false, false)
+	require.NoError(t, err)
+
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, err := p.ImageIndex()
+	require.NoError(t, err)
+
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, im.Manifests, 2)
+	require.Equal(t, "kapsule.io/nicholasjackson/test:v2", im.Manifests[0].Annotations[ocispec.AnnotationRefName])
+	require.Equal(t, "kapsule.io/nicholasjackson/test:v1", im.Manifests[1].Annotations[ocispec.AnnotationRefName])
+
+	// the blobs of the replaced image should have been removed
+	fl, err := first.Layers()
+	require.NoError(t, err)
+
+	for _, l := range fl {
+		d, err := l.Digest()
+		require.NoError(t, err)
+		require.NoFileExists(t, path.Join(o, "blobs", d.Algorithm, d.Hex))
+	}
+
+	fd, err := first.Digest()
+	require.NoError(t, err)
+	require.NoFileExists(t, path.Join(o, "blobs", fd.Algorithm, fd.Hex))
+
+	sd, err := second.Digest()
+	require.NoError(t, err)
+	require.FileExists(t, path.Join(o, "blobs", sd.Algorithm, sd.Hex))
+}
+
+func TestPathWriteKeepsBlobsThatAreNotInTheIndex(t *testing.T) {
+	pw, _, o, _ := setupPathFileKp(t, "test")
+
+	first, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	second, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	err = pw.Write(context.Background(), first, "nicholasjackson/test:v1", false, false)
+	require.NoError(t, err)
+
+	// a blob written by another write that has not yet updated the index
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	inflight, err := random.Layer(100, gtypes.OCILayer)
+	require.NoError(t, err)
+
+	ih, err := inflight.Digest()
+	require.NoError(t, err)
+
+	rc, err := inflight.Compressed()
+	require.NoError(t, err)
+	require.NoError(t, p.WriteBlob(ih, rc))
+
+	err = pw.Write(context.Background(), second, "nicholasjackson/test:v1", false, false)
+	require.NoError(t, err)
+
+	require.FileExists(t, path.Join(o, "blobs", ih.Algorithm, ih.Hex))
+
+	fd, err := first.Digest()
+	require.NoError(t, err)
+	require.NoFileExists(t, path.Join(o, "blobs", fd.Algorithm, fd.Hex))
+}
+
+func TestPathWriteEncryptedAddsAnnotatedImageToIndex(t *testing.T) {
+	pw, _, o, i := setupPathFileKp(t, "test")
+
+	err := pw.WriteEncrypted(context.Background(), i, "nicholasjackson/test:enc")
+	require.NoError(t, err)
+
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, err := p.ImageIndex()
+	require.NoError(t, err)
+
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, im.Manifests, 1)
+
+	img, err := idx.Image(im.Manifests[0].Digest)
+	require.NoError(t, err)
+
+	mf, err := img.Manifest()
+	require.NoError(t, err)
+
+	for _, l := range mf.Layers {
+		require.NotEmpty(t, l.Annotations[ENCRYPTION_KEY_ANNOTATION])
+	}
+
+	// the manifest and config of the first write are removed
+	unused, err := unreferencedBlobs(p)
+	require.NoError(t, err)
+	require.Empty(t, unused)
+}
+
+func TestPathWriteEncryptedOnlyEncryptsSelectedLayers(t *testing.T) {
+	pw, l, o, i := setupPathFileKp(t, "test")
+	pw.SetEncryptLayers([]string{"model"})
+
+	err := pw.WriteEncrypted(context.Background(), i, "nicholasjackson/test:enc")
+	require.NoError(t, err)
+
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, err := p.ImageIndex()
+	require.NoError(t, err)
+
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	img, err := idx.Image(im.Manifests[0].Digest)
+	require.NoError(t, err)
+
+	mf, err := img.Manifest()
+	require.NoError(t, err)
+	require.Len(t, mf.Layers, 4)
+
+	for _, ml := range mf.Layers {
+		if types.LayerType(string(ml.MediaType)) == "model" {
+			require.True(t, types.IsEncryptedMediaType(string(ml.MediaType)))
+			require.NotEmpty(t, ml.Annotations[ENCRYPTION_KEY_ANNOTATION])
+			continue
+		}
+
+		require.False(t, types.IsEncryptedMediaType(string(ml.MediaType)))
+		require.Empty(t, ml.Annotations)
+	}
+
+	// the image can be decrypted as only the encrypted layers are decrypted
+	kp := keyproviders.NewFile("", "../test_fixtures/keys/private.key")
+	err = NewPathWriter(l, kp, t.TempDir()).Write(context.Background(), img, "nicholasjackson/test:dec", true, false)
+	require.NoError(t, err)
+}
+
+func TestPathWriteUnzipEncryptedImageReturnsCryptoError(t *testing.T) {
+	pw, _, o, i := setupPathFileKp(t, "test")
+
+	err := pw.WriteEncrypted(context.Background(), i, "nicholasjackson/test:enc")
+	require.NoError(t, err)
+
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, err := p.ImageIndex()
+	require.NoError(t, err)
+
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	img, err := idx.Image(im.Manifests[0].Digest)
+	require.NoError(t, err)
+
+	err = NewPathWriter(testutils.CreateTestLogger(t), nil, t.TempDir()).Write(context.Background(), img, "nicholasjackson/test:enc", false, true)
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindCrypto, types.ErrorKindOf(err))
+}
+
+func TestPathWriteUnzipStoresLayersUnderDiffID(t *testing.T) {
+	pw, _, o, i := setupPathFileKp(t, "test")
+
+	err := pw.Write(context.Background(), i, "test:v1", false, true)
+	require.NoError(t, err)
+
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, _ := p.ImageIndex()
+	im, _ := idx.IndexManifest()
+	require.Len(t, im.Manifests, 1)
+
+	img, err := idx.Image(im.Manifests[0].Digest)
+	require.NoError(t, err)
+
+	mf, err := img.Manifest()
+	require.NoError(t, err)
+
+	cf, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Len(t, cf.RootFS.DiffIDs, len(mf.Layers))
+
+	for n, l := range mf.Layers {
+		require.NotContains(t, string(l.MediaType), "+gzip")
+		require.Equal(t, cf.RootFS.DiffIDs[n], l.Digest)
+	}
+
+	require.Equal(t, types.KAPSULE_MEDIA_TYPE_MODEL, types.CompressedMediaType(string(mf.Layers[0].MediaType)))
+
+	// every blob must match its digest
+	problems, err := pw.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
+
+func TestPathWriteUnzipImageCanBeWrittenToOllama(t *testing.T) {
+	pw, _, o, i := setupPathFileKp(t, "test")
+
+	err := pw.Write(context.Background(), i, "test:v1", false, true)
+	require.NoError(t, err)
+
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, _ := p.ImageIndex()
+	im, _ := idx.IndexManifest()
+
+	img, err := idx.Image(im.Manifests[0].Digest)
+	require.NoError(t, err)
+
+	ow, _, _, _ := setupOllama(t)
+	err = ow.Write(context.Background(), img, "test:latest", false, false)
+	require.NoError(t, err)
+
+	problems, err := ow.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
@@ -0,0 +1,44 @@
+package writer
+
+import (
+	"context"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultJobs is the number of layers that writers fetch, decrypt and
+// write concurrently when SetJobs has not been called
+const DefaultJobs = 4
+
+// forEachLayer calls fn for every layer using at most jobs goroutines. The
+// index of the layer is passed to fn so that results can be stored in the
+// order of the layers regardless of the order in which they complete. The
+// context passed to fn is cancelled when any call returns an error, layers
+// that have not started are skipped and the first error is returned.
+func forEachLayer(ctx context.Context, jobs int, layers []v1.Layer, fn func(ctx context.Context, i int, l v1.Layer) error) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(validJobs(jobs))
+
+	for i, l := range layers {
+		i, l := i, l
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+
+			return fn(gctx, i, l)
+		})
+	}
+
+	return g.Wait()
+}
+
+// validJobs returns DefaultJobs when jobs is less than one
+func validJobs(jobs int) int {
+	if jobs < 1 {
+		return DefaultJobs
+	}
+
+	return jobs
+}
@@ -0,0 +1,102 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+)
+
+func randomLayers(t *testing.T, n int) []v1.Layer {
+	layers := make([]v1.Layer, n)
+	for i := range layers {
+		l, err := random.Layer(64, "application/vnd.oci.image.layer.v1.tar+gzip")
+		require.NoError(t, err)
+
+		layers[i] = l
+	}
+
+	return layers
+}
+
+func TestForEachLayerLimitsConcurrency(t *testing.T) {
+	layers := randomLayers(t, 10)
+
+	var running, max int64
+	err := forEachLayer(context.Background(), 3, layers, func(ctx context.Context, i int, l v1.Layer) error {
+		r := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+
+		for {
+			m := atomic.LoadInt64(&max)
+			if r <= m || atomic.CompareAndSwapInt64(&max, m, r) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.LessOrEqual(t, atomic.LoadInt64(&max), int64(3))
+	require.Greater(t, atomic.LoadInt64(&max), int64(1))
+}
+
+func TestForEachLayerPassesIndexOfLayer(t *testing.T) {
+	layers := randomLayers(t, 10)
+
+	digests := make([]v1.Hash, len(layers))
+	err := forEachLayer(context.Background(), 4, layers, func(ctx context.Context, i int, l v1.Layer) error {
+		// complete the layers in reverse order
+		time.Sleep(time.Duration(len(layers)-i) * time.Millisecond)
+
+		d, err := l.Digest()
+		digests[i] = d
+		return err
+	})
+	require.NoError(t, err)
+
+	for i, l := range layers {
+		d, _ := l.Digest()
+		require.Equal(t, d, digests[i])
+	}
+}
+
+func TestForEachLayerStopsOnFirstError(t *testing.T) {
+	layers := randomLayers(t, 10)
+
+	var calls int64
+	err := forEachLayer(context.Background(), 1, layers, func(ctx context.Context, i int, l v1.Layer) error {
+		atomic.AddInt64(&calls, 1)
+		return fmt.Errorf("boom")
+	})
+	require.EqualError(t, err, "boom")
+	require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+}
+
+func TestOllamaManifestDoesNotDependOnJobs(t *testing.T) {
+	manifests := []string{}
+
+	for _, jobs := range []int{1, 8} {
+		w, _, o, i := setupOllama(t)
+		w.SetJobs(jobs)
+
+		err := w.Write(context.Background(), i, "test:latest", false, true)
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(path.Join(o, "manifests", "kapsule.io", "library", "test", "latest"))
+		require.NoError(t, err)
+
+		manifests = append(manifests, string(data))
+	}
+
+	require.Equal(t, manifests[0], manifests[1])
+}
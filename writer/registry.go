@@ -1,101 +1,143 @@
 package writer
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
-	"net/http"
-	"time"
 
 	"github.com/charmbracelet/log"
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/progress"
+	"github.com/nicholasjackson/kapsule/registry"
+	"github.com/nicholasjackson/kapsule/types"
 )
 
 type OCIRegistry struct {
 	logger      *log.Logger
-	username    string
-	password    string
+	options     registry.Options
 	keyProvider keyproviders.Provider
-	insecure    bool
+	jobs        int
+	progress    progress.Reporter
+	// encryptLayers are the layer types or media types that are encrypted by
+	// WriteEncrypted, when empty every layer is encrypted
+	encryptLayers []string
 }
 
-func NewOCIRegistry(logger *log.Logger, kp keyproviders.Provider, username, password string, insecure bool) *OCIRegistry {
+func NewOCIRegistry(logger *log.Logger, kp keyproviders.Provider, options registry.Options) *OCIRegistry {
 	return &OCIRegistry{
 		logger:      logger,
-		username:    username,
-		password:    password,
+		options:     options,
 		keyProvider: kp,
-		insecure:    insecure,
+		jobs:        DefaultJobs,
+		progress:    progress.NewLogReporter(logger, 0),
 	}
 }
 
-// Push pushes the given image to a remote OCI image registry
-func (r *OCIRegistry) Write(image v1.Image, imageRef string, decrypt, unzip bool) error {
-	ref, err := name.ParseReference(imageRef)
+// SetJobs sets the number of layers that are pushed concurrently
+func (r *OCIRegistry) SetJobs(jobs int) {
+	r.jobs = jobs
+}
+
+// SetProgress sets the reporter that receives the progress of each layer
+func (r *OCIRegistry) SetProgress(p progress.Reporter) {
+	r.progress = p
+}
+
+// SetEncryptLayers sets the layers that are encrypted by WriteEncrypted, layers
+// are selected by Kapsule layer type i.e. model or by media type. Layers that
+// are not selected are written unencrypted, by default every layer is encrypted.
+func (r *OCIRegistry) SetEncryptLayers(layers []string) {
+	r.encryptLayers = layers
+}
+
+// Write pushes the given image to a remote OCI image registry, if decrypt is
+// set the layers are decrypted before they are pushed. Layers that already
+// exist in the repository are skipped and layers of images pulled from the
+// same registry are mounted rather than uploaded.
+func (r *OCIRegistry) Write(ctx context.Context, image v1.Image, imageRef string, decrypt, unzip bool) error {
+	// registries that serve plain HTTP are accessed using http
+	ref, err := r.options.ParseReference(imageRef)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	// get the auth for the registry
-	auth, err := r.getAuth()
+	// credentials passed explicitly take precedence over those stored by
+	// kapsule login, docker login or podman login
+	kc := r.options.Keychain()
+
+	// each registry gets its own transport so that the TLS settings are
+	// never shared with other registries
+	t, err := r.options.Transport(ref.Context().Registry)
 	if err != nil {
-		return fmt.Errorf("unable to get auth: %s", err)
+		return err
 	}
 
-	// get the transport setting insecure if needed
-	t := r.getTransport()
+	// layers that already exist in the registry are not read so only the
+	// layers that are uploaded are reported
+	image = progress.WrapImage(image, r.progress)
+
+	if decrypt {
+		r.logger.Info("Decrypting layers with private key")
+
+		image, err = DecryptImage(ctx, r.keyProvider, image)
+		if err != nil {
+			return err
+		}
+	}
 
-	// remote.WithProgress to write the image with progress
 	r.logger.Info("Pushing image", "imageRef", imageRef)
-	err = remote.Write(ref, image, remote.WithAuth(auth), remote.WithProgress(r.progressReport()), remote.WithTransport(t))
+
+	err = remote.Write(ref, image, remote.WithContext(ctx), remote.WithAuthFromKeychain(kc), remote.WithTransport(t), remote.WithRetryBackoff(r.options.RetryBackoff()), remote.WithJobs(validJobs(r.jobs)))
 	if err != nil {
-		return fmt.Errorf("unable to write image to registry: %s", err)
+		return fmt.Errorf("unable to write image to registry: %w", err)
 	}
 
+	r.logger.Info("Image pushed to registry", "imageRef", imageRef)
+
 	return nil
 }
 
-func (r *OCIRegistry) WriteEncrypted(image v1.Image, imageRef string) error {
-	ref, err := name.ParseReference(imageRef)
+func (r *OCIRegistry) WriteEncrypted(ctx context.Context, image v1.Image, imageRef string) error {
+	// registries that serve plain HTTP are accessed using http
+	ref, err := r.options.ParseReference(imageRef)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	auth, err := r.getAuth()
+	kc := r.options.Keychain()
+
+	trans, err := r.options.Transport(ref.Context().Registry)
 	if err != nil {
-		return fmt.Errorf("unable to get auth: %s", err)
+		return err
 	}
 
-	// get the transport setting insecure if needed
-	trans := r.getTransport()
-
 	// we need to encrypt the image
 	// we do this by wrapping the image in a layers with an
 	// encrypted layer
-	pk, err := r.keyProvider.PublicKey()
+	pk, err := keyproviders.PublicKeys(ctx, r.keyProvider)
 	if err != nil {
-		return fmt.Errorf("unable to get public key: %s", err)
+		return types.Errorf(types.ErrorKindCrypto, "unable to get public keys: %w", err)
 	}
 
-	r.logger.Info("Encrypting layers with public key")
+	r.logger.Info("Encrypting layers with public keys", "recipients", len(pk))
 
-	ei, err := wrapLayersWithEncryptedLayer(image, pk)
+	// the progress of reading the unencrypted layers is reported
+	ei, err := wrapLayersWithEncryptedLayer(progress.WrapImage(image, r.progress), pk, r.encryptLayers)
 	if err != nil {
-		return fmt.Errorf("unable to encrypt image: %s", err)
+		return types.Errorf(types.ErrorKindCrypto, "unable to encrypt image: %w", err)
 	}
 
-	// replace the image with the encrypted image
+	// replace the image with the encrypted image, the config of the
+	// source image is added once the layers have been written
+	source := image
 	image = ei
 
-	// remote.WithProgress to write the image with progress
-	r.logger.Info("Pushing image", "imageRef", imageRef, "insecure", r.insecure)
+	r.logger.Info("Pushing image", "imageRef", imageRef)
 
-	err = remote.Write(ref, image, remote.WithAuth(auth), remote.WithProgress(r.progressReport()), remote.WithTransport(trans))
+	err = remote.Write(ref, image, remote.WithContext(ctx), remote.WithAuthFromKeychain(kc), remote.WithTransport(trans), remote.WithRetryBackoff(r.options.RetryBackoff()), remote.WithJobs(validJobs(r.jobs)))
 	if err != nil {
-		return fmt.Errorf("unable to write image to registry: %s", err)
+		return fmt.Errorf("unable to write image to registry: %w", err)
 	}
 
 	// we need to update the annotations that are set when writing the
@@ -103,86 +145,19 @@ func (r *OCIRegistry) WriteEncrypted(image v1.Image, imageRef string) error {
 	// decrypt the image
 	r.logger.Info("Updating layers with encryption details")
 
-	newImage, err := appendEncyptedLayerAnnotations(image)
+	newImage, err := appendEncyptedLayerAnnotations(image, source)
 	if err != nil {
 		return fmt.Errorf("unable to update annotations: %s", err)
 	}
 
 	r.logger.Info("Updating remote image", "imageRef", imageRef)
 
-	err = remote.Write(ref, newImage, remote.WithAuth(auth), remote.WithProgress(r.progressReport()), remote.WithTransport(trans))
+	err = remote.Write(ref, newImage, remote.WithContext(ctx), remote.WithAuthFromKeychain(kc), remote.WithTransport(trans), remote.WithRetryBackoff(r.options.RetryBackoff()), remote.WithJobs(validJobs(r.jobs)))
 	if err != nil {
-		return fmt.Errorf("unable to write image to registry: %s", err)
+		return fmt.Errorf("unable to write image to registry: %w", err)
 	}
-	return nil
-}
 
-func (r *OCIRegistry) progressReport() chan v1.Update {
-	ch := make(chan v1.Update, 1)
-	completed := int64(0)
-
-	// we can't calculate the percentage as we do not know the total size of the
-	// image, so we will just report the number bytes pushed
-	t := time.NewTicker(2 * time.Second)
-	go func() {
-		for {
-			<-t.C
-			r.logger.Info("Pushing image", "complete", byteCountSI(completed))
-		}
-	}()
-
-	go func() {
-		for {
-			update, ok := <-ch
-			if !ok {
-				r.logger.Info("Image pushed to registry")
-				t.Stop()
-				return
-			}
-
-			completed = update.Complete
-		}
-	}()
-
-	return ch
-}
-
-func byteCountSI(b int64) string {
-	const unit = 1000
-	if b < unit {
-		return fmt.Sprintf("%d B", b)
-	}
-	div, exp := int64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB",
-		float64(b)/float64(div), "kMGTPE"[exp])
-}
-
-func (r *OCIRegistry) getAuth() (authn.Authenticator, error) {
-	b := authn.Basic{
-		Username: r.username,
-		Password: r.password,
-	}
+	r.logger.Info("Image pushed to registry", "imageRef", imageRef)
 
-	cfg, err := b.Authorization()
-	if err != nil {
-		return nil, err
-	}
-
-	return authn.FromConfig(*cfg), nil
-}
-
-func (r *OCIRegistry) getTransport() http.RoundTripper {
-	// create a custom transport so we can set the insecure flag
-	transport := remote.DefaultTransport
-	if r.insecure {
-		transport.(*http.Transport).TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
-	}
-
-	return transport
+	return nil
 }
@@ -1,6 +1,7 @@
 package writer
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -8,6 +9,7 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/nicholasjackson/kapsule/builder"
 	"github.com/nicholasjackson/kapsule/crypto/keyproviders"
+	"github.com/nicholasjackson/kapsule/registry"
 	"github.com/nicholasjackson/kapsule/testutils"
 	"github.com/stretchr/testify/require"
 )
@@ -18,10 +20,10 @@ func setupRegistry(t *testing.T, ref string) (*OCIRegistry, *log.Logger, v1.Imag
 	// create a builder and push to a registry
 	kp := keyproviders.NewFile("../test_fixtures/keys/public.key", "../test_fixtures/keys/private.key")
 	b := builder.NewBuilder()
-	w := NewOCIRegistry(l, kp, "admin", "password", true)
+	w := NewOCIRegistry(l, kp, registry.Options{Username: "admin", Password: "password", TLS: registry.TLS{InsecureSkipVerify: true}})
 
 	// build the image
-	i, err := b.Build("../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
+	i, err := b.Build(context.Background(), "../test_fixtures/testmodel/modelfile", "../test_fixtures/testmodel")
 	require.NoError(t, err)
 
 	return w, l, i
@@ -36,7 +38,7 @@ func TestACCPushToRegistry(t *testing.T) {
 
 	r, _, i := setupRegistry(t, ref)
 
-	err := r.Write(i, ref, false, false)
+	err := r.Write(context.Background(), i, ref, false, false)
 	require.NoError(t, err)
 }
 
@@ -49,6 +51,6 @@ func TestACCPushEncryptedToRegistry(t *testing.T) {
 
 	r, _, i := setupRegistry(t, ref)
 
-	err := r.WriteEncrypted(i, ref)
+	err := r.WriteEncrypted(context.Background(), i, ref)
 	require.NoError(t, err)
 }
@@ -0,0 +1,449 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/nicholasjackson/kapsule/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Tag points the tag dst at the manifest of src, the manifest is not
+// downloaded and no blobs are uploaded. When dst is in another repository
+// of the same registry the blobs are mounted from the source repository.
+func (r *OCIRegistry) Tag(ctx context.Context, src, dst string) error {
+	sref, err := r.options.ParseReference(src)
+	if err != nil {
+		return err
+	}
+
+	dref, err := r.options.ParseReference(dst)
+	if err != nil {
+		return err
+	}
+
+	tag, ok := dref.(name.Tag)
+	if !ok {
+		return types.Errorf(types.ErrorKindParse, "invalid tag %s, the destination must be a tag not a digest", dst)
+	}
+
+	if sref.Context().RegistryStr() != tag.Context().RegistryStr() {
+		return types.Errorf(types.ErrorKindParse, "%s and %s are in different registries, use copy to copy the image", src, dst)
+	}
+
+	t, err := r.options.Transport(sref.Context().Registry)
+	if err != nil {
+		return err
+	}
+
+	opts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(r.options.Keychain()),
+		remote.WithTransport(t),
+		remote.WithRetryBackoff(r.options.RetryBackoff()),
+	}
+
+	r.logger.Info("Tagging image", "src", src, "dst", dst)
+
+	if sref.Context().Name() != tag.Context().Name() {
+		// the layers of remote images are mounted by remote.Write
+		image, err := remote.Image(sref, opts...)
+		if err != nil {
+			return fmt.Errorf("unable to get image %s: %w", src, err)
+		}
+
+		err = remote.Write(tag, image, opts...)
+		if err != nil {
+			return fmt.Errorf("unable to write image to registry: %w", err)
+		}
+
+		return nil
+	}
+
+	desc, err := remote.Get(sref, opts...)
+	if err != nil {
+		return fmt.Errorf("unable to get image %s: %w", src, err)
+	}
+
+	err = remote.Tag(tag, desc, opts...)
+	if err != nil {
+		return fmt.Errorf("unable to tag image: %w", err)
+	}
+
+	return nil
+}
+
+// Remove deletes the tag or manifest at ref using the OCI delete API.
+// Deleting a digest removes the manifest and every tag that points at it,
+// not all registries support deleting a tag without deleting the manifest.
+// Blobs are removed by the garbage collection of the registry.
+func (r *OCIRegistry) Remove(ctx context.Context, imageRef string) error {
+	ref, err := r.options.ParseReference(imageRef)
+	if err != nil {
+		return err
+	}
+
+	t, err := r.options.Transport(ref.Context().Registry)
+	if err != nil {
+		return err
+	}
+
+	r.logger.Info("Removing image", "ref", imageRef)
+
+	err = remote.Delete(
+		ref,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(r.options.Keychain()),
+		remote.WithTransport(t),
+		remote.WithRetryBackoff(r.options.RetryBackoff()),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to remove %s: %w", imageRef, err)
+	}
+
+	return nil
+}
+
+// Tag adds dst to the index of the layout pointing at the same manifest
+// as src, src can be the digest, name or tag of an image in the layout. An
+// image already tagged dst is replaced and its blobs removed when they are
+// no longer referenced.
+func (pw *PathWriter) Tag(ctx context.Context, src, dst string) error {
+	p, im, err := pw.openIndex()
+	if err != nil {
+		return err
+	}
+
+	d, err := types.FindManifest(im.Manifests, src)
+	if err != nil {
+		return types.Errorf(types.ErrorKindNotFound, "unable to find image %s in OCI layout at %s: %w", src, pw.filePath, err)
+	}
+
+	name := types.CanonicalRef(dst)
+
+	desc := *d
+	desc.Annotations = map[string]string{}
+	for k, v := range d.Annotations {
+		desc.Annotations[k] = v
+	}
+	desc.Annotations[ocispec.AnnotationRefName] = name
+
+	pw.logger.Info("Tagging image", "src", src, "dst", name, "digest", d.Digest)
+
+	removed, err := updateIndex(p, func(m v1.Descriptor) bool {
+		return m.Annotations[ocispec.AnnotationRefName] != name
+	}, desc)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to update index: %w", err)
+	}
+
+	return pw.removeUnreferenced(p, removed)
+}
+
+// Remove deletes the image at ref from the index of the layout and removes
+// the blobs that are not referenced by any other image. When ref is a
+// digest every name that points at the manifest is removed, otherwise only
+// the matching name is removed.
+func (pw *PathWriter) Remove(ctx context.Context, ref string) error {
+	p, im, err := pw.openIndex()
+	if err != nil {
+		return err
+	}
+
+	d, err := types.FindManifest(im.Manifests, ref)
+	if err != nil {
+		return types.Errorf(types.ErrorKindNotFound, "unable to find image %s in OCI layout at %s: %w", ref, pw.filePath, err)
+	}
+
+	byDigest := d.Digest.String() == ref
+	name := d.Annotations[ocispec.AnnotationRefName]
+
+	pw.logger.Info("Removing image", "ref", ref, "digest", d.Digest)
+
+	removed, err := updateIndex(p, func(m v1.Descriptor) bool {
+		return m.Digest != d.Digest || (!byDigest && m.Annotations[ocispec.AnnotationRefName] != name)
+	})
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to update index: %w", err)
+	}
+
+	return pw.removeUnreferenced(p, removed)
+}
+
+// openIndex opens the existing layout and reads its index
+func (pw *PathWriter) openIndex() (layout.Path, *v1.IndexManifest, error) {
+	p, err := layout.FromPath(pw.filePath)
+	if err != nil {
+		return "", nil, types.Errorf(types.ErrorKindNotFound, "unable to open OCI layout at %s: %w", pw.filePath, err)
+	}
+
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return "", nil, types.Errorf(types.ErrorKindIO, "unable to read index for OCI layout at %s: %w", pw.filePath, err)
+	}
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return "", nil, types.Errorf(types.ErrorKindIO, "unable to read index for OCI layout at %s: %w", pw.filePath, err)
+	}
+
+	return p, im, nil
+}
+
+// removeUnreferenced removes the blobs of the removed images and the stale
+// blobs that are no longer referenced by the images in the index. Only
+// these blobs are candidates for removal, blobs that are not yet in the
+// index, such as those of a write in progress, are kept.
+func (pw *PathWriter) removeUnreferenced(p layout.Path, removed []v1.Descriptor, stale ...v1.Hash) error {
+	candidates := stale
+	for _, d := range removed {
+		blobs, err := descriptorBlobs(p, d)
+		if err != nil {
+			return types.Errorf(types.ErrorKindIO, "unable to remove unreferenced blobs: %w", err)
+		}
+
+		candidates = append(candidates, blobs...)
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to read index for OCI layout at %s: %w", pw.filePath, err)
+	}
+
+	keep := map[v1.Hash]bool{}
+	err = referencedBlobs(idx, keep)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to remove unreferenced blobs: %w", err)
+	}
+
+	count := 0
+	for _, h := range candidates {
+		if keep[h] {
+			continue
+		}
+
+		// candidates can be listed more than once
+		keep[h] = true
+
+		err = p.RemoveBlob(h)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return types.Errorf(types.ErrorKindIO, "unable to remove blob %s: %w", h, err)
+		}
+
+		count++
+	}
+
+	if count > 0 {
+		pw.logger.Info("Removed unreferenced blobs", "count", count)
+	}
+
+	return nil
+}
+
+// Tag copies the manifest of the model src to dst, the blobs are shared by
+// both models. An existing model named dst is replaced.
+func (ol *OllamaWriter) Tag(ctx context.Context, src, dst string) error {
+	sp, err := ol.manifestPath(src)
+	if err != nil {
+		return err
+	}
+
+	dp, err := ol.manifestPath(dst)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(sp)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return types.Errorf(types.ErrorKindNotFound, "unable to find model %s in Ollama store at %s", src, ol.filePath)
+		}
+
+		return types.Errorf(types.ErrorKindIO, "unable to read manifest: %w", err)
+	}
+
+	// blobs are only removed when every manifest in the store can be read
+	_, err = ol.referencedBlobs()
+	if err != nil {
+		return err
+	}
+
+	ol.logger.Info("Tagging model", "src", src, "dst", dst)
+
+	err = os.MkdirAll(filepath.Dir(dp), os.ModePerm)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to create manifests folder: %w", err)
+	}
+
+	// the blobs of a model that is replaced may no longer be referenced
+	replaced, _ := ollamaBlobs(dp, filepath.Join(ol.filePath, "blobs"))
+
+	err = writeFileAtomic(dp, data)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to write manifest: %w", err)
+	}
+
+	return ol.removeUnshared(replaced)
+}
+
+// Remove deletes the manifest of the model and the blobs that are not
+// shared with other models in the store
+func (ol *OllamaWriter) Remove(ctx context.Context, ref string) error {
+	mp, err := ol.manifestPath(ref)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := ollamaBlobs(mp, filepath.Join(ol.filePath, "blobs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return types.Errorf(types.ErrorKindNotFound, "unable to find model %s in Ollama store at %s", ref, ol.filePath)
+		}
+
+		return types.Errorf(types.ErrorKindParse, "unable to read manifest: %w", err)
+	}
+
+	// blobs are only removed when every manifest in the store can be read
+	_, err = ol.referencedBlobs()
+	if err != nil {
+		return err
+	}
+
+	ol.logger.Info("Removing model", "ref", ref)
+
+	err = os.Remove(mp)
+	if err != nil {
+		return types.Errorf(types.ErrorKindIO, "unable to remove manifest: %w", err)
+	}
+
+	// remove the folders of the repository when it has no other tags
+	manifests := filepath.Join(ol.filePath, "manifests")
+	for dir := filepath.Dir(mp); dir != manifests; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return ol.removeUnshared(blobs)
+}
+
+// removeUnshared removes the blobs that are not referenced by any manifest
+// in the store
+func (ol *OllamaWriter) removeUnshared(blobs []string) error {
+	if len(blobs) == 0 {
+		return nil
+	}
+
+	shared, err := ol.referencedBlobs()
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, b := range blobs {
+		if shared[b] {
+			continue
+		}
+
+		err := os.Remove(b)
+		if err != nil && !os.IsNotExist(err) {
+			return types.Errorf(types.ErrorKindIO, "unable to remove blob: %w", err)
+		}
+
+		removed++
+	}
+
+	if removed > 0 {
+		ol.logger.Info("Removed unreferenced blobs", "count", removed)
+	}
+
+	return nil
+}
+
+// manifestPath returns the path of the manifest for the model i.e.
+// manifests/registry.ollama.ai/library/mistral/latest
+func (ol *OllamaWriter) manifestPath(imageRef string) (string, error) {
+	ref, err := name.ParseReference(types.CanonicalRef(imageRef))
+	if err != nil {
+		return "", types.Errorf(types.ErrorKindParse, "invalid image reference %s: %w", imageRef, err)
+	}
+
+	return filepath.Join(ol.filePath, "manifests", ref.Context().RegistryStr(), ref.Context().RepositoryStr(), ref.Identifier()), nil
+}
+
+// referencedBlobs returns the paths of the blobs referenced by the manifests
+// in the store, manifests that can not be read are ignored
+func (ol *OllamaWriter) referencedBlobs() (map[string]bool, error) {
+	manifests := filepath.Join(ol.filePath, "manifests")
+	blobsFolder := filepath.Join(ol.filePath, "blobs")
+
+	referenced := map[string]bool{}
+
+	// the blobs of a manifest that can not be read may still be in use so
+	// the store is treated as corrupt and no blobs are removed
+	var unreadable error
+
+	err := filepath.WalkDir(manifests, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isTempFile(path) {
+			return err
+		}
+
+		blobs, err := ollamaBlobs(path, blobsFolder)
+		if err != nil {
+			unreadable = types.Errorf(types.ErrorKindCorrupt, "unable to read manifest %s, run kapsule fsck to repair the store: %w", path, err)
+			return filepath.SkipAll
+		}
+
+		for _, b := range blobs {
+			referenced[b] = true
+		}
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, types.Errorf(types.ErrorKindIO, "unable to read manifests: %w", err)
+	}
+
+	if unreadable != nil {
+		return nil, unreadable
+	}
+
+	return referenced, nil
+}
+
+// ollamaBlobs returns the paths of the config and layers of the manifest
+func ollamaBlobs(manifestPath, blobsFolder string) ([]string, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mf, err := v1.ParseManifest(f)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := []string{blobName(blobsFolder, mf.Config.Digest)}
+	for _, l := range mf.Layers {
+		blobs = append(blobs, blobName(blobsFolder, l.Digest))
+	}
+
+	return blobs, nil
+}
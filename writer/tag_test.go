@@ -0,0 +1,227 @@
+package writer
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/nicholasjackson/kapsule/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func readIndex(t *testing.T, o string) *v1.IndexManifest {
+	p, err := layout.FromPath(o)
+	require.NoError(t, err)
+
+	idx, err := p.ImageIndex()
+	require.NoError(t, err)
+
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	return im
+}
+
+func TestPathTagAddsNameForExistingManifest(t *testing.T) {
+	pw, _, o, _ := setupPathFileKp(t, "test")
+
+	i, err := random.Image(100, 2)
+	require.NoError(t, err)
+
+	require.NoError(t, pw.Write(context.Background(), i, "test:v1", false, false))
+
+	err = pw.Tag(context.Background(), "v1", "test:latest")
+	require.NoError(t, err)
+
+	im := readIndex(t, o)
+	require.Len(t, im.Manifests, 2)
+	require.Equal(t, im.Manifests[0].Digest, im.Manifests[1].Digest)
+	require.Equal(t, "kapsule.io/library/test:latest", im.Manifests[1].Annotations[ocispec.AnnotationRefName])
+}
+
+func TestPathTagReplacesImageAndRemovesItsBlobs(t *testing.T) {
+	pw, _, o, _ := setupPathFileKp(t, "test")
+
+	first, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	second, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	require.NoError(t, pw.Write(context.Background(), first, "test:v1", false, false))
+	require.NoError(t, pw.Write(context.Background(), second, "test:v2", false, false))
+
+	err = pw.Tag(context.Background(), "test:v2", "test:v1")
+	require.NoError(t, err)
+
+	im := readIndex(t, o)
+	require.Len(t, im.Manifests, 2)
+
+	sd, _ := second.Digest()
+	for _, m := range im.Manifests {
+		require.Equal(t, sd, m.Digest)
+	}
+
+	fd, _ := first.Digest()
+	require.NoFileExists(t, path.Join(o, "blobs", fd.Algorithm, fd.Hex))
+}
+
+func TestPathTagReturnsNotFoundForMissingImage(t *testing.T) {
+	pw, _, _, i := setupPathFileKp(t, "test")
+
+	require.NoError(t, pw.Write(context.Background(), i, "test:v1", false, false))
+
+	err := pw.Tag(context.Background(), "test:v2", "test:v3")
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindNotFound, types.ErrorKindOf(err))
+}
+
+func TestPathRemoveKeepsBlobsSharedWithOtherNames(t *testing.T) {
+	pw, _, o, _ := setupPathFileKp(t, "test")
+
+	i, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	require.NoError(t, pw.Write(context.Background(), i, "test:v1", false, false))
+	require.NoError(t, pw.Tag(context.Background(), "test:v1", "test:v2"))
+
+	err = pw.Remove(context.Background(), "test:v1")
+	require.NoError(t, err)
+
+	im := readIndex(t, o)
+	require.Len(t, im.Manifests, 1)
+	require.Equal(t, "kapsule.io/library/test:v2", im.Manifests[0].Annotations[ocispec.AnnotationRefName])
+
+	d, _ := i.Digest()
+	require.FileExists(t, path.Join(o, "blobs", d.Algorithm, d.Hex))
+}
+
+func TestPathRemoveByDigestRemovesAllNamesAndBlobs(t *testing.T) {
+	pw, _, o, _ := setupPathFileKp(t, "test")
+
+	i, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	other, err := random.Image(100, 1)
+	require.NoError(t, err)
+
+	require.NoError(t, pw.Write(context.Background(), i, "test:v1", false, false))
+	require.NoError(t, pw.Write(context.Background(), other, "test:other", false, false))
+	require.NoError(t, pw.Tag(context.Background(), "test:v1", "test:v2"))
+
+	d, _ := i.Digest()
+
+	err = pw.Remove(context.Background(), d.String())
+	require.NoError(t, err)
+
+	im := readIndex(t, o)
+	require.Len(t, im.Manifests, 1)
+	require.Equal(t, "kapsule.io/library/test:other", im.Manifests[0].Annotations[ocispec.AnnotationRefName])
+
+	require.NoFileExists(t, path.Join(o, "blobs", d.Algorithm, d.Hex))
+
+	ls, _ := i.Layers()
+	ld, _ := ls[0].Digest()
+	require.NoFileExists(t, path.Join(o, "blobs", ld.Algorithm, ld.Hex))
+}
+
+func TestOllamaTagCopiesManifest(t *testing.T) {
+	w, _, o, i := setupOllama(t)
+
+	require.NoError(t, w.Write(context.Background(), i, "test:v1", false, true))
+
+	err := w.Tag(context.Background(), "test:v1", "other:latest")
+	require.NoError(t, err)
+
+	src, err := os.ReadFile(path.Join(o, "manifests", "kapsule.io", "library", "test", "v1"))
+	require.NoError(t, err)
+
+	dst, err := os.ReadFile(path.Join(o, "manifests", "kapsule.io", "library", "other", "latest"))
+	require.NoError(t, err)
+	require.Equal(t, src, dst)
+}
+
+func TestOllamaRemoveDeletesBlobsNotSharedWithOtherModels(t *testing.T) {
+	w, _, o, _ := setupOllama(t)
+
+	shared, err := random.Layer(1024, "")
+	require.NoError(t, err)
+
+	only, err := random.Layer(1024, "")
+	require.NoError(t, err)
+
+	keep, err := mutate.AppendLayers(empty.Image, shared)
+	require.NoError(t, err)
+
+	remove, err := mutate.AppendLayers(empty.Image, shared, only)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(context.Background(), keep, "keep:v1", false, true))
+	require.NoError(t, w.Write(context.Background(), remove, "remove:v1", false, true))
+
+	blobs, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+	require.Len(t, blobs, 4)
+
+	err = w.Remove(context.Background(), "remove:v1")
+	require.NoError(t, err)
+
+	require.NoDirExists(t, path.Join(o, "manifests", "kapsule.io", "library", "remove"))
+	require.FileExists(t, path.Join(o, "manifests", "kapsule.io", "library", "keep", "v1"))
+
+	// the config and the shared layer of keep:v1 are kept
+	blobs, err = os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+
+	od, err := only.DiffID()
+	require.NoError(t, err)
+	require.NoFileExists(t, blobName(path.Join(o, "blobs"), od))
+
+	problems, err := w.Check(context.Background(), false)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
+
+func TestOllamaRemoveKeepsModelWhenAnotherManifestIsUnreadable(t *testing.T) {
+	w, _, o, i := setupOllama(t)
+
+	require.NoError(t, w.Write(context.Background(), i, "remove:v1", false, true))
+
+	// a manifest that can not be parsed may reference the same blobs
+	bad := path.Join(o, "manifests", "kapsule.io", "library", "bad", "v1")
+	require.NoError(t, os.MkdirAll(path.Dir(bad), os.ModePerm))
+	require.NoError(t, os.WriteFile(bad, []byte("not a manifest"), os.ModePerm))
+
+	before, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+
+	err = w.Remove(context.Background(), "remove:v1")
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindCorrupt, types.ErrorKindOf(err))
+
+	require.FileExists(t, path.Join(o, "manifests", "kapsule.io", "library", "remove", "v1"))
+
+	after, err := os.ReadDir(path.Join(o, "blobs"))
+	require.NoError(t, err)
+	require.Len(t, after, len(before))
+
+	err = w.Tag(context.Background(), "remove:v1", "remove:latest")
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindCorrupt, types.ErrorKindOf(err))
+}
+
+func TestOllamaRemoveReturnsNotFoundForMissingModel(t *testing.T) {
+	w, _, _, _ := setupOllama(t)
+
+	err := w.Remove(context.Background(), "missing:v1")
+	require.Error(t, err)
+	require.Equal(t, types.ErrorKindNotFound, types.ErrorKindOf(err))
+}